@@ -0,0 +1,408 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+//Request is a flex call-out as the shim got it from kubelet, e.g {"command": "mount", "args": ["<mount dir>", "<json options>"]}
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+//CleanupDryRunArg is the argument of the cleanup command that only reports the orphans
+const CleanupDryRunArg = "--dry-run"
+
+//Agent is the long running flex node agent, it keeps one controller (and its ubiquity client and mounters) for all the call-outs of the node
+type Agent struct {
+	controller *controller.Controller
+	config     func() resources.UbiquityPluginConfig
+	logger     logs.Logger
+	lock       sync.Mutex
+	listener   net.Listener
+	stopped    bool
+	wg         sync.WaitGroup
+}
+
+//NewAgent allows to instantiate an agent on top of an existing controller, config returns the config in use
+func NewAgent(controller *controller.Controller, config func() resources.UbiquityPluginConfig) *Agent {
+	return &Agent{controller: controller, config: config, logger: logs.GetLogger()}
+}
+
+//Run serves the flex call-outs on the given unix socket until Stop is called
+func (a *Agent) Run(socketPath string) error {
+	defer a.logger.Trace(logs.INFO)()
+
+	// a socket left behind by a previous agent makes listen fail
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return a.logger.ErrorRet(err, "failed to remove stale socket", logs.Args{{"socket", socketPath}})
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return a.logger.ErrorRet(err, "net.Listen failed", logs.Args{{"socket", socketPath}})
+	}
+	// only root (kubelet) may call the agent
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return a.logger.ErrorRet(err, "os.Chmod failed", logs.Args{{"socket", socketPath}})
+	}
+	a.lock.Lock()
+	if a.stopped {
+		a.lock.Unlock()
+		return listener.Close()
+	}
+	a.listener = listener
+	a.lock.Unlock()
+	a.logger.Info("Flex agent listening", logs.Args{{"socket", socketPath}})
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if a.isStopped() {
+				break
+			}
+			a.logger.Error("listener.Accept failed", logs.Args{{"error", err}})
+			continue
+		}
+		a.wg.Add(1)
+		go a.serve(conn)
+	}
+
+	// let the call-outs in flight finish, kubelet would see a broken response otherwise
+	a.wg.Wait()
+	return nil
+}
+
+//Stop stops accepting call-outs, Run returns once the pending ones are done
+func (a *Agent) Stop() {
+	defer a.logger.Trace(logs.INFO)()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.stopped = true
+	if a.listener != nil {
+		a.listener.Close()
+	}
+}
+
+func (a *Agent) isStopped() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.stopped
+}
+
+func (a *Agent) serve(conn net.Conn) {
+	defer a.wg.Done()
+	defer conn.Close()
+
+	var request Request
+	var response k8sresources.FlexVolumeResponse
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response = k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to decode the flex agent request %v", err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	} else {
+		response = a.Handle(request)
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		a.logger.Error("failed to send the response", logs.Args{{"request", request}, {"response", response}, {"error", err}})
+	}
+}
+
+//Handle parses the call-out arguments the way kubelet passes them and runs the matching controller method
+func (a *Agent) Handle(request Request) k8sresources.FlexVolumeResponse {
+	defer a.logger.Trace(logs.DEBUG)()
+	args := request.Args
+
+	switch request.Command {
+	case "getvolumename":
+		return a.getVolumeName(args)
+	case "attach":
+		return a.attach(args)
+	case "waitforattach":
+		return a.waitForAttach(args)
+	case "isattached":
+		return a.isAttached(args)
+	case "detach":
+		return a.detach(args)
+	case "mountdevice":
+		return a.mountDevice(args)
+	case "unmountdevice":
+		return a.unmountDevice(args)
+	case "mount":
+		return a.mount(args)
+	case "unmount":
+		return a.unmount(args)
+	case "cleanup":
+		return a.cleanup(args)
+	case "testubiquity":
+		return a.controller.TestUbiquity(a.config())
+	default:
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Not supported",
+			Message: fmt.Sprintf("Unknown call out %s", request.Command),
+		}
+	}
+}
+
+//<driver executable> getvolumename <json options> (v>=1.6)
+func (a *Agent) getVolumeName(args []string) k8sresources.FlexVolumeResponse {
+	if len(args) < 1 {
+		return notEnoughArguments("getVolumeName")
+	}
+	opts, err := unmarshalOpts(args[0])
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to unmarshall request in getVolumeName %v", err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: opts}
+	return a.controller.GetVolumeName(getVolumeNameRequest)
+}
+
+//<driver executable> attach <json options> <node name> (v=1.5 with json options, v >= 1.6 json options and node name)
+func (a *Agent) attach(args []string) k8sresources.FlexVolumeResponse {
+	var version string
+	var hostname string
+	if len(args) < 1 {
+		return notEnoughArguments("attach")
+	}
+	if len(args) == 1 {
+		version = k8sresources.KubernetesVersion_1_5
+	} else {
+		hostname = args[1]
+		version = k8sresources.KubernetesVersion_1_6OrLater
+	}
+	attachRequestOpts, err := unmarshalOpts(args[0])
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to unmarshall request in attach volume %v", err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	volumeName, ok := attachRequestOpts["volumeName"]
+	if !ok {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("volumeName is mandatory for attach %v", attachRequestOpts),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	attachRequest := k8sresources.FlexVolumeAttachRequest{Name: volumeName, Host: hostname, Opts: attachRequestOpts, Version: version}
+	return a.controller.Attach(attachRequest)
+}
+
+//<driver executable> waitforattach <mount device> <json options> (v >= 1.6)
+func (a *Agent) waitForAttach(args []string) k8sresources.FlexVolumeResponse {
+	if len(args) < 2 {
+		return notEnoughArguments("waitForAttach")
+	}
+	opts, err := unmarshalOpts(args[1])
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to marshall args in waitForAttach %v", err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: args[0], Opts: opts}
+	return a.controller.WaitForAttach(waitForAttachRequest)
+}
+
+//<driver executable> isattached <json options> <node name> (v >= 1.6)
+func (a *Agent) isAttached(args []string) k8sresources.FlexVolumeResponse {
+	if len(args) < 2 {
+		return notEnoughArguments("isAttached")
+	}
+	opts, err := unmarshalOpts(args[0])
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to marshall args in isAttached %v", err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	isAttachedRequest := k8sresources.FlexVolumeIsAttachedRequest{Opts: opts, Host: args[1]}
+	return a.controller.IsAttached(isAttachedRequest)
+}
+
+//<driver executable> detach <mount device> <node name> (v=1.5 with mount device, v >= 1.6 mount device and node name)
+func (a *Agent) detach(args []string) k8sresources.FlexVolumeResponse {
+	var hostname string
+	var version string
+	if len(args) < 1 {
+		return notEnoughArguments("detach")
+	}
+	mountDevice := args[0]
+	if len(args) == 1 {
+		version = k8sresources.KubernetesVersion_1_5
+	} else {
+		hostname = args[1]
+		version = k8sresources.KubernetesVersion_1_6OrLater
+	}
+	detachRequest := k8sresources.FlexVolumeDetachRequest{Name: mountDevice, Host: hostname, Version: version}
+	return a.controller.Detach(detachRequest)
+}
+
+//<driver executable> mountdevice <mount dir> <mount device> <json options> (v >= 1.6)
+func (a *Agent) mountDevice(args []string) k8sresources.FlexVolumeResponse {
+	if len(args) < 3 {
+		return notEnoughArguments("mountDevice")
+	}
+	opts, err := unmarshalOpts(args[2])
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to marshall args in MountDevice %v", err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	mountDeviceRequest := k8sresources.FlexVolumeMountDeviceRequest{Path: args[0], Name: args[1], Opts: opts}
+	return a.controller.MountDevice(mountDeviceRequest)
+}
+
+//<driver executable> unmountdevice <mount device> (v >= 1.6)
+func (a *Agent) unmountDevice(args []string) k8sresources.FlexVolumeResponse {
+	if len(args) < 1 {
+		return notEnoughArguments("unmountDevice")
+	}
+	unmountDeviceRequest := k8sresources.FlexVolumeUnmountDeviceRequest{Name: args[0]}
+	return a.controller.UnmountDevice(unmountDeviceRequest)
+}
+
+//<driver executable> mount <mount dir> <mountDevice> <json options> (v>=1.5)
+//<driver executable> mount <mount dir> <json options> (v>=1.6)
+func (a *Agent) mount(args []string) k8sresources.FlexVolumeResponse {
+	var volumeName string
+	var mountOptsIndex int
+	var version string
+
+	if len(args) < 2 {
+		return notEnoughArguments("mount")
+	}
+	targetMountDir := args[0]
+	// kubernetes version 1.5
+	if len(args) == 3 {
+		volumeName = args[1]
+		mountOptsIndex = 2
+		version = k8sresources.KubernetesVersion_1_5
+	} else /*kubernetes version 1.6*/ {
+		mountOptsIndex = 1
+		version = k8sresources.KubernetesVersion_1_6OrLater
+	}
+
+	mountOpts, err := unmarshalOpts(args[mountOptsIndex])
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to mount device to %s due to: %v", targetMountDir, err),
+			Code:    k8sresources.ErrorCodeInvalidRequest,
+		}
+	}
+	if volumeName == "" {
+		var ok bool
+		volumeName, ok = mountOpts["volumeName"]
+		if !ok {
+			return k8sresources.FlexVolumeResponse{
+				Status:  "Failure",
+				Message: fmt.Sprintf("Failed to get volumeName in opts: %v", mountOpts),
+				Code:    k8sresources.ErrorCodeInvalidRequest,
+			}
+		}
+	}
+
+	mountRequest := k8sresources.FlexVolumeMountRequest{
+		MountPath:   targetMountDir,
+		MountDevice: volumeName,
+		Opts:        mountOpts,
+		Version:     version,
+	}
+	return a.controller.Mount(mountRequest)
+}
+
+//<driver executable> unmount <mount dir> (v>=1.5)
+func (a *Agent) unmount(args []string) k8sresources.FlexVolumeResponse {
+	if len(args) < 1 {
+		return notEnoughArguments("unmount")
+	}
+	unmountRequest := k8sresources.FlexVolumeUnmountRequest{
+		MountPath: args[0],
+	}
+	return a.controller.Unmount(unmountRequest)
+}
+
+//<driver executable> cleanup [--dry-run]
+func (a *Agent) cleanup(args []string) k8sresources.FlexVolumeResponse {
+	dryRun := false
+	for _, arg := range args {
+		if arg != CleanupDryRunArg {
+			return k8sresources.FlexVolumeResponse{
+				Status:  "Failure",
+				Message: fmt.Sprintf("Unknown argument %s of cleanup call out", arg),
+				Code:    k8sresources.ErrorCodeInvalidRequest,
+			}
+		}
+		dryRun = true
+	}
+	return a.controller.CleanupOrphans(dryRun)
+}
+
+//RunOrphanCleanup cleans up the orphan mounts of the node every interval until stopCh is closed
+func (a *Agent) RunOrphanCleanup(interval time.Duration, dryRun bool, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			// the controller logs the result
+			a.controller.CleanupOrphans(dryRun)
+		}
+	}
+}
+
+func notEnoughArguments(callOut string) k8sresources.FlexVolumeResponse {
+	return k8sresources.FlexVolumeResponse{
+		Status:  "Failure",
+		Message: fmt.Sprintf("Not enough arguments to %s call out", callOut),
+		Code:    k8sresources.ErrorCodeInvalidRequest,
+	}
+}
+
+func unmarshalOpts(jsonOpts string) (map[string]string, error) {
+	opts := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonOpts), &opts)
+	return opts, err
+}
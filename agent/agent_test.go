@@ -0,0 +1,105 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/IBM/ubiquity-k8s/agent"
+	ctl "github.com/IBM/ubiquity-k8s/controller"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Agent", func() {
+
+	var (
+		fakeClient *fakes.FakeStorageClient
+		fakeExec   *fakes.FakeExecutor
+		flexAgent  *agent.Agent
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeExec = new(fakes.FakeExecutor)
+		controller := ctl.NewControllerWithClient(testLogger, fakeClient, fakeExec)
+		flexAgent = agent.NewAgent(controller, func() resources.UbiquityPluginConfig { return resources.UbiquityPluginConfig{} })
+	})
+
+	Context(".Handle", func() {
+		It("fails when the call-out has not enough arguments", func() {
+			response := flexAgent.Handle(agent.Request{Command: "mountdevice", Args: []string{"/tmp/test/globalmount/pv1"}})
+			Expect(response.Status).To(Equal("Failure"))
+			Expect(response.Message).To(Equal("Not enough arguments to mountDevice call out"))
+		})
+		It("fails when the json options cannot be parsed", func() {
+			response := flexAgent.Handle(agent.Request{Command: "getvolumename", Args: []string{"{"}})
+			Expect(response.Status).To(Equal("Failure"))
+			Expect(fakeClient.GetVolumeCallCount()).To(Equal(0))
+		})
+		It("runs the controller method of the call-out", func() {
+			response := flexAgent.Handle(agent.Request{Command: "getvolumename", Args: []string{`{"volumeName": "pv1", "backend": "softlayer-nfs"}`}})
+			Expect(response.Status).To(Equal("Success"))
+			Expect(response.VolumeName).To(Equal("softlayer-nfs_pv1"))
+		})
+		It("fails on an unknown argument of cleanup", func() {
+			response := flexAgent.Handle(agent.Request{Command: "cleanup", Args: []string{"--force"}})
+			Expect(response.Status).To(Equal("Failure"))
+			Expect(response.Message).To(Equal("Unknown argument --force of cleanup call out"))
+			Expect(fakeClient.ListVolumesCallCount()).To(Equal(0))
+		})
+		It("does not support unknown call-outs", func() {
+			response := flexAgent.Handle(agent.Request{Command: "expandvolume"})
+			Expect(response.Status).To(Equal("Not supported"))
+		})
+	})
+
+	Context(".Run", func() {
+		var socketPath string
+		BeforeEach(func() {
+			os.MkdirAll("/tmp/test/agent", 0777)
+			socketPath = filepath.Join("/tmp/test/agent", "agent.sock")
+		})
+
+		It("serves the call-outs forwarded on the socket", func() {
+			done := make(chan error)
+			go func() {
+				done <- flexAgent.Run(socketPath)
+			}()
+			Eventually(func() error {
+				_, err := os.Stat(socketPath)
+				return err
+			}).ShouldNot(HaveOccurred())
+
+			response, err := agent.Forward(socketPath, agent.Request{Command: "getvolumename", Args: []string{`{"volumeName": "pv1", "backend": "spectrum-scale"}`}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Status).To(Equal("Success"))
+			Expect(response.VolumeName).To(Equal("spectrum-scale_pv1"))
+
+			flexAgent.Stop()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+		It("reports the agent as unavailable when nothing listens on the socket", func() {
+			os.Remove(socketPath)
+			_, err := agent.Forward(socketPath, agent.Request{Command: "unmount", Args: []string{"/tmp/test/pod1/pv1"}})
+			Expect(err).To(BeAssignableToTypeOf(&agent.AgentUnavailableError{}))
+		})
+	})
+})
@@ -0,0 +1,54 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+)
+
+//AgentUnavailableError is returned by Forward when no agent listens on the socket, the call-out was not sent and can be handled in process
+type AgentUnavailableError struct {
+	Err error
+}
+
+func (e *AgentUnavailableError) Error() string {
+	return fmt.Sprintf("flex agent is not available: %v", e.Err)
+}
+
+//Forward sends the call-out to the agent listening on socketPath and returns its response
+func Forward(socketPath string, request Request) (k8sresources.FlexVolumeResponse, error) {
+	var response k8sresources.FlexVolumeResponse
+
+	// no timeout, kubelet kills the call-out once its own timeout expires
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return response, &AgentUnavailableError{Err: err}
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return response, fmt.Errorf("Failed to send the request to the flex agent: %v", err)
+	}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return response, fmt.Errorf("Failed to read the response of the flex agent: %v", err)
+	}
+	return response, nil
+}
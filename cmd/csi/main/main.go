@@ -0,0 +1,80 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/IBM/ubiquity-k8s/csi"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/utils"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+var (
+	endpoint           = flag.String("endpoint", k8sresources.CsiDefaultEndpoint, "CSI endpoint")
+	nodeID             = flag.String("nodeid", "", "Node id, defaults to the hostname")
+	ubiquityConfigFile = flag.String("config", os.Getenv("UBIQUITY_CONFIG"), "TOML or YAML file of the ubiquity config, the environment variables override it")
+)
+
+func main() {
+	flag.Parse()
+
+	ubiquityConfig, err := k8sutils.LoadConfigFile(*ubiquityConfigFile)
+	if err != nil {
+		panic(fmt.Errorf("Failed to load config: %v", err))
+	}
+	var csiConfig k8sresources.CsiConfig
+	if *ubiquityConfigFile != "" {
+		if err := k8sutils.DecodeConfigFile(*ubiquityConfigFile, &csiConfig); err != nil {
+			panic(fmt.Errorf("Failed to load config: %v", err))
+		}
+	}
+
+	err = os.MkdirAll(ubiquityConfig.LogPath, 0640)
+	if err != nil {
+		panic(fmt.Errorf("Failed to setup log dir"))
+	}
+
+	defer logs.InitStdoutLogger(logs.GetLogLevelFromString(ubiquityConfig.LogLevel))()
+	logger := utils.SetupOldLogger(k8sresources.UbiquityK8sCsiDriverName)
+
+	if *nodeID == "" {
+		*nodeID, err = os.Hostname()
+		if err != nil {
+			panic(fmt.Sprintf("Failed to get hostname: %v", err))
+		}
+	}
+
+	ubiquityConfigCopyWithPasswordStarred := ubiquityConfig
+	ubiquityConfigCopyWithPasswordStarred.CredentialInfo.Password = "****"
+	logger.Printf("starting the CSI driver on %s, node %s, config %#v", *endpoint, *nodeID, ubiquityConfigCopyWithPasswordStarred)
+	driver, err := csi.NewDriver(logger, ubiquityConfig, csiConfig.ClientRetry, *nodeID)
+	if err != nil {
+		logger.Printf("Error getting remote Client: %v", err)
+		panic("Error getting remote client")
+	}
+
+	err = driver.Run(*endpoint)
+	if err != nil {
+		logger.Printf("CSI driver stopped: %v", err)
+		panic("CSI driver stopped")
+	}
+}
@@ -20,25 +20,31 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
-	"github.com/BurntSushi/toml"
+	"github.com/IBM/ubiquity-k8s/agent"
 	"github.com/IBM/ubiquity-k8s/controller"
+	"github.com/IBM/ubiquity-k8s/events"
+	"github.com/IBM/ubiquity-k8s/metrics"
 	flags "github.com/jessevdk/go-flags"
 
+	k8sremote "github.com/IBM/ubiquity-k8s/remote"
 	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
 	"github.com/IBM/ubiquity/resources"
-	"github.com/IBM/ubiquity/remote"
 	"github.com/IBM/ubiquity/utils"
 	"github.com/IBM/ubiquity/utils/logs"
-	"strconv"
 )
 
 var configFile = flag.String(
 	"configFile",
 	k8sresources.FlexConfPath,
-	"Flex Volume configuration file",
+	"Flex Volume configuration file, TOML or YAML (.yml or .yaml)",
 )
 
 // All the method should printout as response:
@@ -47,7 +53,8 @@ var configFile = flag.String(
 //"message": "<Reason for success/failure>",
 //"device": "Path to the device attached. valid only for attach & waitforattach call-outs”
 //"volumeName": "Cluster wide unique name of the volume”
-//"attached": True/False}
+//"attached": True/False
+//"code": "<InvalidRequest/BackendNotFound/VolumeNotFound/AttachedElsewhere/Timeout/Transient>, optional classification of a failure"}
 
 //InitCommand initializes the plugin
 //<driver executable> init (v>=1.5)
@@ -71,11 +78,7 @@ type GetVolumeNameCommand struct {
 }
 
 func (g *GetVolumeNameCommand) Execute(args []string) error {
-	// This GetVolumeName action in FlexVolume CLI is not relevant, we can just return not supported without logging anything.
-	response := k8sresources.FlexVolumeResponse{
-		Status: "Not supported",
-	}
-	return printResponse(response)
+	return runCallOut("getvolumename", args)
 }
 
 //AttachCommand attaches a volume to a node
@@ -86,59 +89,7 @@ type AttachCommand struct {
 }
 
 func (a *AttachCommand) Execute(args []string) error {
-	var version string
-	var hostname string
-	if len(args) < 1 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to attach call out"),
-		}
-		return printResponse(response)
-	}
-	if len(args) == 1 {
-		version = k8sresources.KubernetesVersion_1_5
-	} else {
-		hostname = args[1]
-		version = k8sresources.KubernetesVersion_1_6OrLater
-	}
-	attachRequestOpts := make(map[string]string)
-	err := json.Unmarshal([]byte(args[0]), &attachRequestOpts)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to unmarshall request in attach volume %#v", err),
-		}
-		return printResponse(response)
-	}
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in attach volume %#v", err),
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-
-	if err != nil {
-		panic(fmt.Sprintf("backend %s not found", config))
-	}
-
-	volumeName, ok := attachRequestOpts["volumeName"]
-	if !ok {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("volumeName is mandatory for attach %#v", attachRequestOpts),
-		}
-		return printResponse(response)
-	}
-
-	attachRequest := k8sresources.FlexVolumeAttachRequest{Name: volumeName, Host: hostname, Opts: attachRequestOpts, Version: version}
-
-	attachResponse := controller.Attach(attachRequest)
-	return printResponse(attachResponse)
+	return runCallOut("attach", args)
 }
 
 //WaitForAttach the volume to be attached on the node
@@ -148,36 +99,7 @@ type WaitForAttachCommand struct {
 }
 
 func (wfa *WaitForAttachCommand) Execute(args []string) error {
-	if len(args) < 2 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to waitForAttach call out"),
-		}
-		return printResponse(response)
-	}
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in waitForAttach volume %#v", err),
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-	opts := make(map[string]string)
-	err = json.Unmarshal([]byte(args[1]), &opts)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to marshall args in waitForAttach %#v", err),
-		}
-		return printResponse(response)
-	}
-	waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: args[0], Opts: opts}
-	response := controller.WaitForAttach(waitForAttachRequest)
-	return printResponse(response)
+	return runCallOut("waitforattach", args)
 }
 
 //IsAttachedCommand Checks if the volume is attached to the node
@@ -187,36 +109,7 @@ type IsAttachedCommand struct {
 }
 
 func (d *IsAttachedCommand) Execute(args []string) error {
-	if len(args) < 2 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to isAttached call out"),
-		}
-		return printResponse(response)
-	}
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in isAttached volume %#v", err),
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-	opts := make(map[string]string)
-	err = json.Unmarshal([]byte(args[0]), &opts)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to marshall args in isAttached %#v", err),
-		}
-		return printResponse(response)
-	}
-	isAttachedRequest := k8sresources.FlexVolumeIsAttachedRequest{Opts: opts, Host: args[1]}
-	response := controller.IsAttached(isAttachedRequest)
-	return printResponse(response)
+	return runCallOut("isattached", args)
 }
 
 //DetachCommand detaches a volume from a given node
@@ -227,42 +120,7 @@ type DetachCommand struct {
 }
 
 func (d *DetachCommand) Execute(args []string) error {
-	var hostname string
-	var version string
-	if len(args) < 1 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to getVolumeName call out"),
-		}
-		return printResponse(response)
-	}
-	mountDevice := args[0]
-	if len(args) == 1 {
-		version = k8sresources.KubernetesVersion_1_5
-	} else {
-		hostname = args[1]
-		version = k8sresources.KubernetesVersion_1_6OrLater
-	}
-
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in detach %#v", err),
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-
-	if err != nil {
-		panic("backend not found")
-	}
-
-	detachRequest := k8sresources.FlexVolumeDetachRequest{Name: mountDevice, Host: hostname, Version: version}
-	detachResponse := controller.Detach(detachRequest)
-	return printResponse(detachResponse)
+	return runCallOut("detach", args)
 }
 
 //MountDevice Mounts the device to a global path which individual pods can then bind mount
@@ -272,36 +130,7 @@ type MountDeviceCommand struct {
 }
 
 func (d *MountDeviceCommand) Execute(args []string) error {
-	if len(args) < 3 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to mountDevice call out"),
-		}
-		return printResponse(response)
-	}
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in MountDevice  %#v", err),
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-	opts := make(map[string]string)
-	err = json.Unmarshal([]byte(args[2]), &opts)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to marshall args in MountDevice %#v", err),
-		}
-		return printResponse(response)
-	}
-	mountDeviceRequest := k8sresources.FlexVolumeMountDeviceRequest{Path: args[0], Name: args[1], Opts: opts}
-	response := controller.MountDevice(mountDeviceRequest)
-	return printResponse(response)
+	return runCallOut("mountdevice", args)
 }
 
 //UnmountDevice	Unmounts the global mount for the device. This is called once all bind mounts have been unmounted
@@ -311,28 +140,7 @@ type UnmountDeviceCommand struct {
 }
 
 func (d *UnmountDeviceCommand) Execute(args []string) error {
-	if len(args) < 1 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to unmountDevice call out"),
-		}
-		return printResponse(response)
-	}
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in UnmountDevice  %#v", err),
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-
-	unmountDeviceRequest := k8sresources.FlexVolumeUnmountDeviceRequest{Name: args[0]}
-	response := controller.UnmountDevice(unmountDeviceRequest)
-	return printResponse(response)
+	return runCallOut("unmountdevice", args)
 }
 
 //MountCommand mounts a given volume to a given mountpoint
@@ -343,79 +151,7 @@ type MountCommand struct {
 }
 
 func (m *MountCommand) Execute(args []string) error {
-	var volumeName string
-	var mountOpts map[string]string
-	var mountOptsIndex int
-	var ok bool
-	var version string
-
-	//should error out when not enough args
-	if len(args) < 2 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to mount call out"),
-		}
-		return printResponse(response)
-	}
-	targetMountDir := args[0]
-	// kubernetes version 1.5
-	if len(args) == 3 {
-		volumeName = args[1]
-		mountOptsIndex = 2
-		version = k8sresources.KubernetesVersion_1_5
-
-	} else /*kubernetes version 1.6*/ {
-		mountOptsIndex = 1
-		version = k8sresources.KubernetesVersion_1_6OrLater
-	}
-
-	err := json.Unmarshal([]byte(args[mountOptsIndex]), &mountOpts)
-
-	if err != nil {
-		mountResponse := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to mount device %s to %s due to: %#v", targetMountDir, err),
-		}
-		return printResponse(mountResponse)
-	}
-
-	if volumeName == "" {
-		volumeName, ok = mountOpts["volumeName"]
-		if !ok {
-			mountResponse := k8sresources.FlexVolumeResponse{
-				Status:  "Failure",
-				Message: fmt.Sprintf("Failed to get volumeName in opts: %#v", mountOpts),
-			}
-			return printResponse(mountResponse)
-
-		}
-	}
-
-	mountRequest := k8sresources.FlexVolumeMountRequest{
-		MountPath:   targetMountDir,
-		MountDevice: volumeName,
-		Opts:        mountOpts,
-		Version:     version,
-	}
-
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in mount %#v", err),
-		}
-		return printResponse(response)
-	}
-
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
-
-	if err != nil {
-		panic("backend not found")
-	}
-	mountResponse := controller.Mount(mountRequest)
-	return printResponse(mountResponse)
+	return runCallOut("mount", args)
 }
 
 //UnmountCommand unmounts a given mountedDirectory
@@ -425,36 +161,20 @@ type UnmountCommand struct {
 }
 
 func (u *UnmountCommand) Execute(args []string) error {
-	if len(args) < 1 {
-
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Not enough arguments to unmount call out"),
-		}
-		return printResponse(response)
-	}
-	mountDir := args[0]
-	config, err := readConfig(*configFile)
-	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in Unmount %#v", err),
-			Device:  "",
-		}
-		return printResponse(response)
-	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
+	return runCallOut("unmount", args)
+}
 
-	if err != nil {
-		panic("backend not found")
-	}
+//CleanupCommand removes the pod symlinks, the mounts and the multipath devices the unmount flows left behind on the node
+//<driver executable> cleanup [--dry-run]
+type CleanupCommand struct {
+	DryRun bool `long:"dry-run" description:"Only report what would be removed"`
+}
 
-	unmountRequest := k8sresources.FlexVolumeUnmountRequest{
-		MountPath: mountDir,
+func (c *CleanupCommand) Execute(args []string) error {
+	if c.DryRun {
+		args = append(args, agent.CleanupDryRunArg)
 	}
-	unmountResponse := controller.Unmount(unmountRequest)
-	return printResponse(unmountResponse)
+	return runCallOut("cleanup", args)
 }
 
 type TestUbiquityCommand struct {
@@ -462,25 +182,110 @@ type TestUbiquityCommand struct {
 }
 
 func (i *TestUbiquityCommand) Execute(args []string) error {
-	config, err := readConfig(*configFile)
+	return runCallOut("testubiquity", args)
+}
+
+//AgentCommand runs the long running flex agent of the node, the other call-outs are forwarded to it while it runs
+//<driver executable> agent
+type AgentCommand struct {
+	Agent func() `long:"agent" description:"Run the flex agent"`
+}
+
+func (a *AgentCommand) Execute(args []string) error {
+	config, err := k8sutils.LoadConfigFile(*configFile)
 	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to read config in Test Ubiquity %#v", err),
-		}
-		return printResponse(response)
+		return err
 	}
-	defer logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))()
-	controller, err := createController(config)
+	flexConfig, err := readFlexConfig(*configFile)
 	if err != nil {
-		response := k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Failed to create controller %#v", err),
-		}
-		return printResponse(response)
+		return err
 	}
-	response := controller.TestUbiquity(config)
-	return printResponse(response)
+	deinitLogger := initFlexLogger(config)
+	defer func() {
+		deinitLogger()
+	}()
+	logger := utils.SetupOldLogger(k8sresources.UbiquityFlexLogFileName)
+	remoteClient, err := k8sremote.NewRemoteClient(logger, config)
+	if err != nil {
+		return err
+	}
+	// the remote client is swapped when the server, the credentials or the SSL settings change
+	reloadableClient := k8sutils.NewReloadableStorageClient(remoteClient)
+	controller, err := newController(logger, reloadableClient, config)
+	if err != nil {
+		return err
+	}
+
+	// The controller keeps the rest of the config it was built with: the other changes apply at the next restart
+	logRestartChanges := func(changes []string) {
+		logs.GetLogger().Info("the flex agent applies these config changes at its next restart", logs.Args{{"changes", changes}})
+	}
+	reloadedFlexConfig := flexConfig
+	configWatcher := k8sutils.NewConfigWatcher(func() (resources.UbiquityPluginConfig, error) {
+		if newFlexConfig, err := readFlexConfig(*configFile); err == nil {
+			if changes := k8sutils.ValueChanges(reloadedFlexConfig, newFlexConfig); len(changes) > 0 {
+				logRestartChanges(changes)
+				reloadedFlexConfig = newFlexConfig
+			}
+		}
+		return k8sutils.LoadConfigFile(*configFile)
+	}, config)
+	configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+		if !k8sutils.ConnectionChanged(oldConfig, newConfig) {
+			return nil
+		}
+		client, err := k8sremote.NewRemoteClient(logger, newConfig)
+		if err != nil {
+			return err
+		}
+		reloadableClient.SetClient(client)
+		return nil
+	})
+	configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+		if newConfig.LogLevel != oldConfig.LogLevel || newConfig.LogPath != oldConfig.LogPath {
+			// the new log file is open before the previous one is closed
+			deinitPreviousLogger := deinitLogger
+			deinitLogger = initFlexLogger(newConfig)
+			deinitPreviousLogger()
+		}
+		newConfig.LogLevel = oldConfig.LogLevel
+		newConfig.LogPath = oldConfig.LogPath
+		if changes := k8sutils.ChangesBesidesConnection(oldConfig, newConfig); len(changes) > 0 {
+			logRestartChanges(changes)
+		}
+		return nil
+	})
+	stopWatcher := make(chan struct{})
+	watcherStopped := make(chan struct{})
+	go func() {
+		configWatcher.Run(k8sresources.FlexAgentConfigReloadIntervalSeconds*time.Second, stopWatcher)
+		close(watcherStopped)
+	}()
+	defer func() {
+		close(stopWatcher)
+		<-watcherStopped
+	}()
+
+	flexAgent := agent.NewAgent(controller, configWatcher.Config)
+	if interval := flexConfig.OrphanCleanup.IntervalSeconds; interval > 0 {
+		stopCleanup := make(chan struct{})
+		cleanupStopped := make(chan struct{})
+		go func() {
+			flexAgent.RunOrphanCleanup(time.Duration(interval)*time.Second, flexConfig.OrphanCleanup.DryRun, stopCleanup)
+			close(cleanupStopped)
+		}()
+		defer func() {
+			close(stopCleanup)
+			<-cleanupStopped
+		}()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		flexAgent.Stop()
+	}()
+	return flexAgent.Run(k8sresources.FlexAgentSocketPath)
 }
 
 type Options struct{}
@@ -497,6 +302,8 @@ func main() {
 	var mountDeviceCommand MountDeviceCommand
 	var unmountDeviceCommand UnmountDeviceCommand
 	var testUbiquityCommand TestUbiquityCommand
+	var agentCommand AgentCommand
+	var cleanupCommand CleanupCommand
 
 	var options Options
 	var parser = flags.NewParser(&options, flags.Default)
@@ -545,6 +352,14 @@ func main() {
 		"Tests connectivity to ubiquity",
 		"Tests connectivity to ubiquity",
 		&testUbiquityCommand)
+	parser.AddCommand("cleanup",
+		"Clean up the orphans",
+		"Removes the pod symlinks, the mounts and the multipath devices of the volumes no live pod uses",
+		&cleanupCommand)
+	parser.AddCommand("agent",
+		"Run the flex agent",
+		"Serves the flex call-outs of the node on a unix socket",
+		&agentCommand)
 
 	_, err := parser.Parse()
 	if err != nil {
@@ -553,23 +368,126 @@ func main() {
 	}
 }
 
+//runCallOut forwards the call-out to the flex agent of the node, or handles it in process when no agent runs
+func runCallOut(command string, args []string) error {
+	start := time.Now()
+	response := handleCallOut(command, args)
+	recordCallOut(command, response, time.Since(start))
+	return printResponse(response)
+}
+
+func handleCallOut(command string, args []string) k8sresources.FlexVolumeResponse {
+	request := agent.Request{Command: command, Args: args}
+	response, err := agent.Forward(k8sresources.FlexAgentSocketPath, request)
+	if err == nil {
+		return response
+	}
+	if _, ok := err.(*agent.AgentUnavailableError); !ok {
+		// the agent may have handled the call-out already, it must not run twice
+		return k8sresources.FlexVolumeResponse{
+			Status:    "Failure",
+			Message:   fmt.Sprintf("Failed to run %s in the flex agent: %v", command, err),
+			Code:      k8sresources.ErrorCodeTransient,
+			Retryable: true,
+		}
+	}
+
+	config, err := k8sutils.LoadConfigFile(*configFile)
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to read config in %s: %v", command, err),
+		}
+	}
+	defer initFlexLogger(config)()
+	controller, err := createController(config)
+	if err != nil {
+		return k8sresources.FlexVolumeResponse{
+			Status:  "Failure",
+			Message: fmt.Sprintf("Failed to create controller in %s: %v", command, err),
+		}
+	}
+	return agent.NewAgent(controller, func() resources.UbiquityPluginConfig { return config }).Handle(request)
+}
+
+// recordCallOut adds the call-out to the textfile of node_exporter, failing to record never fails the call-out
+func recordCallOut(command string, response k8sresources.FlexVolumeResponse, duration time.Duration) {
+	flexConfig, err := readFlexConfig(*configFile)
+	if err != nil {
+		return
+	}
+	textfileDir := flexConfig.Metrics.TextfileDir
+	if textfileDir == "" {
+		textfileDir = k8sresources.MetricsDefaultTextfileDir
+	}
+	if _, err := os.Stat(textfileDir); err != nil {
+		// node_exporter does not collect textfiles on this node
+		return
+	}
+	metrics.NewTextfileRecorder(textfileDir, os.TempDir()).Record(command, response.Status == "Success", duration)
+}
+
 func createController(config resources.UbiquityPluginConfig) (*controller.Controller, error) {
 	logger := utils.SetupOldLogger(k8sresources.UbiquityFlexLogFileName)
-	controller, err := controller.NewController(logger, config)
-	return controller, err
+	remoteClient, err := k8sremote.NewRemoteClient(logger, config)
+	if err != nil {
+		return nil, err
+	}
+	return newController(logger, remoteClient, config)
+}
+
+// newController creates the controller of the flex config over client
+func newController(logger *log.Logger, client resources.StorageClient, config resources.UbiquityPluginConfig) (*controller.Controller, error) {
+	flexConfig, err := readFlexConfig(*configFile)
+	if err != nil {
+		return nil, err
+	}
+	// the circuit breaker only spans the call-outs the agent serves, a plain call-out just retries
+	client = k8sutils.NewRetryingStorageClient(client, flexConfig.ClientRetry, controller.ErrorCode)
+	controller := controller.NewControllerWithConfig(logger, client, utils.NewExecutor(), config)
+	controller.SetWaitForAttachConfig(flexConfig.WaitForAttach)
+	controller.SetPodMountLayouts(flexConfig.PodMountLayout)
+	if flexConfig.Events.Kubeconfig != "" {
+		podEventReporter, err := events.NewPodEventReporter(flexConfig.Events.Kubeconfig, flexConfig.Events.NodeName)
+		if err != nil {
+			// the Events are optional, the call-outs work without them
+			logger.Printf("Failed to create the pod event reporter: %v", err)
+		} else {
+			controller.SetPodEventReporter(podEventReporter)
+		}
+		podLister, err := events.NewNodePodLister(flexConfig.Events.Kubeconfig, flexConfig.Events.NodeName)
+		if err != nil {
+			// the orphan cleanup counts every pod directory of the node as live without it
+			logger.Printf("Failed to create the pod lister: %v", err)
+		} else {
+			controller.SetPodLister(podLister)
+		}
+		credentialsGetter, err := events.NewVolumeCredentialsGetter(flexConfig.Events.Kubeconfig)
+		if err != nil {
+			// the unmount and detach call-outs use the credentials of the node config without it
+			logger.Printf("Failed to create the volume credentials getter: %v", err)
+		} else {
+			controller.SetVolumeCredentialsGetter(credentialsGetter)
+		}
+	}
+	orphanCleanupConfig := flexConfig.OrphanCleanup
+	if orphanCleanupConfig.NodeName == "" {
+		orphanCleanupConfig.NodeName = flexConfig.Events.NodeName
+	}
+	controller.SetOrphanCleanupConfig(orphanCleanupConfig)
+	return controller, nil
 }
 
-func readConfig(configFile string) (resources.UbiquityPluginConfig, error) {
-	var config resources.UbiquityPluginConfig
-	if _, err := toml.DecodeFile(configFile, &config); err != nil {
-		fmt.Printf("error decoding config file", err)
-		return resources.UbiquityPluginConfig{}, err
+// initFlexLogger logs to the flex log file of config, it returns the function closing the file
+func initFlexLogger(config resources.UbiquityPluginConfig) func() {
+	return logs.InitFileLogger(logs.GetLogLevelFromString(config.LogLevel), path.Join(config.LogPath, k8sresources.UbiquityFlexLogFileName))
+}
 
+func readFlexConfig(configFile string) (k8sresources.FlexConfig, error) {
+	var config k8sresources.FlexConfig
+	if err := k8sutils.DecodeConfigFile(configFile, &config); err != nil {
+		return k8sresources.FlexConfig{}, err
 	}
-	// Create environment variables for some of the config params
-	os.Setenv(remote.KeyUseSsl,  strconv.FormatBool(config.SslConfig.UseSsl))
-	os.Setenv(resources.KeySslMode,  config.SslConfig.SslMode)
-	os.Setenv(remote.KeyVerifyCA, config.SslConfig.VerifyCa)
 	return config, nil
 }
 
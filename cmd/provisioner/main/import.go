@@ -0,0 +1,76 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/IBM/ubiquity-k8s/volume"
+	"github.com/IBM/ubiquity/resources"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	importVolume         = flag.String("import-volume", "", "Create the PV of this existing backend volume and exit, rather than run the provisioner")
+	importPVName         = flag.String("import-pv-name", "", "Name of the PV of the imported volume, it must be the volume name (the default) since the flex driver finds the volume by the PV name")
+	importCapacity       = flag.String("import-capacity", "", "Capacity of the PV of the imported volume, e.g 10Gi, the request of the claim by default")
+	importClaim          = flag.String("import-claim", "", "Claim to bind the PV of the imported volume to, as namespace/name")
+	importPodMountLayout = flag.String("import-pod-mount-layout", "", "Pod mount layout of the imported volume, symlink or bind, the one of the flex config by default")
+	importReclaimPolicy  = flag.String("import-reclaim-policy", string(v1.PersistentVolumeReclaimRetain), "Reclaim policy of the PV of the imported volume, Retain or Delete (the provisioner then deletes the volume with the PV)")
+	importDryRun         = flag.Bool("import-dry-run", false, "Print the PV of the imported volume without creating it")
+)
+
+// runImport creates the PV of the backend volume of -import-volume and prints it
+func runImport(logger *log.Logger, ubiquityClient resources.StorageClient, clientset kubernetes.Interface) error {
+	options := volume.ImportOptions{
+		VolumeName:     *importVolume,
+		PVName:         *importPVName,
+		Capacity:       *importCapacity,
+		PodMountLayout: *importPodMountLayout,
+		ReclaimPolicy:  v1.PersistentVolumeReclaimPolicy(*importReclaimPolicy),
+	}
+	if *importClaim != "" {
+		claim := strings.SplitN(*importClaim, "/", 2)
+		if len(claim) != 2 || claim[0] == "" || claim[1] == "" {
+			return fmt.Errorf("claim %q is invalid, expected namespace/name", *importClaim)
+		}
+		options.ClaimNamespace, options.ClaimName = claim[0], claim[1]
+	}
+	if options.ReclaimPolicy != v1.PersistentVolumeReclaimRetain && options.ReclaimPolicy != v1.PersistentVolumeReclaimDelete {
+		return fmt.Errorf("reclaim policy %q is invalid, expected %s or %s", options.ReclaimPolicy, v1.PersistentVolumeReclaimRetain, v1.PersistentVolumeReclaimDelete)
+	}
+
+	pv, err := volume.ImportVolume(ubiquityClient, clientset, options, *importDryRun)
+	if err != nil {
+		return err
+	}
+	if *importDryRun {
+		logger.Printf("Dry run, PV %s of volume %s not created", pv.Name, options.VolumeName)
+	} else {
+		logger.Printf("Created PV %s of volume %s", pv.Name, options.VolumeName)
+	}
+	output, err := json.MarshalIndent(pv, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(output))
+	return nil
+}
@@ -0,0 +1,81 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"log"
+	"time"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+// The ConfigMap the provisioner replicas compete for
+const leaderElectionLockName = "ubiquity-k8s-provisioner-leader"
+
+type leaderElectionConfig struct {
+	Namespace     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+//runAsLeader blocks until this replica holds the lease, then calls run with a channel closed when it loses the lease.
+//A replica that loses the lease exits, so it never provisions beside the new leader. The standbys take over once
+//the lease of an exited leader expires, at most LeaseDuration after its last renewal.
+func runAsLeader(logger *log.Logger, clientset kubernetes.Interface, config leaderElectionConfig, run func(stopCh <-chan struct{})) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(config.Namespace)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: k8sresources.UbiquityProvisionerName})
+
+	lock := &resourcelock.ConfigMapLock{
+		ConfigMapMeta: metav1.ObjectMeta{Namespace: config.Namespace, Name: leaderElectionLockName},
+		Client:        clientset.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      config.Identity,
+			EventRecorder: recorder,
+		},
+	}
+
+	logger.Printf("Waiting for the leader election lease %s/%s as %s", config.Namespace, leaderElectionLockName, config.Identity)
+	leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stopCh <-chan struct{}) {
+				logger.Printf("Became the leader of %s/%s", config.Namespace, leaderElectionLockName)
+				run(stopCh)
+			},
+			OnStoppedLeading: func() {
+				logger.Printf("Lost the leader election lease %s/%s", config.Namespace, leaderElectionLockName)
+				panic("Lost the leader election lease")
+			},
+			OnNewLeader: func(identity string) {
+				logger.Printf("The leader is now %s", identity)
+			},
+		},
+	})
+}
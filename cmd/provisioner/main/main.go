@@ -17,15 +17,22 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"strings"
+	"time"
 
+	ubiquitycontroller "github.com/IBM/ubiquity-k8s/controller"
+	"github.com/IBM/ubiquity-k8s/metrics"
+	k8sremote "github.com/IBM/ubiquity-k8s/remote"
 	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity-k8s/snapshot"
 	k8sutils "github.com/IBM/ubiquity-k8s/utils"
 	"github.com/IBM/ubiquity-k8s/volume"
-	"github.com/IBM/ubiquity/remote"
 	"github.com/IBM/ubiquity/utils"
 	"github.com/IBM/ubiquity/utils/logs"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -36,13 +43,56 @@ import (
 var (
 	provisioner = k8sresources.ProvisionerName
 	configFile  = os.Getenv("KUBECONFIG")
+
+	leaderElect              = flag.Bool("leader-elect", false, "Run the controllers only on the replica elected leader, for running several replicas. Needs to get, create and update ConfigMaps in the leader election namespace")
+	leaderElectNamespace     = flag.String("leader-elect-namespace", getNamespace(), "Namespace of the leader election ConfigMap, defaults to POD_NAMESPACE")
+	leaderElectIdentity      = flag.String("leader-elect-identity", getLeaderElectIdentity(), "Identity of this replica in the leader election, unique per replica, defaults to POD_NAME or else to the hostname and a random UUID")
+	leaderElectLeaseDuration = flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration the standbys wait after the last renewal of the leader before taking over")
+	leaderElectRenewDeadline = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration the leader retries renewing before it gives up leading, shorter than the lease duration")
+	leaderElectRetryPeriod   = flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration between the tries to acquire or renew the lease")
+
+	orphanCollectorInterval    = flag.Duration("orphan-collector-interval", time.Hour, "Interval of the reports of the ubiquity volumes no PV references, 0 to disable them")
+	orphanCollectorDelete      = flag.Bool("orphan-collector-delete", false, "Delete the ubiquity volumes no PV references, only when the ubiquity server serves this cluster only")
+	orphanCollectorGracePeriod = flag.Duration("orphan-collector-grace-period", 24*time.Hour, "Duration a volume stays orphaned before it is deleted, counted in memory from the first report: it starts over at each restart or change of leader")
+	orphanCollectorBackends    = flag.String("orphan-collector-backends", "", "Comma separated backends of the collected volumes, all by default")
+
+	metricsAddress = flag.String("metrics-address", ":9898", "Address of the /metrics endpoint, empty to disable it")
+
+	ubiquityConfigFile   = flag.String("config", os.Getenv("UBIQUITY_CONFIG"), "TOML or YAML file of the ubiquity config, the environment variables override it")
+	configReloadInterval = flag.Duration("config-reload-interval", 30*time.Second, "Interval of the reloads of the config file and of the credentials directory (UBIQUITY_CREDENTIALS_DIR), 0 to disable them")
 )
 
+func getNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
+
+// getLeaderElectIdentity returns the pod name, or the hostname and a random UUID, the provisioner identity is shared by the replicas
+func getLeaderElectIdentity() string {
+	if podName := os.Getenv("POD_NAME"); podName != "" {
+		return podName
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "ubiquity-provisioner"
+	}
+	return hostname + "_" + string(uuid.NewUUID())
+}
+
 func main() {
+	flag.Parse()
 
-	ubiquityConfig, err := k8sutils.LoadConfig()
+	ubiquityConfig, err := k8sutils.LoadConfigFile(*ubiquityConfigFile)
 	if err != nil {
-		panic(fmt.Errorf("Failed to load config %#v", err))
+		panic(fmt.Errorf("Failed to load config: %v", err))
+	}
+	var provisionerConfig k8sresources.ProvisionerConfig
+	if *ubiquityConfigFile != "" {
+		if err := k8sutils.DecodeConfigFile(*ubiquityConfigFile, &provisionerConfig); err != nil {
+			panic(fmt.Errorf("Failed to load config: %v", err))
+		}
 	}
 	fmt.Printf("Starting ubiquity plugin with %s config file\n", configFile)
 
@@ -71,6 +121,10 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("Failed to create client: %v", err))
 	}
+	snapshotClient, err := snapshot.NewClient(config)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create snapshot client: %v", err))
+	}
 
 	// The controller needs to know what the server version is because out-of-tree
 	// provisioners aren't officially supported until 1.5
@@ -78,26 +132,90 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("Error getting server version: %v", err))
 	}
-	remoteClient, err := remote.NewRemoteClientSecure(logger, ubiquityConfig)
+	ubiquityClient, err := k8sremote.NewRemoteClient(logger, ubiquityConfig)
 	if err != nil {
 		logger.Printf("Error getting remote Client: %v", err)
 		panic("Error getting remote client")
 	}
+	if *importVolume != "" {
+		if err := runImport(logger, ubiquityClient, clientset); err != nil {
+			panic(fmt.Sprintf("Failed to import volume %s: %v", *importVolume, err))
+		}
+		return
+	}
+
+	// the remote client is swapped when the server, the credentials or the SSL settings change.
+	// The metrics see every attempt of the retried calls.
+	reloadableClient := k8sutils.NewReloadableStorageClient(ubiquityClient)
+	remoteClient := k8sutils.NewRetryingStorageClient(metrics.NewInstrumentedStorageClient(reloadableClient), provisionerConfig.ClientRetry, ubiquitycontroller.ErrorCode)
+	if *configReloadInterval > 0 {
+		go watchConfig(logger, ubiquityConfig, reloadableClient, *configReloadInterval, wait.NeverStop)
+	}
+
+	// Every replica serves its metrics, the standbys just report no operations
+	if *metricsAddress != "" {
+		go func() {
+			logger.Printf("Serving the metrics on %s/metrics", *metricsAddress)
+			logger.Printf("Error serving the metrics: %v", metrics.Serve(*metricsAddress))
+		}()
+	}
 
 	// Create the provisioner: it implements the Provisioner interface expected by
 	// the controller
-	ubiquityConfigCopyWithPasswordStarred := ubiquityConfig
-	ubiquityConfigCopyWithPasswordStarred.CredentialInfo.Password = "****"
-	logger.Printf("starting the provisioner, remote client %#v, config %#v", remoteClient, ubiquityConfigCopyWithPasswordStarred)
-	flexProvisioner, err := volume.NewFlexProvisioner(logger, remoteClient, ubiquityConfig)
+	logger.Printf("starting the provisioner, remote client %#v, config %#v", remoteClient, k8sutils.MaskSecrets(ubiquityConfig))
+	flexProvisioner, err := volume.NewFlexProvisionerWithTopology(logger, remoteClient, clientset, snapshotClient, ubiquityConfig, provisionerConfig.Topology)
 	if err != nil {
 		logger.Printf("Error starting provisioner: %v", err)
 		panic("Error starting ubiquity client")
 	}
 
-	// Start the provision controller which will dynamically provision Ubiquity PVs
+	run := func(stopCh <-chan struct{}) {
+		// Start the provision controller which will dynamically provision Ubiquity PVs
+
+		pc := controller.NewProvisionController(clientset, provisioner, flexProvisioner, serverVersion.GitVersion)
+
+		// Start the snapshot controller which will take the backend snapshots of the VolumeSnapshots.
+		// Without snapshot calls it would only fail them, and keep them with its finalizer
+		if k8sutils.SupportsSnapshots(remoteClient) {
+			go volume.NewSnapshotController(logger, clientset, snapshotClient, remoteClient).Run(stopCh)
+		} else {
+			logger.Printf("The ubiquity client does not support volume snapshots, the VolumeSnapshots are left as they are")
+		}
+
+		// Start the orphan collector which will report, or delete, the backend volumes no PV references
+		if *orphanCollectorInterval > 0 {
+			go volume.NewOrphanCollector(logger, clientset, remoteClient, volume.OrphanCollectorConfig{
+				Interval:    *orphanCollectorInterval,
+				Delete:      *orphanCollectorDelete,
+				GracePeriod: *orphanCollectorGracePeriod,
+				Backends:    splitBackends(*orphanCollectorBackends),
+			}).Run(stopCh)
+		}
+
+		pc.Run(stopCh)
+	}
+
+	if !*leaderElect {
+		run(wait.NeverStop)
+		return
+	}
 
-	pc := controller.NewProvisionController(clientset, provisioner, flexProvisioner, serverVersion.GitVersion)
-	pc.Run(wait.NeverStop)
+	// Several replicas would provision a claim each, only the leader runs the controllers
+	runAsLeader(logger, clientset, leaderElectionConfig{
+		Namespace:     *leaderElectNamespace,
+		Identity:      *leaderElectIdentity,
+		LeaseDuration: *leaderElectLeaseDuration,
+		RenewDeadline: *leaderElectRenewDeadline,
+		RetryPeriod:   *leaderElectRetryPeriod,
+	}, run)
 }
 
+func splitBackends(backends string) []string {
+	var split []string
+	for _, backend := range strings.Split(backends, ",") {
+		if backend = strings.TrimSpace(backend); backend != "" {
+			split = append(split, backend)
+		}
+	}
+	return split
+}
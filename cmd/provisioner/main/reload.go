@@ -0,0 +1,60 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"log"
+	"time"
+
+	k8sremote "github.com/IBM/ubiquity-k8s/remote"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+// watchConfig reloads the ubiquity config every interval until stopCh is closed.
+// A new log level applies at once, a new server, credentials or SSL settings get a new remote client, the calls in flight finish with the previous one.
+// The provisioner keeps the rest of the config it started with, the other changes apply at the next restart.
+func watchConfig(logger *log.Logger, ubiquityConfig resources.UbiquityPluginConfig, reloadableClient *k8sutils.ReloadableStorageClient, interval time.Duration, stopCh <-chan struct{}) {
+	configWatcher := k8sutils.NewConfigWatcher(func() (resources.UbiquityPluginConfig, error) {
+		return k8sutils.LoadConfigFile(*ubiquityConfigFile)
+	}, ubiquityConfig)
+	configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+		if !k8sutils.ConnectionChanged(oldConfig, newConfig) {
+			return nil
+		}
+		client, err := k8sremote.NewRemoteClient(logger, newConfig)
+		if err != nil {
+			return err
+		}
+		reloadableClient.SetClient(client)
+		logger.Printf("Using a new remote client for the ubiquity server %s:%d", newConfig.UbiquityServer.Address, newConfig.UbiquityServer.Port)
+		return nil
+	})
+	configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+		if newConfig.LogLevel != oldConfig.LogLevel {
+			logs.InitStdoutLogger(logs.GetLogLevelFromString(newConfig.LogLevel))
+		}
+		newConfig.LogLevel = oldConfig.LogLevel
+		if changes := k8sutils.ChangesBesidesConnection(oldConfig, newConfig); len(changes) > 0 {
+			logger.Printf("The provisioner applies these config changes at its next restart: %v", changes)
+		}
+		return nil
+	})
+
+	logger.Printf("Reloading the config every %s", interval)
+	configWatcher.Run(interval, stopCh)
+}
@@ -18,70 +18,106 @@ package controller
 
 import (
 	"fmt"
+	"github.com/IBM/ubiquity/utils/logs"
 	"log"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
-	"github.com/IBM/ubiquity/utils/logs"
-
 
 	"bytes"
+	k8sremote "github.com/IBM/ubiquity-k8s/remote"
 	k8sresources "github.com/IBM/ubiquity-k8s/resources"
-	"github.com/IBM/ubiquity/remote"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/remote/mounter"
 	"github.com/IBM/ubiquity/resources"
 	"github.com/IBM/ubiquity/utils"
 	"path/filepath"
-	"github.com/IBM/ubiquity/remote/mounter"
-	"github.com/nightlyone/lockfile"
+	"sync"
 	"time"
 )
 
+// a volume lock is held for a whole mount or unmount flow, the rescan lock only for the rescan or multipath cleanup inside it
+const volumeLockTimeout = 3 * time.Minute
+const rescanLockTimeout = 2 * time.Minute
+const rescanLockKey = "rescan"
+
+// uniqueVolumeNameSeparator joins the parts of the GetVolumeName output, it is neither used by backend names nor by WWNs
+const uniqueVolumeNameSeparator = "_"
+
 //Controller this is a structure that controls volume management
 type Controller struct {
-	Client resources.StorageClient
-	exec   utils.Executor
-	logger logs.Logger
-	legacyLogger *log.Logger
-	config resources.UbiquityPluginConfig
-	mounterPerBackend map[string]resources.Mounter
-	unmountFlock       lockfile.Lockfile
+	Client                resources.StorageClient
+	exec                  utils.Executor
+	logger                logs.Logger
+	legacyLogger          *log.Logger
+	config                resources.UbiquityPluginConfig
+	mounterPerBackend     map[string]resources.Mounter
+	mounterPerBackendLock sync.Mutex
+	volumeLocks           *k8sutils.KeyedLocker
+	rescanLocks           *k8sutils.KeyedLocker
+	waitForAttachConfig   k8sresources.WaitForAttachConfig
+	podMountLayouts       map[string]string
+	podEventReporter      PodEventReporter
+	podLister             PodLister
+	credentialsGetter     VolumeCredentialsGetter
+	orphanCleanupConfig   k8sresources.OrphanCleanupConfig
+	mountTable            MountTable
+}
+
+//PodEventReporter is told the Mount and Unmount failures, e.g to post them as Events of the pods
+type PodEventReporter interface {
+	ReportPodVolumeFailure(pod k8sresources.PodRef, volumeName string, reason string, message string) error
+}
+
+//PodLister lists the UIDs of the pods of the node, the orphan cleanup keeps the volumes of these pods
+type PodLister interface {
+	ListPodUIDs() (map[string]bool, error)
+}
+
+//VolumeCredentialsGetter gets the credentials of the SecretRef of a PV, for the unmount and detach flows kubelet passes no options to
+type VolumeCredentialsGetter interface {
+	GetVolumeCredentials(pvName string) (resources.CredentialInfo, error)
+}
+
+//MountTable reads the mounts of the node
+type MountTable interface {
+	IsMountPoint(path string) (bool, error)
+	GetMountRefs(path string) ([]string, error)
+	ListMountPoints() (map[string]string, error)
 }
 
 //NewController allows to instantiate a controller
 func NewController(logger *log.Logger, config resources.UbiquityPluginConfig) (*Controller, error) {
-	unmountFlock, err := lockfile.New(filepath.Join(os.TempDir(), "ubiquity.unmount.lock"))
-	if err != nil {
-		panic(err)
-	}
-
-	remoteClient, err := remote.NewRemoteClientSecure(logger, config)
+	remoteClient, err := k8sremote.NewRemoteClient(logger, config)
 	if err != nil {
 		return nil, err
 	}
 	return &Controller{
-		logger: logs.GetLogger(),
-		legacyLogger: logger,
-		Client: remoteClient,
-		exec: utils.NewExecutor(),
-		config: config,
+		logger:            logs.GetLogger(),
+		legacyLogger:      logger,
+		Client:            remoteClient,
+		exec:              utils.NewExecutor(),
+		config:            config,
 		mounterPerBackend: make(map[string]resources.Mounter),
-		unmountFlock: unmountFlock}, nil
+		volumeLocks:       k8sutils.NewKeyedLocker(os.TempDir(), "ubiquity.volume"),
+		rescanLocks:       k8sutils.NewKeyedLocker(os.TempDir(), "ubiquity"),
+		mountTable:        k8sutils.ProcMountTable{}}, nil
+}
+
+//NewControllerWithConfig allows to instantiate a controller that shares an existing client, e.g the CSI node service
+func NewControllerWithConfig(logger *log.Logger, client resources.StorageClient, exec utils.Executor, config resources.UbiquityPluginConfig) *Controller {
+	controller := NewControllerWithClient(logger, client, exec)
+	controller.config = config
+	return controller
 }
 
 //NewControllerWithClient is made for unit testing purposes where we can pass a fake client
 func NewControllerWithClient(logger *log.Logger, client resources.StorageClient, exec utils.Executor) *Controller {
-	unmountFlock, err := lockfile.New(filepath.Join(os.TempDir(), "ubiquity.unmount.lock"))
-	if err != nil {
-		panic(err)
-	}
-
 	utils.NewExecutor()
-	return &Controller{logger: logs.GetLogger(), legacyLogger: logger, Client: client, exec: exec, unmountFlock: unmountFlock}
+	return &Controller{logger: logs.GetLogger(), legacyLogger: logger, Client: client, exec: exec, mounterPerBackend: make(map[string]resources.Mounter), volumeLocks: k8sutils.NewKeyedLocker(os.TempDir(), "ubiquity.volume"), rescanLocks: k8sutils.NewKeyedLocker(os.TempDir(), "ubiquity"), mountTable: k8sutils.ProcMountTable{}}
 }
 
-
-
 //Init method is to initialize the k8sresourcesvolume
 func (c *Controller) Init(config resources.UbiquityPluginConfig) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
@@ -105,10 +141,7 @@ func (c *Controller) TestUbiquity(config resources.UbiquityPluginConfig) k8sreso
 
 	err := c.doActivate(activateRequest)
 	if err != nil {
-		response = k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: fmt.Sprintf("Test ubiquity failed %#v ", err),
-		}
+		response = failureResponse(wrapError(err, "Test ubiquity failed"))
 	} else {
 		response = k8sresources.FlexVolumeResponse{
 			Status:  "Success",
@@ -124,15 +157,13 @@ func (c *Controller) TestUbiquity(config resources.UbiquityPluginConfig) k8sreso
 func (c *Controller) Attach(attachRequest k8sresources.FlexVolumeAttachRequest) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
 	var response k8sresources.FlexVolumeResponse
-	c.logger.Debug("", logs.Args{{"request", attachRequest}})
+	loggedRequest := attachRequest
+	loggedRequest.Opts = maskSecretOptions(attachRequest.Opts)
+	c.logger.Debug("", logs.Args{{"request", loggedRequest}})
 
 	err := c.doAttach(attachRequest)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to attach volume [%s], Error: %#v", attachRequest.Name, err)
-		response = k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: msg,
-		}
+		response = failureResponse(wrapError(err, "Failed to attach volume [%s]", attachRequest.Name))
 	} else {
 		response = k8sresources.FlexVolumeResponse{
 			Status: "Success",
@@ -143,29 +174,70 @@ func (c *Controller) Attach(attachRequest k8sresources.FlexVolumeAttachRequest)
 	return response
 }
 
-
-//GetVolumeName checks if volume is attached
+//GetVolumeName returns a cluster wide unique name of the volume, kubelet uses it for detach and for the device mount path
 func (c *Controller) GetVolumeName(getVolumeNameRequest k8sresources.FlexVolumeGetVolumeNameRequest) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
 	var response k8sresources.FlexVolumeResponse
 	c.logger.Debug("", logs.Args{{"request", getVolumeNameRequest}})
 
-	response = k8sresources.FlexVolumeResponse{
-		Status: "Not supported",
+	uniqueName, err := c.doGetVolumeName(getVolumeNameRequest)
+	if err != nil {
+		response = failureResponse(err)
+	} else {
+		response = k8sresources.FlexVolumeResponse{
+			Status:     "Success",
+			VolumeName: uniqueName,
+		}
 	}
 
 	c.logger.Debug("", logs.Args{{"response", response}})
 	return response
 }
 
+//SetWaitForAttachConfig sets the polling parameters of WaitForAttach, zero values keep the defaults
+func (c *Controller) SetWaitForAttachConfig(config k8sresources.WaitForAttachConfig) {
+	c.waitForAttachConfig = config
+}
+
+//SetPodMountLayouts sets the pod mount layout (symlink or bind) per backend, backends that are not set keep the symlink layout
+func (c *Controller) SetPodMountLayouts(podMountLayouts map[string]string) {
+	c.podMountLayouts = podMountLayouts
+}
+
+//SetPodEventReporter sets the reporter of the Mount and Unmount failures, none by default
+func (c *Controller) SetPodEventReporter(podEventReporter PodEventReporter) {
+	c.podEventReporter = podEventReporter
+}
+
+//SetVolumeCredentialsGetter sets the getter of the credentials of the unmount and detach flows, without it they use the credentials of the node config
+func (c *Controller) SetVolumeCredentialsGetter(credentialsGetter VolumeCredentialsGetter) {
+	c.credentialsGetter = credentialsGetter
+}
+
+//SetPodLister sets the lister of the live pods of the node, without it the orphan cleanup counts every pod directory as live
+func (c *Controller) SetPodLister(podLister PodLister) {
+	c.podLister = podLister
+}
+
+//SetOrphanCleanupConfig sets the kubelet root dir and the node name of the orphan cleanup, zero values keep the defaults
+func (c *Controller) SetOrphanCleanupConfig(config k8sresources.OrphanCleanupConfig) {
+	c.orphanCleanupConfig = config
+}
+
 //WaitForAttach Waits for a volume to get attached to the node
 func (c *Controller) WaitForAttach(waitForAttachRequest k8sresources.FlexVolumeWaitForAttachRequest) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
 	var response k8sresources.FlexVolumeResponse
 	c.logger.Debug("", logs.Args{{"request", waitForAttachRequest}})
 
-	response = k8sresources.FlexVolumeResponse{
-		Status: "Not supported",
+	devicePath, err := c.doWaitForAttach(waitForAttachRequest)
+	if err != nil {
+		response = failureResponse(err)
+	} else {
+		response = k8sresources.FlexVolumeResponse{
+			Status: "Success",
+			Device: devicePath,
+		}
 	}
 
 	c.logger.Debug("", logs.Args{{"response", response}})
@@ -180,14 +252,10 @@ func (c *Controller) IsAttached(isAttachedRequest k8sresources.FlexVolumeIsAttac
 
 	isAttached, err := c.doIsAttached(isAttachedRequest)
 	if err != nil {
-		msg := fmt.Sprintf("Failed to check IsAttached volume [%s], Error: %#v", isAttachedRequest.Name, err)
-		response = k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: msg,
-		}
+		response = failureResponse(wrapError(err, "Failed to check IsAttached volume [%s]", isAttachedRequest.Name))
 	} else {
 		response = k8sresources.FlexVolumeResponse{
-			Status: "Success",
+			Status:   "Success",
 			Attached: isAttached,
 		}
 	}
@@ -208,14 +276,12 @@ func (c *Controller) Detach(detachRequest k8sresources.FlexVolumeDetachRequest)
 			Status: "Success",
 		}
 	} else {
-		err := c.doDetach(detachRequest, true)
+		credentials, err := c.credentialsOfVolume(volumeNameFromUniqueName(detachRequest.Name))
+		if err == nil {
+			err = c.doDetach(detachRequest, true, credentials)
+		}
 		if err != nil {
-			msg := fmt.Sprintf(
-				"Failed to detach volume [%s] from host [%s]. Error: %#v",
-				detachRequest.Name,
-				detachRequest.Host,
-				err)
-			response = k8sresources.FlexVolumeResponse{Status: "Failure", Message: msg, Device: ""}
+			response = failureResponse(wrapError(err, "Failed to detach volume [%s] from host [%s]", detachRequest.Name, detachRequest.Host))
 		} else {
 			response = k8sresources.FlexVolumeResponse{
 				Status: "Success",
@@ -227,28 +293,48 @@ func (c *Controller) Detach(detachRequest k8sresources.FlexVolumeDetachRequest)
 	return response
 }
 
-//MountDevice mounts a device in a given location
+//MountDevice mounts the volume once on the node and bind mounts it on the global path kubelet passes in
 func (c *Controller) MountDevice(mountDeviceRequest k8sresources.FlexVolumeMountDeviceRequest) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
 	var response k8sresources.FlexVolumeResponse
-	c.logger.Debug("", logs.Args{{"request", mountDeviceRequest}})
-
-	response = k8sresources.FlexVolumeResponse{
-		Status: "Not supported",
+	loggedRequest := mountDeviceRequest
+	loggedRequest.Opts = maskSecretOptions(mountDeviceRequest.Opts)
+	c.logger.Debug("", logs.Args{{"request", loggedRequest}})
+
+	unlock, err := c.lockVolume(volumeLockKey(mountDeviceRequest.Path))
+	if err == nil {
+		defer unlock()
+		err = c.doMountDevice(mountDeviceRequest)
+	}
+	if err != nil {
+		response = failureResponse(err)
+	} else {
+		response = k8sresources.FlexVolumeResponse{
+			Status: "Success",
+		}
 	}
 
 	c.logger.Debug("", logs.Args{{"response", response}})
 	return response
 }
 
-//UnmountDevice checks if volume is unmounted
+//UnmountDevice unmounts the global path of the volume and the volume itself, once no pod bind mounts it anymore
 func (c *Controller) UnmountDevice(unmountDeviceRequest k8sresources.FlexVolumeUnmountDeviceRequest) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
 	var response k8sresources.FlexVolumeResponse
 	c.logger.Debug("", logs.Args{{"request", unmountDeviceRequest}})
 
-	response = k8sresources.FlexVolumeResponse{
-		Status: "Not supported",
+	unlock, err := c.lockVolume(volumeLockKey(unmountDeviceRequest.Name))
+	if err == nil {
+		defer unlock()
+		err = c.doUnmountDevice(unmountDeviceRequest)
+	}
+	if err != nil {
+		response = failureResponse(err)
+	} else {
+		response = k8sresources.FlexVolumeResponse{
+			Status: "Success",
+		}
 	}
 
 	c.logger.Debug("", logs.Args{{"response", response}})
@@ -257,23 +343,71 @@ func (c *Controller) UnmountDevice(unmountDeviceRequest k8sresources.FlexVolumeU
 
 //Mount method allows to mount the volume/fileset to a given location for a pod
 func (c *Controller) Mount(mountRequest k8sresources.FlexVolumeMountRequest) k8sresources.FlexVolumeResponse {
+	response := c.mount(mountRequest)
+	if response.Status == "Failure" {
+		pod := k8sresources.PodRef{
+			UID:       podUIDFromMountPath(mountRequest.MountPath),
+			Name:      mountRequest.Opts[k8sresources.OptionNamePodName],
+			Namespace: mountRequest.Opts[k8sresources.OptionNamePodNamespace],
+		}
+		c.reportPodVolumeFailure(pod, path.Base(mountRequest.MountPath), "FailedMount", response.Message)
+	}
+	return response
+}
+
+func (c *Controller) mount(mountRequest k8sresources.FlexVolumeMountRequest) k8sresources.FlexVolumeResponse {
 	defer c.logger.Trace(logs.DEBUG)()
 	var response k8sresources.FlexVolumeResponse
-	c.logger.Debug("", logs.Args{{"request", mountRequest}})
+	loggedRequest := mountRequest
+	loggedRequest.Opts = maskSecretOptions(mountRequest.Opts)
+	c.logger.Debug("", logs.Args{{"request", loggedRequest}})
 
-	mountedPath, err := c.doMount(mountRequest)
+	unlock, err := c.lockVolume(volumeLockKey(mountRequest.MountPath))
 	if err != nil {
-		response = k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: err.Error(),
-		}
-	} else {
-		err = c.doAfterMount(mountRequest, mountedPath)
+		response = failureResponse(err)
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+	defer unlock()
+
+	deviceMountPath, err := c.getDeviceMountPath(mountRequest.MountDevice)
+	if err != nil {
+		response = failureResponse(err)
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+	if deviceMountPath != "" && mountRequest.Version != k8sresources.KubernetesVersion_1_5 {
+		// mountdevice already mounted the volume on the node, the pod only needs a bind mount of it
+		err = c.doBindMountToPod(deviceMountPath, mountRequest.MountPath)
 		if err != nil {
+			response = failureResponse(err)
+		} else {
 			response = k8sresources.FlexVolumeResponse{
-				Status:  "Failure",
-				Message: err.Error(),
+				Status: "Success",
 			}
+		}
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+
+	podMountLayout, err := c.getPodMountLayout(mountRequest)
+	if err != nil {
+		response = failureResponse(err)
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+
+	mountedPath, err := c.doMount(mountRequest)
+	if err != nil {
+		response = failureResponse(err)
+	} else {
+		if podMountLayout == k8sresources.PodMountLayoutBind && mountRequest.Version != k8sresources.KubernetesVersion_1_5 {
+			err = c.doBindMountToPod(mountedPath, mountRequest.MountPath)
+		} else {
+			err = c.doAfterMount(mountRequest, mountedPath)
+		}
+		if err != nil {
+			response = failureResponse(err)
 		} else {
 			response = k8sresources.FlexVolumeResponse{
 				Status: "Success",
@@ -287,54 +421,100 @@ func (c *Controller) Mount(mountRequest k8sresources.FlexVolumeMountRequest) k8s
 
 //Unmount methods unmounts the volume from the pod
 func (c *Controller) Unmount(unmountRequest k8sresources.FlexVolumeUnmountRequest) k8sresources.FlexVolumeResponse {
-	defer c.logger.Trace(logs.DEBUG)()
-	// locking for concurrent rescans and reduce rescans if no need
-	c.logger.Debug("Ask for unmountFlock for mountpath", logs.Args{{"mountpath", unmountRequest.MountPath}})
-	for {
-		err := c.unmountFlock.TryLock()
-		if err == nil {
-			break
-		}
-		c.logger.Debug("unmountFlock.TryLock failed", logs.Args{{"error", err}})
-		time.Sleep(time.Duration(500*time.Millisecond))
+	response := c.unmount(unmountRequest)
+	if response.Status == "Failure" {
+		pod := k8sresources.PodRef{UID: podUIDFromMountPath(unmountRequest.MountPath)}
+		c.reportPodVolumeFailure(pod, path.Base(unmountRequest.MountPath), "FailedUnmount", response.Message)
 	}
-	c.logger.Debug("Got unmountFlock for mountpath", logs.Args{{"mountpath", unmountRequest.MountPath}})
-	defer c.unmountFlock.Unlock()
-	defer c.logger.Debug("Released unmountFlock for mountpath", logs.Args{{"mountpath", unmountRequest.MountPath}})
+	return response
+}
+
+func (c *Controller) unmount(unmountRequest k8sresources.FlexVolumeUnmountRequest) k8sresources.FlexVolumeResponse {
+	defer c.logger.Trace(logs.DEBUG)()
 
 	var response k8sresources.FlexVolumeResponse
 	c.logger.Debug("", logs.Args{{"request", unmountRequest}})
-	var err error
+
+	unlock, err := c.lockVolume(volumeLockKey(unmountRequest.MountPath))
+	if err != nil {
+		response = failureResponse(err)
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+	defer unlock()
+
+	credentials, err := c.credentialsOfVolume(path.Base(unmountRequest.MountPath))
+	if err != nil {
+		response = failureResponse(err)
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+
+	isBindMount, err := c.mountTable.IsMountPoint(unmountRequest.MountPath)
+	if err == nil && isBindMount {
+		// bind layout, of the real mountpoint or of the mountdevice global path
+		err = c.doUnmountBindMount(unmountRequest, credentials)
+		if err != nil {
+			response = failureResponse(err)
+		} else {
+			response = k8sresources.FlexVolumeResponse{Status: "Success"}
+		}
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
+
+	// a replay of an unmount that removed the pod path already, or a pod path the mount never linked
+	info, err := os.Lstat(unmountRequest.MountPath)
+	if err != nil && !os.IsNotExist(err) {
+		err = wrapError(err, "Cannot execute umount because the mountPath [%s] cannot be read", unmountRequest.MountPath)
+		c.logger.Error(err.Error())
+		return failureResponse(err)
+	}
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		err = c.doUnmountAbsentPath(unmountRequest, credentials)
+		if err != nil {
+			response = failureResponse(err)
+		} else {
+			response = k8sresources.FlexVolumeResponse{Status: "Success"}
+		}
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
 
 	// Validate that the mountpoint is a symlink as ubiquity expect it to be
 	realMountPoint, err := c.exec.EvalSymlinks(unmountRequest.MountPath)
+	if err != nil && os.IsNotExist(err) {
+		// a replay of an unmount that unmounted the volume but did not remove the symlink
+		err = c.doUnmountDanglingSymlink(unmountRequest, credentials)
+		if err != nil {
+			response = failureResponse(err)
+		} else {
+			response = k8sresources.FlexVolumeResponse{Status: "Success"}
+		}
+		c.logger.Debug("", logs.Args{{"response", response}})
+		return response
+	}
 	if err != nil {
-		msg := fmt.Sprintf("Cannot execute umount because the mountPath [%s] is not a symlink as expected. Error: %#v", unmountRequest.MountPath, err)
-		c.logger.Error(msg)
-		return k8sresources.FlexVolumeResponse{Status: "Failure", Message: msg, Device: ""}
+		err = wrapError(err, "Cannot execute umount because the mountPath [%s] is not a symlink as expected", unmountRequest.MountPath)
+		c.logger.Error(err.Error())
+		return failureResponse(err)
 	}
 
 	ubiquityMountPrefix := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "")
 	if strings.HasPrefix(realMountPoint, ubiquityMountPrefix) {
 		// SCBE backend flow
-		err = c.doUnmountScbe(unmountRequest, realMountPoint)
+		err = c.doUnmountScbe(unmountRequest, realMountPoint, credentials)
 	} else {
 		// SSC backend flow
-		err = c.doUnmountSsc(unmountRequest, realMountPoint)
+		err = c.doUnmountSsc(unmountRequest, realMountPoint, credentials)
 	}
 
 	if err != nil {
-		response = k8sresources.FlexVolumeResponse{
-			Status:  "Failure",
-			Message: err.Error(),
-		}
+		response = failureResponse(err)
 	} else {
-		err = c.doLegacyDetach(unmountRequest)
+		err = c.doLegacyDetach(unmountRequest, credentials)
 		if err != nil {
-			response = k8sresources.FlexVolumeResponse{
-				Status:  "Failure",
-				Message: err.Error(),
-			}
+			response = failureResponse(err)
 		} else {
 			response = k8sresources.FlexVolumeResponse{
 				Status: "Success",
@@ -346,17 +526,218 @@ func (c *Controller) Unmount(unmountRequest k8sresources.FlexVolumeUnmountReques
 	return response
 }
 
-func (c *Controller) doLegacyDetach(unmountRequest k8sresources.FlexVolumeUnmountRequest) error	{
+//MountVolume mounts the volume on the node at its ubiquity mountpoint and returns the real mountpoint
+func (c *Controller) MountVolume(name string, opts map[string]string) (string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	mountRequest := k8sresources.FlexVolumeMountRequest{MountDevice: name, Opts: opts}
+	return c.doMount(mountRequest)
+}
+
+//UnmountVolume unmounts the volume from its ubiquity mountpoint on the node
+func (c *Controller) UnmountVolume(name string) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	credentials, err := c.credentialsOfVolume(name)
+	if err != nil {
+		return err
+	}
+	return c.doUnmountVolume(name, credentials)
+}
+
+//VolumeMountpoint returns the ubiquity mountpoint of the volume on the node
+func (c *Controller) VolumeMountpoint(name string) (string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	credentials, err := c.credentialsOfVolume(name)
+	if err != nil {
+		return "", err
+	}
+	_, mountpoint, err := c.getVolumeMountpoint(name, credentials)
+	return mountpoint, err
+}
+
+// lockVolume serializes the mount and unmount flows of a volume, the flows of other volumes run in parallel. It returns the unlock function.
+func (c *Controller) lockVolume(volumeName string) (func(), error) {
+	c.logger.Debug("Ask for the volume lock", logs.Args{{"volume", volumeName}})
+	unlock, err := c.volumeLocks.Lock(volumeName, volumeLockTimeout)
+	if err != nil {
+		return nil, c.logger.ErrorRet(err, "volumeLocks.Lock failed")
+	}
+	c.logger.Debug("Got the volume lock", logs.Args{{"volume", volumeName}})
+
+	return func() {
+		unlock()
+		c.logger.Debug("Released the volume lock", logs.Args{{"volume", volumeName}})
+	}, nil
+}
+
+// lockRescan serializes the SCSI rescans and multipath cleanups of the node, whatever the volume. It returns the unlock function.
+func (c *Controller) lockRescan() (func(), error) {
+	c.logger.Debug("Ask for the rescan lock")
+	unlock, err := c.rescanLocks.Lock(rescanLockKey, rescanLockTimeout)
+	if err != nil {
+		return nil, c.logger.ErrorRet(err, "rescanLocks.Lock failed")
+	}
+	c.logger.Debug("Got the rescan lock")
+
+	return func() {
+		unlock()
+		c.logger.Debug("Released the rescan lock")
+	}, nil
+}
+
+func (c *Controller) doMountDevice(mountDeviceRequest k8sresources.FlexVolumeMountDeviceRequest) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	mounted, err := c.mountTable.IsMountPoint(mountDeviceRequest.Path)
+	if err != nil {
+		return c.logger.ErrorRet(err, "IsMountPoint failed")
+	}
+	if mounted {
+		c.logger.Debug("Device already mounted", logs.Args{{"path", mountDeviceRequest.Path}})
+		return nil
+	}
+
+	volumeName, ok := mountDeviceRequest.Opts["volumeName"]
+	if !ok {
+		err = NewControllerError(k8sresources.ErrorCodeInvalidRequest, "volumeName not found in mountDeviceRequest")
+		return c.logger.ErrorRet(err, "failed")
+	}
+
+	mountRequest := k8sresources.FlexVolumeMountRequest{MountDevice: volumeName, Opts: mountDeviceRequest.Opts}
+	realMountPoint, err := c.doMount(mountRequest)
+	if err != nil {
+		return c.logger.ErrorRet(err, "doMount failed")
+	}
+
+	err = c.exec.MkdirAll(mountDeviceRequest.Path, 0750)
+	if err != nil {
+		err = fmt.Errorf("Failed creating the device mount directory %v", err)
+		return c.logger.ErrorRet(err, "failed")
+	}
+	err = k8sutils.BindMount(c.exec, realMountPoint, mountDeviceRequest.Path, false)
+	if err != nil {
+		return c.logger.ErrorRet(err, "BindMount failed")
+	}
+
+	c.logger.Debug("Device mounted successfully", logs.Args{{"realMountPoint", realMountPoint}, {"path", mountDeviceRequest.Path}})
+	return nil
+}
+
+func (c *Controller) doUnmountDevice(unmountDeviceRequest k8sresources.FlexVolumeUnmountDeviceRequest) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	deviceMountPath := unmountDeviceRequest.Name
+	mounted, err := c.mountTable.IsMountPoint(deviceMountPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "IsMountPoint failed")
+	}
+	if !mounted {
+		c.logger.Debug("Device is not mounted (skipping)", logs.Args{{"path", deviceMountPath}})
+		return nil
+	}
+
+	// The ubiquity mountpoint shares the device of the global path, any other ref is a pod that still uses the volume
+	refs, err := c.mountTable.GetMountRefs(deviceMountPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "GetMountRefs failed")
+	}
+	ubiquityMountPrefix := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "")
+	var podRefs []string
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref, ubiquityMountPrefix) {
+			podRefs = append(podRefs, ref)
+		}
+	}
+	if len(podRefs) > 0 {
+		err = fmt.Errorf("Cannot unmount device [%s], it is still bind mounted on %v", deviceMountPath, podRefs)
+		return c.logger.ErrorRet(err, "failed")
+	}
+
+	err = k8sutils.Unmount(c.exec, deviceMountPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "Unmount failed")
+	}
+
+	pvName := volumeNameFromUniqueName(path.Base(deviceMountPath))
+	credentials, err := c.credentialsOfVolume(pvName)
+	if err != nil {
+		return c.logger.ErrorRet(err, "credentialsOfVolume failed")
+	}
+	err = c.doUnmountVolume(pvName, credentials)
+	if err != nil {
+		return c.logger.ErrorRet(err, "doUnmountVolume failed")
+	}
+
+	return c.doLegacyDetach(k8sresources.FlexVolumeUnmountRequest{MountPath: deviceMountPath}, credentials)
+}
+
+// getDeviceMountPath returns the kubelet global path of the volume if mountdevice mounted it, or empty string
+func (c *Controller) getDeviceMountPath(volumeName string) (string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	mountpoints, err := c.mountTable.ListMountPoints()
+	if err != nil {
+		return "", c.logger.ErrorRet(err, "ListMountPoints failed")
+	}
+	// the directory is named after the GetVolumeName output, or after the PV name with older kubelets
+	deviceMountDir := "/" + k8sresources.FlexDeviceMountDir
+	for mountpoint := range mountpoints {
+		if strings.HasSuffix(path.Dir(mountpoint), deviceMountDir) && volumeNameFromUniqueName(path.Base(mountpoint)) == volumeName {
+			return mountpoint, nil
+		}
+	}
+	return "", nil
+}
+
+func (c *Controller) doBindMountToPod(deviceMountPath string, podMountPath string) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	mounted, err := c.mountTable.IsMountPoint(podMountPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "IsMountPoint failed")
+	}
+	if mounted {
+		// a replay of a mount that went through already, the pod path shares the device and the root of its source
+		refs, err := c.mountTable.GetMountRefs(podMountPath)
+		if err != nil {
+			return c.logger.ErrorRet(err, "GetMountRefs failed")
+		}
+		if !containsString(refs, filepath.Clean(deviceMountPath)) {
+			err = NewControllerError(k8sresources.ErrorCodeInvalidRequest, "[%s] is already mounted from another source than [%s]", podMountPath, deviceMountPath)
+			return c.logger.ErrorRet(err, "failed")
+		}
+		c.logger.Debug("Volume already bind mounted", logs.Args{{"path", podMountPath}})
+		return nil
+	}
+
+	// kubelet creates the pod directory before calling mount
+	err = c.exec.MkdirAll(podMountPath, 0750)
+	if err != nil {
+		err = fmt.Errorf("Failed creating volume directory %v", err)
+		return c.logger.ErrorRet(err, "failed")
+	}
+	err = k8sutils.BindMount(c.exec, deviceMountPath, podMountPath, false)
+	if err != nil {
+		return c.logger.ErrorRet(err, "BindMount failed")
+	}
+
+	c.logger.Debug("Volume bind mounted successfully", logs.Args{{"deviceMountPath", deviceMountPath}, {"podMountPath", podMountPath}})
+	return nil
+}
+
+func (c *Controller) doLegacyDetach(unmountRequest k8sresources.FlexVolumeUnmountRequest, credentials resources.CredentialInfo) error {
 	defer c.logger.Trace(logs.DEBUG)()
 	var err error
 
 	pvName := path.Base(unmountRequest.MountPath)
 	detachRequest := k8sresources.FlexVolumeDetachRequest{Name: pvName}
-	err = c.doDetach(detachRequest, false)
+	err = c.doDetach(detachRequest, false, credentials)
 	if err != nil {
 		return c.logger.ErrorRet(err, "failed")
 	} else {
-		err = c.doAfterDetach(detachRequest)
+		err = c.doAfterDetach(detachRequest, credentials)
 		if err != nil {
 			return c.logger.ErrorRet(err, "failed")
 		}
@@ -365,8 +746,22 @@ func (c *Controller) doLegacyDetach(unmountRequest k8sresources.FlexVolumeUnmoun
 	return nil
 }
 
+//SetMountTable is made for unit testing purposes where we can pass a fake mount table
+func (c *Controller) SetMountTable(mountTable MountTable) {
+	c.mountTable = mountTable
+}
+
+//SetMounterForBackend is made for unit testing purposes where we can pass a fake mounter
+func (c *Controller) SetMounterForBackend(backend string, backendMounter resources.Mounter) {
+	c.mounterPerBackendLock.Lock()
+	defer c.mounterPerBackendLock.Unlock()
+	c.mounterPerBackend[backend] = backendMounter
+}
+
 func (c *Controller) getMounterForBackend(backend string) (resources.Mounter, error) {
 	defer c.logger.Trace(logs.DEBUG)()
+	c.mounterPerBackendLock.Lock()
+	defer c.mounterPerBackendLock.Unlock()
 	mounterInst, ok := c.mounterPerBackend[backend]
 	if ok {
 		return mounterInst, nil
@@ -377,7 +772,7 @@ func (c *Controller) getMounterForBackend(backend string) (resources.Mounter, er
 	} else if backend == resources.SCBE {
 		c.mounterPerBackend[backend] = mounter.NewScbeMounter(c.config.ScbeRemoteConfig)
 	} else {
-		err := fmt.Errorf("Mounter not found for backend: %s", backend)
+		err := NewControllerError(k8sresources.ErrorCodeBackendNotFound, "Mounter not found for backend: %s", backend)
 		return nil, c.logger.ErrorRet(err, "failed")
 	}
 	return c.mounterPerBackend[backend], nil
@@ -387,23 +782,30 @@ func (c *Controller) doMount(mountRequest k8sresources.FlexVolumeMountRequest) (
 	defer c.logger.Trace(logs.DEBUG)()
 
 	name := mountRequest.MountDevice
-	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: name}
+	credentials, err := credentialsFromOptions(mountRequest.Opts)
+	if err != nil {
+		return "", c.logger.ErrorRet(err, "credentialsFromOptions failed")
+	}
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: name, CredentialInfo: credentials}
 	volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
 	if err != nil {
 		return "", c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
 	}
 
-	getVolumeRequest := resources.GetVolumeRequest{Name: name}
+	getVolumeRequest := resources.GetVolumeRequest{Name: name, CredentialInfo: credentials}
 	volume, err := c.Client.GetVolume(getVolumeRequest)
+	if err != nil {
+		return "", c.logger.ErrorRet(err, "Client.GetVolume failed")
+	}
 	mounter, err := c.getMounterForBackend(volume.Backend)
 	if err != nil {
-		err = fmt.Errorf("Error determining mounter for volume: %s", err.Error())
+		err = wrapError(err, "Error determining mounter for volume")
 		return "", c.logger.ErrorRet(err, "getMounterForBackend failed")
 	}
 
 	wwn, ok := mountRequest.Opts["Wwn"]
 	if !ok {
-		err = fmt.Errorf("mountRequest.Opts[Wwn] not found")
+		err = NewControllerError(k8sresources.ErrorCodeInvalidRequest, "mountRequest.Opts[Wwn] not found")
 		return "", c.logger.ErrorRet(err, "failed")
 	}
 
@@ -422,6 +824,16 @@ func (c *Controller) doAfterMount(mountRequest k8sresources.FlexVolumeMountReque
 	var lnPath string
 	var err error
 
+	// a replay of a mount that linked the pod path already, e.g kubelet retries after a timeout
+	linked, err := isSymlinkTo(podSymlinkPath(mountRequest, mountedPath), mountedPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "isSymlinkTo failed")
+	}
+	if linked {
+		c.logger.Debug("Volume already linked to the pod (skipping)", logs.Args{{"mountedPath", mountedPath}, {"mountPath", mountRequest.MountPath}})
+		return nil
+	}
+
 	if mountRequest.Version == k8sresources.KubernetesVersion_1_5 {
 		//For k8s 1.5, by the time we do the attach/mount, the mountDir (MountPath) is not created trying to do mount and ln will fail because the dir is not found, so we need to create the directory before continuing
 		dir := filepath.Dir(mountRequest.MountPath)
@@ -432,7 +844,7 @@ func (c *Controller) doAfterMount(mountRequest k8sresources.FlexVolumeMountReque
 				c.logger.Debug("creating volume directory", logs.Args{{"dir", dir}})
 				err = os.MkdirAll(dir, 0777)
 				if err != nil && !os.IsExist(err) {
-					err = fmt.Errorf("Failed creating volume directory %#v", err)
+					err = fmt.Errorf("Failed creating volume directory %v", err)
 					return c.logger.ErrorRet(err, "failed")
 				}
 			}
@@ -447,8 +859,8 @@ func (c *Controller) doAfterMount(mountRequest k8sresources.FlexVolumeMountReque
 		}
 		c.logger.Debug("removing folder", logs.Args{{"folder", mountRequest.MountPath}})
 		err = os.Remove(mountRequest.MountPath)
-		if err != nil && !os.IsExist(err) {
-			err = fmt.Errorf("Failed removing existing volume directory %#v", err)
+		if err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("Failed removing existing volume directory %v", err)
 			return c.logger.ErrorRet(err, "failed")
 		}
 	}
@@ -461,109 +873,497 @@ func (c *Controller) doAfterMount(mountRequest k8sresources.FlexVolumeMountReque
 	cmd.Stderr = &stderr
 	err = cmd.Run()
 	if err != nil {
-		err = fmt.Errorf("Controller: mount failed to symlink %#v", stderr.String())
-		c.logger.ErrorRet(err, "failed")
+		err = fmt.Errorf("Controller: mount failed to symlink %v", stderr.String())
+		return c.logger.ErrorRet(err, "failed")
 	}
 
 	c.logger.Debug("Volume mounted successfully", logs.Args{{"mountedPath", mountedPath}})
 	return nil
 }
 
-func (c *Controller) doUnmountScbe(unmountRequest k8sresources.FlexVolumeUnmountRequest, realMountPoint string) error {
+// podSymlinkPath returns the path of the symlink doAfterMount creates for the pod, the link is named after the mountpoint when ln gets the pod directory
+func podSymlinkPath(mountRequest k8sresources.FlexVolumeMountRequest, mountedPath string) string {
+	ubiquityMountPrefix := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "")
+	if mountRequest.Version == k8sresources.KubernetesVersion_1_5 || strings.HasPrefix(mountedPath, ubiquityMountPrefix) {
+		return mountRequest.MountPath
+	}
+	return path.Join(path.Dir(mountRequest.MountPath), path.Base(mountedPath))
+}
+
+// isSymlinkTo tells whether linkPath is already a symlink to target, a symlink to anything else is an error
+func isSymlinkTo(linkPath string, target string) (bool, error) {
+	info, err := os.Lstat(linkPath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+	linkTarget, err := os.Readlink(linkPath)
+	if err != nil {
+		return false, err
+	}
+	if filepath.Clean(linkTarget) != filepath.Clean(target) {
+		return false, NewControllerError(k8sresources.ErrorCodeInvalidRequest, "[%s] is already a symlink to [%s] rather than to [%s]", linkPath, linkTarget, target)
+	}
+	return true, nil
+}
+
+// getPodMountLayout returns the layout of the pod volume path, from the storage class parameter if any or else from the backend setting
+func (c *Controller) getPodMountLayout(mountRequest k8sresources.FlexVolumeMountRequest) (string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	podMountLayout, ok := mountRequest.Opts[k8sresources.OptionNamePodMountLayout]
+	if !ok {
+		backend, ok := mountRequest.Opts["backend"]
+		if !ok {
+			credentials, err := credentialsFromOptions(mountRequest.Opts)
+			if err != nil {
+				return "", c.logger.ErrorRet(err, "credentialsFromOptions failed")
+			}
+			getVolumeRequest := resources.GetVolumeRequest{Name: mountRequest.MountDevice, CredentialInfo: credentials}
+			volume, err := c.Client.GetVolume(getVolumeRequest)
+			if err != nil {
+				return "", c.logger.ErrorRet(err, "Client.GetVolume failed")
+			}
+			backend = volume.Backend
+		}
+		podMountLayout = c.podMountLayouts[backend]
+	}
+
+	switch podMountLayout {
+	case "", k8sresources.PodMountLayoutSymlink:
+		return k8sresources.PodMountLayoutSymlink, nil
+	case k8sresources.PodMountLayoutBind:
+		return k8sresources.PodMountLayoutBind, nil
+	default:
+		err := NewControllerError(k8sresources.ErrorCodeInvalidRequest, "Invalid %s [%s] of volume [%s], expected %s or %s", k8sresources.OptionNamePodMountLayout, podMountLayout, mountRequest.MountDevice, k8sresources.PodMountLayoutSymlink, k8sresources.PodMountLayoutBind)
+		return "", c.logger.ErrorRet(err, "failed")
+	}
+}
+
+// doUnmountBindMount unmounts a pod path of the bind layout, and tears down the volume when no other pod nor the mountdevice global path uses it anymore
+func (c *Controller) doUnmountBindMount(unmountRequest k8sresources.FlexVolumeUnmountRequest, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	// the refs must be read before the unmount, the pod path is the way to find the real mountpoint
+	refs, err := c.mountTable.GetMountRefs(unmountRequest.MountPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "GetMountRefs failed")
+	}
+	err = k8sutils.Unmount(c.exec, unmountRequest.MountPath)
+	if err != nil {
+		return c.logger.ErrorRet(err, "Unmount failed")
+	}
+
+	deviceMountDir := "/" + k8sresources.FlexDeviceMountDir
+	for _, ref := range refs {
+		if strings.HasSuffix(path.Dir(ref), deviceMountDir) {
+			c.logger.Debug("Volume is mounted by mountdevice, unmountdevice will tear it down", logs.Args{{"deviceMountPath", ref}})
+			return nil
+		}
+	}
+
+	// the real mountpoint is told by ubiquity, the one of a Spectrum Scale fileset is not under /ubiquity
+	pvName := path.Base(unmountRequest.MountPath)
+	volume, volumeMountpoint, err := c.getVolumeMountpoint(pvName, credentials)
+	if err != nil {
+		return c.logger.ErrorRet(err, "getVolumeMountpoint failed")
+	}
+	var podRefs []string
+	for _, ref := range refs {
+		if filepath.Clean(ref) != volumeMountpoint {
+			podRefs = append(podRefs, ref)
+		}
+	}
+	if len(podRefs) > 0 {
+		c.logger.Debug("Volume is still used by other pods (skipping the volume unmount)", logs.Args{{"refs", podRefs}})
+		return nil
+	}
+
+	if volume.Backend == resources.SCBE {
+		// SCBE backend flow
+		err = c.doUnmountVolume(pvName, credentials)
+		if err != nil {
+			return c.logger.ErrorRet(err, "doUnmountVolume failed")
+		}
+	} else {
+		// SSC backend flow
+		err = c.doDetachSsc(unmountRequest, volume, credentials)
+		if err != nil {
+			return c.logger.ErrorRet(err, "doDetachSsc failed")
+		}
+	}
+
+	return c.doLegacyDetach(unmountRequest, credentials)
+}
+
+// getVolumeMountpoint returns the volume and its real mountpoint on the node: the one of its WWN for SCBE, the one ubiquity tells for the other backends
+func (c *Controller) getVolumeMountpoint(pvName string, credentials resources.CredentialInfo) (resources.Volume, string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	getVolumeRequest := resources.GetVolumeRequest{Name: pvName, CredentialInfo: credentials}
+	volume, err := c.Client.GetVolume(getVolumeRequest)
+	if err != nil {
+		return resources.Volume{}, "", c.logger.ErrorRet(err, "Client.GetVolume failed")
+	}
+	if volume.Backend != resources.SCBE {
+		return volume, filepath.Clean(volume.Mountpoint), nil
+	}
+
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: pvName, CredentialInfo: credentials}
+	volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
+	if err != nil {
+		return resources.Volume{}, "", c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
+	}
+	wwn, ok := volumeConfig["Wwn"].(string)
+	if !ok {
+		err = fmt.Errorf("Volume [%s] has no Wwn in its config", pvName)
+		return resources.Volume{}, "", c.logger.ErrorRet(err, "failed")
+	}
+	return volume, fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, wwn), nil
+}
+
+func (c *Controller) doUnmountScbe(unmountRequest k8sresources.FlexVolumeUnmountRequest, realMountPoint string, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	pvName := path.Base(unmountRequest.MountPath)
+	err := c.doUnmountVolume(pvName, credentials)
+	if err != nil {
+		return c.logger.ErrorRet(err, "doUnmountVolume failed")
+	}
+
+	c.logger.Debug(fmt.Sprintf("Removing the slink [%s] to the real mountpoint [%s]", unmountRequest.MountPath, realMountPoint))
+	err = c.exec.Remove(unmountRequest.MountPath)
+	if err != nil {
+		err = fmt.Errorf("fail to remove slink %s. Error %v", unmountRequest.MountPath, err)
+		return c.logger.ErrorRet(err, "exec.Remove failed")
+	}
+
+	return nil
+}
+
+// doUnmountDanglingSymlink removes the symlink of an unmounted volume, and detaches the volume if the backend did not yet
+func (c *Controller) doUnmountDanglingSymlink(unmountRequest k8sresources.FlexVolumeUnmountRequest, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	c.logger.Debug("Removing the slink to the unmounted volume", logs.Args{{"mountPath", unmountRequest.MountPath}})
+	err := c.exec.Remove(unmountRequest.MountPath)
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("fail to remove slink %s. Error %v", unmountRequest.MountPath, err)
+		return c.logger.ErrorRet(err, "exec.Remove failed")
+	}
+	return c.doUnmountAbsentPath(unmountRequest, credentials)
+}
+
+// doUnmountAbsentPath replays an unmount whose pod path is gone already. It succeeds once the backend detached the volume,
+// it detaches it when the previous unmount stopped before, unless other pods of the node still use its mountpoint.
+func (c *Controller) doUnmountAbsentPath(unmountRequest k8sresources.FlexVolumeUnmountRequest, credentials resources.CredentialInfo) error {
 	defer c.logger.Trace(logs.DEBUG)()
 
 	pvName := path.Base(unmountRequest.MountPath)
-	getVolumeRequest := resources.GetVolumeRequest{Name: pvName}
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: pvName, CredentialInfo: credentials}
+	volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
+	if err != nil {
+		if ErrorCode(err) == k8sresources.ErrorCodeVolumeNotFound {
+			c.logger.Debug("Pod path absent and volume deleted, nothing left to unmount", logs.Args{{"mountPath", unmountRequest.MountPath}})
+			return nil
+		}
+		return c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
+	}
+	attachTo, _ := volumeConfig[resources.ScbeKeyVolAttachToHost].(string)
+	if attachTo == "" {
+		c.logger.Debug("Pod path absent and volume detached, nothing left to unmount", logs.Args{{"mountPath", unmountRequest.MountPath}})
+		return nil
+	}
+	// the detach fills an empty host with the one the volume is attached to, a stale node must not detach it from the node running the pod now
+	if host := getHost(""); !IsAttachedTo(attachTo, host) {
+		c.logger.Debug("Pod path absent and volume attached to another node, nothing left to unmount", logs.Args{{"mountPath", unmountRequest.MountPath}, {"attachTo", attachTo}, {"host", host}})
+		return nil
+	}
+
+	if wwn, ok := volumeConfig["Wwn"].(string); ok {
+		mounted, err := c.mountTable.IsMountPoint(fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, wwn))
+		if err != nil {
+			return c.logger.ErrorRet(err, "IsMountPoint failed")
+		}
+		if mounted {
+			// the symlinks of other pods do not show as mount refs, the orphan cleanup tears the mountpoint down once no live pod uses it
+			c.logger.Debug("Pod path absent and volume still mounted on the node (skipping the detach)", logs.Args{{"mountPath", unmountRequest.MountPath}, {"attachTo", attachTo}})
+			return nil
+		}
+	}
+
+	c.logger.Debug("Pod path absent and volume still attached, completing the detach", logs.Args{{"mountPath", unmountRequest.MountPath}, {"attachTo", attachTo}})
+	return c.doLegacyDetach(unmountRequest, credentials)
+}
+
+func (c *Controller) doUnmountVolume(pvName string, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
 
+	getVolumeRequest := resources.GetVolumeRequest{Name: pvName, CredentialInfo: credentials}
 	volume, err := c.Client.GetVolume(getVolumeRequest)
+	if err != nil {
+		return c.logger.ErrorRet(err, "Client.GetVolume failed")
+	}
 	mounter, err := c.getMounterForBackend(volume.Backend)
 	if err != nil {
-		err = fmt.Errorf("Error determining mounter for volume: %s", err.Error())
+		err = wrapError(err, "Error determining mounter for volume")
 		return c.logger.ErrorRet(err, "failed")
 	}
 
-	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: pvName}
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: pvName, CredentialInfo: credentials}
 	volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
 	if err != nil {
-		err = fmt.Errorf("Error unmount for volume: %s", err.Error())
+		err = wrapError(err, "Error unmount for volume")
 		return c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
 	}
 
+	if volume.Backend == resources.SCBE {
+		// the SCBE mounter flushes the multipath device and removes its SCSI devices
+		unlock, err := c.lockRescan()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
 	ubUnmountRequest := resources.UnmountRequest{VolumeConfig: volumeConfig}
 	err = mounter.Unmount(ubUnmountRequest)
 	if err != nil {
 		return c.logger.ErrorRet(err, "mounter.Unmount failed")
 	}
 
-	c.logger.Debug(fmt.Sprintf("Removing the slink [%s] to the real mountpoint [%s]", unmountRequest.MountPath, realMountPoint))
-	err = c.exec.Remove(unmountRequest.MountPath)
+	return nil
+}
+
+func (c *Controller) doAfterDetach(detachRequest k8sresources.FlexVolumeDetachRequest, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	getVolumeRequest := resources.GetVolumeRequest{Name: detachRequest.Name, CredentialInfo: credentials}
+	volume, err := c.Client.GetVolume(getVolumeRequest)
 	if err != nil {
-		err = fmt.Errorf("fail to remove slink %s. Error %#v", unmountRequest.MountPath, err)
-		return c.logger.ErrorRet(err, "exec.Remove failed")
+		return c.logger.ErrorRet(err, "Client.GetVolume failed")
+	}
+	mounter, err := c.getMounterForBackend(volume.Backend)
+	if err != nil {
+		err = wrapError(err, "Error determining mounter for volume")
+		return c.logger.ErrorRet(err, "failed")
+	}
+
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: detachRequest.Name, CredentialInfo: credentials}
+	volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
+	if err != nil {
+		err = wrapError(err, "Error for volume")
+		return c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
+	}
+
+	if volume.Backend == resources.SCBE {
+		// the SCBE mounter rescans to clean the devices of the detached LUN
+		unlock, err := c.lockRescan()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	afterDetachRequest := resources.AfterDetachRequest{VolumeConfig: volumeConfig}
+	if err := mounter.ActionAfterDetach(afterDetachRequest); err != nil {
+		err = wrapError(err, "Error execute action after detaching the volume")
+		return c.logger.ErrorRet(err, "mounter.ActionAfterDetach failed")
+	}
+
+	return nil
+}
+
+func (c *Controller) doUnmountSsc(unmountRequest k8sresources.FlexVolumeUnmountRequest, realMountPoint string, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	listVolumeRequest := resources.ListVolumesRequest{CredentialInfo: credentials}
+	volumes, err := c.Client.ListVolumes(listVolumeRequest)
+	if err != nil {
+		err = fmt.Errorf("Error getting the volume list from ubiquity server %v", err)
+		return c.logger.ErrorRet(err, "failed")
+	}
+
+	volume, err := getVolumeForMountpoint(unmountRequest.MountPath, volumes)
+	if err != nil {
+		err = fmt.Errorf(
+			"Error finding the volume with mountpoint [%s] from the list of ubiquity volumes %v. Error is : %v",
+			unmountRequest.MountPath,
+			volumes,
+			err)
+		return c.logger.ErrorRet(err, "failed")
+	}
+
+	return c.doDetachSsc(unmountRequest, volume, credentials)
+}
+
+// doDetachSsc detaches the volume of a Spectrum Scale backend, a fileset already unlinked counts as detached
+func (c *Controller) doDetachSsc(unmountRequest k8sresources.FlexVolumeUnmountRequest, volume resources.Volume, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	detachRequest := resources.DetachRequest{Name: volume.Name, CredentialInfo: credentials}
+	err := c.Client.Detach(detachRequest)
+	if err != nil && err.Error() != "fileset not linked" {
+		err = fmt.Errorf(
+			"Failed to unmount volume [%s] on mountpoint [%s]. Error: %v",
+			volume.Name,
+			unmountRequest.MountPath,
+			err)
+		return c.logger.ErrorRet(err, "failed")
 	}
 
 	return nil
 }
 
-func (c *Controller) doAfterDetach(detachRequest k8sresources.FlexVolumeDetachRequest) error {
-    defer c.logger.Trace(logs.DEBUG)()
-
-    getVolumeRequest := resources.GetVolumeRequest{Name: detachRequest.Name}
-    volume, err := c.Client.GetVolume(getVolumeRequest)
-    mounter, err := c.getMounterForBackend(volume.Backend)
-    if err != nil {
-        err = fmt.Errorf("Error determining mounter for volume: %s", err.Error())
-        return c.logger.ErrorRet(err, "failed")
-    }
-
-    getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: detachRequest.Name}
-    volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
-    if err != nil {
-        err = fmt.Errorf("Error for volume: %s", err.Error())
-        return c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
-    }
-
-    afterDetachRequest := resources.AfterDetachRequest{VolumeConfig: volumeConfig}
-    if err := mounter.ActionAfterDetach(afterDetachRequest); err != nil {
-        err = fmt.Errorf("Error execute action after detaching the volume : %#v", err)
-        return c.logger.ErrorRet(err, "mounter.ActionAfterDetach failed")
-    }
-
-    return nil
-}
-
-func (c *Controller) doUnmountSsc(unmountRequest k8sresources.FlexVolumeUnmountRequest, realMountPoint string) error {
-    defer c.logger.Trace(logs.DEBUG)()
-
-    listVolumeRequest := resources.ListVolumesRequest{}
-    volumes, err := c.Client.ListVolumes(listVolumeRequest)
-    if err != nil {
-        err = fmt.Errorf("Error getting the volume list from ubiquity server %#v", err)
-        return c.logger.ErrorRet(err, "failed")
-    }
-
-    volume, err := getVolumeForMountpoint(unmountRequest.MountPath, volumes)
-    if err != nil {
-        err = fmt.Errorf(
-            "Error finding the volume with mountpoint [%s] from the list of ubiquity volumes %#v. Error is : %#v",
-            unmountRequest.MountPath,
-            volumes,
-            err)
-        return c.logger.ErrorRet(err, "failed")
-    }
-
-    detachRequest := resources.DetachRequest{Name: volume.Name}
-    err = c.Client.Detach(detachRequest)
-    if err != nil && err.Error() != "fileset not linked" {
-        err = fmt.Errorf(
-            "Failed to unmount volume [%s] on mountpoint [%s]. Error: %#v",
-            volume.Name,
-            unmountRequest.MountPath,
-            err)
-        return c.logger.ErrorRet(err, "failed")
-    }
-
-    return nil
+func (c *Controller) doWaitForAttach(waitForAttachRequest k8sresources.FlexVolumeWaitForAttachRequest) (string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	volumeName, ok := waitForAttachRequest.Opts["volumeName"]
+	if !ok {
+		err := NewControllerError(k8sresources.ErrorCodeInvalidRequest, "volumeName not found in waitForAttachRequest")
+		return "", c.logger.ErrorRet(err, "failed")
+	}
+
+	timeout, backoff, maxBackoff := c.getWaitForAttachTimeouts()
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		// the rescan is slow and holds the rescan lock of the node, it runs at the attempts 1, 2, 4, 8... while multipath is polled at every attempt
+		rescan := attempt&(attempt-1) == 0
+		devicePath, found, err := c.findAttachedDevice(volumeName, waitForAttachRequest.Opts, rescan)
+		if err != nil {
+			// keep polling, the error is reported only if the device never shows up
+			c.logger.Debug("findAttachedDevice failed", logs.Args{{"attempt", attempt}, {"error", err}})
+		} else if found {
+			c.logger.Debug("Volume device found", logs.Args{{"volume", volumeName}, {"device", devicePath}, {"attempt", attempt}})
+			return devicePath, nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			msg := fmt.Sprintf("Timeout waiting for volume [%s] to be attached, the device did not appear on the node after %s", volumeName, timeout)
+			if wwn, ok := waitForAttachRequest.Opts["Wwn"]; ok {
+				msg = fmt.Sprintf("Timeout waiting for volume [%s] to be attached, the LUN with WWN [%s] did not appear on the node after %s", volumeName, wwn, timeout)
+			}
+			if err != nil {
+				msg = fmt.Sprintf("%s. Last error: %v", msg, err)
+			}
+			return "", c.logger.ErrorRet(NewControllerError(k8sresources.ErrorCodeTimeout, "%s", msg), "failed")
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Controller) getWaitForAttachTimeouts() (time.Duration, time.Duration, time.Duration) {
+	timeoutSeconds := c.waitForAttachConfig.TimeoutSeconds
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = k8sresources.WaitForAttachDefaultTimeoutSeconds
+	}
+	initialBackoff := c.waitForAttachConfig.InitialBackoffMilliseconds
+	if initialBackoff <= 0 {
+		initialBackoff = k8sresources.WaitForAttachDefaultInitialBackoffMilliseconds
+	}
+	maxBackoff := c.waitForAttachConfig.MaxBackoffMilliseconds
+	if maxBackoff <= 0 {
+		maxBackoff = k8sresources.WaitForAttachDefaultMaxBackoffMilliseconds
+	}
+	if maxBackoff < initialBackoff {
+		maxBackoff = initialBackoff
+	}
+	return time.Duration(timeoutSeconds) * time.Second, time.Duration(initialBackoff) * time.Millisecond, time.Duration(maxBackoff) * time.Millisecond
+}
+
+// findAttachedDevice looks once for the device of the volume on the node: the multipath device of the Wwn for SCBE, rescanning the iSCSI sessions first if rescan,
+// the fileset link path for Spectrum Scale
+func (c *Controller) findAttachedDevice(volumeName string, opts map[string]string, rescan bool) (string, bool, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	if wwn, ok := opts["Wwn"]; ok {
+		return c.findMultipathDevice(wwn, rescan)
+	}
+
+	credentials, err := credentialsFromOptions(opts)
+	if err != nil {
+		return "", false, c.logger.ErrorRet(err, "credentialsFromOptions failed")
+	}
+	getVolumeRequest := resources.GetVolumeRequest{Name: volumeName, CredentialInfo: credentials}
+	volume, err := c.Client.GetVolume(getVolumeRequest)
+	if err != nil {
+		return "", false, c.logger.ErrorRet(err, "Client.GetVolume failed")
+	}
+	if volume.Backend != resources.SpectrumScale {
+		// nothing is attached to the node for NFS backends, the mount does it all
+		return "", true, nil
+	}
+	if volume.Mountpoint == "" {
+		return "", false, nil
+	}
+	if _, err := c.exec.Stat(volume.Mountpoint); err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, c.logger.ErrorRet(err, "exec.Stat failed")
+	}
+	return volume.Mountpoint, true, nil
+}
+
+func (c *Controller) findMultipathDevice(wwn string, rescan bool) (string, bool, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	if rescan && !c.config.ScbeRemoteConfig.SkipRescanISCSI {
+		unlock, err := c.lockRescan()
+		if err != nil {
+			return "", false, err
+		}
+		output, err := c.exec.Execute("iscsiadm", []string{"-m", "session", "--rescan"})
+		unlock()
+		if err != nil {
+			// FC only hosts have no iscsi sessions, the multipath lookup below is what counts
+			c.logger.Debug("iscsiadm rescan failed", logs.Args{{"output", string(output)}, {"error", err}})
+		}
+	}
+
+	output, err := c.exec.Execute("multipath", []string{"-ll"})
+	if err != nil {
+		err = fmt.Errorf("multipath -ll failed: %s, Error: %v", string(output), err)
+		return "", false, c.logger.ErrorRet(err, "failed")
+	}
+
+	for _, multipathMap := range parseMultipathMaps(output) {
+		if multipathMap.matches(wwn) {
+			return path.Join("/dev/mapper", multipathMap.name), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+type multipathMap struct {
+	name string
+	wwid string
+}
+
+// matches tells whether the map is the one of the LUN with the given WWN, the wwid has a prefix of the WWN type
+func (m multipathMap) matches(wwn string) bool {
+	lowerWwn := strings.ToLower(wwn)
+	return strings.Contains(strings.ToLower(m.name), lowerWwn) || strings.Contains(strings.ToLower(m.wwid), lowerWwn)
+}
+
+// parseMultipathMaps returns the maps of the multipath -ll output
+func parseMultipathMaps(output []byte) []multipathMap {
+	// the map header line is "<name> (<wwid>) dm-<n> <vendor>,<product>", or "<wwid> dm-<n> ..." without friendly names
+	var maps []multipathMap
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(fields[1], "dm-") && !strings.HasPrefix(fields[1], "(") {
+			continue
+		}
+		if strings.HasPrefix(fields[1], "(") {
+			maps = append(maps, multipathMap{name: fields[0], wwid: strings.Trim(fields[1], "()")})
+		} else {
+			maps = append(maps, multipathMap{name: fields[0], wwid: fields[0]})
+		}
+	}
+	return maps
 }
 
 func (c *Controller) doActivate(activateRequest resources.ActivateRequest) error {
@@ -580,23 +1380,94 @@ func (c *Controller) doActivate(activateRequest resources.ActivateRequest) error
 func (c *Controller) doAttach(attachRequest k8sresources.FlexVolumeAttachRequest) error {
 	defer c.logger.Trace(logs.DEBUG)()
 
-	ubAttachRequest := resources.AttachRequest{Name: attachRequest.Name, Host: getHost(attachRequest.Host)}
-	_, err := c.Client.Attach(ubAttachRequest)
+	credentials, err := credentialsFromOptions(attachRequest.Opts)
 	if err != nil {
+		return c.logger.ErrorRet(err, "credentialsFromOptions failed")
+	}
+	ubAttachRequest := resources.AttachRequest{Name: attachRequest.Name, Host: getHost(attachRequest.Host), CredentialInfo: credentials}
+	_, err = c.Client.Attach(ubAttachRequest)
+	if err != nil {
+		// a replay of an attach that went through already
+		if attachTo, hostErr := c.getHostAttached(attachRequest.Name, credentials); hostErr == nil && IsAttachedTo(attachTo, ubAttachRequest.Host) {
+			c.logger.Debug("Volume already attached to the host", logs.Args{{"volume", attachRequest.Name}, {"host", attachTo}})
+			return nil
+		}
 		return c.logger.ErrorRet(err, "Client.Attach failed")
 	}
 
 	return nil
 }
 
-func (c *Controller) doDetach(detachRequest k8sresources.FlexVolumeDetachRequest, checkIfAttached bool) error {
+func (c *Controller) doGetVolumeName(getVolumeNameRequest k8sresources.FlexVolumeGetVolumeNameRequest) (string, error) {
 	defer c.logger.Trace(logs.DEBUG)()
 
+	volumeName, ok := getVolumeNameRequest.Opts["volumeName"]
+	if !ok {
+		err := NewControllerError(k8sresources.ErrorCodeInvalidRequest, "volumeName not found in getVolumeNameRequest")
+		return "", c.logger.ErrorRet(err, "failed")
+	}
+
+	// PVs provisioned before the backend was part of the flex options need a round trip to ubiquity
+	backend, ok := getVolumeNameRequest.Opts["backend"]
+	if !ok {
+		credentials, err := credentialsFromOptions(getVolumeNameRequest.Opts)
+		if err != nil {
+			return "", c.logger.ErrorRet(err, "credentialsFromOptions failed")
+		}
+		getVolumeRequest := resources.GetVolumeRequest{Name: volumeName, CredentialInfo: credentials}
+		volume, err := c.Client.GetVolume(getVolumeRequest)
+		if err != nil {
+			return "", c.logger.ErrorRet(err, "Client.GetVolume failed")
+		}
+		backend = volume.Backend
+	}
+
+	uniqueName := backend + uniqueVolumeNameSeparator + volumeName
+	if backend == resources.SCBE {
+		wwn, ok := getVolumeNameRequest.Opts["Wwn"]
+		if !ok {
+			err := NewControllerError(k8sresources.ErrorCodeInvalidRequest, "Wwn not found in getVolumeNameRequest of the %s volume [%s]", backend, volumeName)
+			return "", c.logger.ErrorRet(err, "failed")
+		}
+		uniqueName += uniqueVolumeNameSeparator + strings.ToLower(wwn)
+	}
+	return uniqueName, nil
+}
+
+// volumeLockKey returns the key of the volume lock out of the path a call-out passes in, named after the PV or after the GetVolumeName output,
+// so that mount, mountdevice, unmount and unmountdevice of a volume take the same lock
+func volumeLockKey(mountPath string) string {
+	return volumeNameFromUniqueName(filepath.Base(mountPath))
+}
+
+// volumeNameFromUniqueName returns the ubiquity volume name out of a GetVolumeName output, any other name is returned as is
+func volumeNameFromUniqueName(uniqueName string) string {
+	parts := strings.SplitN(uniqueName, uniqueVolumeNameSeparator, 2)
+	if len(parts) != 2 {
+		return uniqueName
+	}
+	switch parts[0] {
+	case resources.SCBE:
+		// <backend>_<volume name>_<wwn>, the volume name itself may contain the separator
+		if i := strings.LastIndex(parts[1], uniqueVolumeNameSeparator); i > 0 {
+			return parts[1][:i]
+		}
+		return uniqueName
+	case resources.SpectrumScale, resources.SpectrumScaleNFS, resources.SoftlayerNFS:
+		return parts[1]
+	default:
+		return uniqueName
+	}
+}
+
+func (c *Controller) doDetach(detachRequest k8sresources.FlexVolumeDetachRequest, checkIfAttached bool, credentials resources.CredentialInfo) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	// kubelet passes the GetVolumeName output to detach
+	detachRequest.Name = volumeNameFromUniqueName(detachRequest.Name)
+
 	if checkIfAttached {
-		opts := make(map[string]string)
-		opts["volumeName"] = detachRequest.Name
-		isAttachedRequest := k8sresources.FlexVolumeIsAttachedRequest{Name: "", Host:detachRequest.Host, Opts: opts}
-		isAttached, err := c.doIsAttached(isAttachedRequest)
+		isAttached, err := c.isAttachedToHost(detachRequest.Name, detachRequest.Host, credentials)
 		if err != nil {
 			return c.logger.ErrorRet(err, "failed")
 		}
@@ -608,14 +1479,13 @@ func (c *Controller) doDetach(detachRequest k8sresources.FlexVolumeDetachRequest
 	if host == "" {
 		// only when triggered during unmount
 		var err error
-		host, err = c.getHostAttached(detachRequest.Name)
+		host, err = c.getHostAttached(detachRequest.Name, credentials)
 		if err != nil {
 			return c.logger.ErrorRet(err, "getHostAttached failed")
 		}
 	}
 
-
-	ubDetachRequest := resources.DetachRequest{Name: detachRequest.Name, Host: host}
+	ubDetachRequest := resources.DetachRequest{Name: detachRequest.Name, Host: host, CredentialInfo: credentials}
 	err := c.Client.Detach(ubDetachRequest)
 	if err != nil {
 		return c.logger.ErrorRet(err, "failed")
@@ -629,24 +1499,34 @@ func (c *Controller) doIsAttached(isAttachedRequest k8sresources.FlexVolumeIsAtt
 
 	volName, ok := isAttachedRequest.Opts["volumeName"]
 	if !ok {
-		err := fmt.Errorf("volumeName not found in isAttachedRequest")
+		err := NewControllerError(k8sresources.ErrorCodeInvalidRequest, "volumeName not found in isAttachedRequest")
 		return false, c.logger.ErrorRet(err, "failed")
 	}
 
-	attachTo, err := c.getHostAttached(volName)
+	credentials, err := credentialsFromOptions(isAttachedRequest.Opts)
+	if err != nil {
+		return false, c.logger.ErrorRet(err, "credentialsFromOptions failed")
+	}
+	return c.isAttachedToHost(volName, isAttachedRequest.Host, credentials)
+}
+
+func (c *Controller) isAttachedToHost(volName string, host string, credentials resources.CredentialInfo) (bool, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	attachTo, err := c.getHostAttached(volName, credentials)
 	if err != nil {
 		return false, c.logger.ErrorRet(err, "getHostAttached failed")
 	}
 
-	isAttached := isAttachedRequest.Host == attachTo
-	c.logger.Debug("", logs.Args{{"host", isAttachedRequest.Host}, {"attachTo", attachTo}, {"isAttached", isAttached}})
+	isAttached := IsAttachedTo(attachTo, host)
+	c.logger.Debug("", logs.Args{{"host", host}, {"attachTo", attachTo}, {"isAttached", isAttached}})
 	return isAttached, nil
 }
 
-func (c *Controller) getHostAttached(volName string) (string, error) {
+func (c *Controller) getHostAttached(volName string, credentials resources.CredentialInfo) (string, error) {
 	defer c.logger.Trace(logs.DEBUG)()
 
-	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: volName}
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: volName, CredentialInfo: credentials}
 	volumeConfig, err := c.Client.GetVolumeConfig(getVolumeConfigRequest)
 	if err != nil {
 		return "", c.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
@@ -667,18 +1547,46 @@ func getVolumeForMountpoint(mountpoint string, volumes []resources.Volume) (reso
 			return volume, nil
 		}
 	}
-	return resources.Volume{}, fmt.Errorf("Volume not found")
+	return resources.Volume{}, NewControllerError(k8sresources.ErrorCodeVolumeNotFound, "Volume not found")
+}
+
+//IsAttachedTo tells whether attachTo, the host ubiquity reports the volume attached to, is host. The backends may not keep the case of the host names.
+func IsAttachedTo(attachTo string, host string) bool {
+	return attachTo != "" && strings.EqualFold(attachTo, host)
 }
 
 func getHost(hostRequest string) string {
-    if hostRequest != "" {
-        return hostRequest
-    }
-    // Only in k8s 1.5 this os.Hostname will happened,
-    // because in k8s 1.5 the flex CLI doesn't get the host to attach with. TODO consider to refactor to remove support for 1.5
-    hostname, err := os.Hostname()
-    if err != nil {
-        return ""
-    }
-    return hostname
-}
\ No newline at end of file
+	if hostRequest != "" {
+		return hostRequest
+	}
+	// Only in k8s 1.5 this os.Hostname will happened,
+	// because in k8s 1.5 the flex CLI doesn't get the host to attach with. TODO consider to refactor to remove support for 1.5
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+// reportPodVolumeFailure reports the failure with a reason classified from its message, a failure to report is only logged
+func (c *Controller) reportPodVolumeFailure(pod k8sresources.PodRef, volumeName string, defaultReason string, message string) {
+	if c.podEventReporter == nil || pod.UID == "" {
+		return
+	}
+	reason := k8sutils.ClassifyStorageFailure(message, defaultReason)
+	err := c.podEventReporter.ReportPodVolumeFailure(pod, volumeName, reason, message)
+	if err != nil {
+		c.logger.Error("failed to report the volume failure of the pod", logs.Args{{"pod", pod}, {"reason", reason}, {"error", err}})
+	}
+}
+
+// podUIDFromMountPath returns the pod UID of a pod volume path, /var/lib/kubelet/pods/<uid>/volumes/<driver>/<volume>
+func podUIDFromMountPath(mountPath string) string {
+	parts := strings.Split(mountPath, "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] == "pods" && parts[i+2] == "volumes" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
@@ -17,18 +17,55 @@
 package controller_test
 
 import (
-//	"fmt"
+	"fmt"
 	"os"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	ctl "github.com/IBM/ubiquity-k8s/controller"
-//	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
 	"github.com/IBM/ubiquity/fakes"
 	"github.com/IBM/ubiquity/resources"
 )
 
+type fakePodEventReporter struct {
+	pods    []k8sresources.PodRef
+	reasons []string
+}
+
+func (r *fakePodEventReporter) ReportPodVolumeFailure(pod k8sresources.PodRef, volumeName string, reason string, message string) error {
+	r.pods = append(r.pods, pod)
+	r.reasons = append(r.reasons, reason)
+	return nil
+}
+
+type fakeCredentialsGetter struct {
+	credentials map[string]resources.CredentialInfo
+}
+
+func (g *fakeCredentialsGetter) GetVolumeCredentials(pvName string) (resources.CredentialInfo, error) {
+	return g.credentials[pvName], nil
+}
+
+type fakeMountTable struct {
+	mountpoints map[string]string
+	refs        map[string][]string
+}
+
+func (t *fakeMountTable) IsMountPoint(path string) (bool, error) {
+	_, ok := t.mountpoints[path]
+	return ok, nil
+}
+
+func (t *fakeMountTable) GetMountRefs(path string) ([]string, error) {
+	return t.refs[path], nil
+}
+
+func (t *fakeMountTable) ListMountPoints() (map[string]string, error) {
+	return t.mountpoints, nil
+}
+
 var _ = Describe("Controller", func() {
 
 	var (
@@ -106,6 +143,295 @@ var _ = Describe("Controller", func() {
 		//		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(1))
 		//	})
 	})
+	Context(".MountDevice", func() {
+		It("fails when volumeName is missing in the options", func() {
+			mountDeviceRequest := k8sresources.FlexVolumeMountDeviceRequest{Path: "/tmp/test/globalmount/pv1", Name: "", Opts: map[string]string{}}
+			mountDeviceResponse := controller.MountDevice(mountDeviceRequest)
+			Expect(mountDeviceResponse.Status).To(Equal("Failure"))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(0))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+		})
+		It("fails when the volume config cannot be fetched", func() {
+			fakeClient.GetVolumeConfigReturns(nil, fmt.Errorf("error getting volume config"))
+			mountDeviceRequest := k8sresources.FlexVolumeMountDeviceRequest{Path: "/tmp/test/globalmount/pv1", Opts: map[string]string{"volumeName": "pv1"}}
+			mountDeviceResponse := controller.MountDevice(mountDeviceRequest)
+			Expect(mountDeviceResponse.Status).To(Equal("Failure"))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+		})
+		It("uses the credentials of the secret of the volume", func() {
+			fakeClient.GetVolumeConfigReturns(nil, fmt.Errorf("error getting volume config"))
+			opts := map[string]string{"volumeName": "pv1", "kubernetes.io/secret/username": "YWRtaW4=", "kubernetes.io/secret/password": "c2VjcmV0"}
+			mountDeviceRequest := k8sresources.FlexVolumeMountDeviceRequest{Path: "/tmp/test/globalmount/pv1", Opts: opts}
+			mountDeviceResponse := controller.MountDevice(mountDeviceRequest)
+			Expect(mountDeviceResponse.Status).To(Equal("Failure"))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(1))
+			Expect(fakeClient.GetVolumeConfigArgsForCall(0).CredentialInfo).To(Equal(resources.CredentialInfo{UserName: "admin", Password: "secret"}))
+		})
+		It("fails when the secret of the volume has no password", func() {
+			opts := map[string]string{"volumeName": "pv1", "kubernetes.io/secret/username": "YWRtaW4="}
+			mountDeviceRequest := k8sresources.FlexVolumeMountDeviceRequest{Path: "/tmp/test/globalmount/pv1", Opts: opts}
+			mountDeviceResponse := controller.MountDevice(mountDeviceRequest)
+			Expect(mountDeviceResponse.Status).To(Equal("Failure"))
+			Expect(mountDeviceResponse.Code).To(Equal(k8sresources.ErrorCodeInvalidRequest))
+			Expect(mountDeviceResponse.Retryable).To(BeFalse())
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(0))
+		})
+	})
+	Context(".Attach", func() {
+		It("attaches with the credentials of the secret of the volume", func() {
+			opts := map[string]string{"volumeName": "pv1", "kubernetes.io/secret/username": "YWRtaW4=", "kubernetes.io/secret/password": "c2VjcmV0"}
+			attachResponse := controller.Attach(k8sresources.FlexVolumeAttachRequest{Name: "pv1", Host: "node1", Opts: opts})
+			Expect(attachResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.AttachCallCount()).To(Equal(1))
+			Expect(fakeClient.AttachArgsForCall(0).CredentialInfo).To(Equal(resources.CredentialInfo{UserName: "admin", Password: "secret"}))
+		})
+		It("attaches with the credentials of the node without a secret", func() {
+			attachResponse := controller.Attach(k8sresources.FlexVolumeAttachRequest{Name: "pv1", Host: "node1", Opts: map[string]string{"volumeName": "pv1"}})
+			Expect(attachResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.AttachArgsForCall(0).CredentialInfo).To(Equal(resources.CredentialInfo{}))
+		})
+		It("succeeds on a replay when the backend reports the host in another case", func() {
+			fakeClient.AttachReturns("", fmt.Errorf("volume pv1 is already attached to host NODE1"))
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: "NODE1"}, nil)
+			attachResponse := controller.Attach(k8sresources.FlexVolumeAttachRequest{Name: "pv1", Host: "node1", Opts: map[string]string{"volumeName": "pv1"}})
+			Expect(attachResponse.Status).To(Equal("Success"))
+			isAttachedResponse := controller.IsAttached(k8sresources.FlexVolumeIsAttachedRequest{Host: "node1", Opts: map[string]string{"volumeName": "pv1"}})
+			Expect(isAttachedResponse.Attached).To(BeTrue())
+		})
+	})
+	Context(".GetVolumeName", func() {
+		It("fails when volumeName is missing in the options", func() {
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"backend": resources.SCBE}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Failure"))
+			Expect(getVolumeNameResponse.Code).To(Equal(k8sresources.ErrorCodeInvalidRequest))
+			Expect(fakeClient.GetVolumeCallCount()).To(Equal(0))
+		})
+		It("returns the backend, the volume name and the Wwn for SCBE volumes", func() {
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"volumeName": "pv1", "backend": resources.SCBE, "Wwn": "6005076306FFD6B60000000000002A1B"}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Success"))
+			Expect(getVolumeNameResponse.VolumeName).To(Equal("scbe_pv1_6005076306ffd6b60000000000002a1b"))
+			Expect(fakeClient.GetVolumeCallCount()).To(Equal(0))
+		})
+		It("fails when the Wwn is missing for SCBE volumes", func() {
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"volumeName": "pv1", "backend": resources.SCBE}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Failure"))
+			Expect(getVolumeNameResponse.Code).To(Equal(k8sresources.ErrorCodeInvalidRequest))
+		})
+		It("returns the backend and the volume name for Spectrum Scale volumes", func() {
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"volumeName": "pv1", "backend": resources.SpectrumScale, "filesystem": "gold"}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Success"))
+			Expect(getVolumeNameResponse.VolumeName).To(Equal("spectrum-scale_pv1"))
+		})
+		It("returns the backend and the volume name for NFS volumes", func() {
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"volumeName": "pv1", "backend": resources.SoftlayerNFS}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Success"))
+			Expect(getVolumeNameResponse.VolumeName).To(Equal("softlayer-nfs_pv1"))
+		})
+		It("gets the backend from ubiquity when it is missing in the options", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SpectrumScaleNFS}, nil)
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"volumeName": "pv1"}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Success"))
+			Expect(getVolumeNameResponse.VolumeName).To(Equal("spectrum-scale-nfs_pv1"))
+			Expect(fakeClient.GetVolumeArgsForCall(0).Name).To(Equal("pv1"))
+		})
+		It("fails when the backend is missing and the volume cannot be fetched", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("error getting volume"))
+			getVolumeNameRequest := k8sresources.FlexVolumeGetVolumeNameRequest{Opts: map[string]string{"volumeName": "pv1"}}
+			getVolumeNameResponse := controller.GetVolumeName(getVolumeNameRequest)
+			Expect(getVolumeNameResponse.Status).To(Equal("Failure"))
+		})
+	})
+	Context(".Detach", func() {
+		It("detaches the ubiquity volume of the unique name kubelet passes in", func() {
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: "node1"}, nil)
+			detachRequest := k8sresources.FlexVolumeDetachRequest{Name: "scbe_pv1_6005076306ffd6b60000000000002a1b", Host: "node1", Version: k8sresources.KubernetesVersion_1_6OrLater}
+			detachResponse := controller.Detach(detachRequest)
+			Expect(detachResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.DetachCallCount()).To(Equal(1))
+			Expect(fakeClient.DetachArgsForCall(0).Name).To(Equal("pv1"))
+		})
+		It("detaches with the credentials of the secret of the PV", func() {
+			credentials := resources.CredentialInfo{UserName: "admin", Password: "secret"}
+			controller.SetVolumeCredentialsGetter(&fakeCredentialsGetter{credentials: map[string]resources.CredentialInfo{"pv1": credentials}})
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: "node1"}, nil)
+			detachRequest := k8sresources.FlexVolumeDetachRequest{Name: "scbe_pv1_6005076306ffd6b60000000000002a1b", Host: "node1", Version: k8sresources.KubernetesVersion_1_6OrLater}
+			detachResponse := controller.Detach(detachRequest)
+			Expect(detachResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.GetVolumeConfigArgsForCall(0).CredentialInfo).To(Equal(credentials))
+			Expect(fakeClient.DetachArgsForCall(0).CredentialInfo).To(Equal(credentials))
+		})
+	})
+	Context(".WaitForAttach", func() {
+		BeforeEach(func() {
+			controller.SetWaitForAttachConfig(k8sresources.WaitForAttachConfig{TimeoutSeconds: 1, InitialBackoffMilliseconds: 10, MaxBackoffMilliseconds: 50})
+		})
+		It("returns the multipath device matching the Wwn", func() {
+			fakeExec.ExecuteStub = func(command string, args []string) ([]byte, error) {
+				if command == "multipath" {
+					return []byte("mpatha (36005076306ffd6b60000000000002a1b) dm-0 IBM,2107900\nsize=1.0G features='0' hwhandler='0' wp=rw\n`-+- policy='service-time 0' prio=1 status=active\n  `- 2:0:0:1 sdb 8:16 active ready running\n"), nil
+				}
+				return nil, nil
+			}
+			waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: "pv1", Opts: map[string]string{"volumeName": "pv1", "Wwn": "6005076306FFD6B60000000000002A1B"}}
+			waitForAttachResponse := controller.WaitForAttach(waitForAttachRequest)
+			Expect(waitForAttachResponse.Status).To(Equal("Success"))
+			Expect(waitForAttachResponse.Device).To(Equal("/dev/mapper/mpatha"))
+		})
+		It("fails with a clear message when the LUN never appears", func() {
+			fakeExec.ExecuteReturns([]byte(""), nil)
+			waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: "pv1", Opts: map[string]string{"volumeName": "pv1", "Wwn": "6005076306ffd6b60000000000002a1b"}}
+			waitForAttachResponse := controller.WaitForAttach(waitForAttachRequest)
+			Expect(waitForAttachResponse.Status).To(Equal("Failure"))
+			Expect(waitForAttachResponse.Message).To(ContainSubstring("6005076306ffd6b60000000000002a1b"))
+			Expect(waitForAttachResponse.Code).To(Equal(k8sresources.ErrorCodeTimeout))
+			Expect(fakeExec.ExecuteCallCount()).To(BeNumerically(">", 2))
+		})
+		It("rescans the iSCSI sessions less often than it polls multipath", func() {
+			fakeExec.ExecuteReturns([]byte(""), nil)
+			waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: "pv1", Opts: map[string]string{"volumeName": "pv1", "Wwn": "6005076306ffd6b60000000000002a1b"}}
+			controller.WaitForAttach(waitForAttachRequest)
+			calls := map[string]int{}
+			for i := 0; i < fakeExec.ExecuteCallCount(); i++ {
+				command, _ := fakeExec.ExecuteArgsForCall(i)
+				calls[command]++
+			}
+			Expect(calls["iscsiadm"]).To(BeNumerically(">=", 2))
+			Expect(calls["iscsiadm"]).To(BeNumerically("<", calls["multipath"]))
+		})
+		It("fails when the volume cannot be fetched", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("error getting volume"))
+			waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: "pv1", Opts: map[string]string{"volumeName": "pv1"}}
+			waitForAttachResponse := controller.WaitForAttach(waitForAttachRequest)
+			Expect(waitForAttachResponse.Status).To(Equal("Failure"))
+			Expect(waitForAttachResponse.Message).To(ContainSubstring("error getting volume"))
+		})
+		It("succeeds without a device for NFS backends", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SoftlayerNFS}, nil)
+			waitForAttachRequest := k8sresources.FlexVolumeWaitForAttachRequest{Name: "pv1", Opts: map[string]string{"volumeName": "pv1"}}
+			waitForAttachResponse := controller.WaitForAttach(waitForAttachRequest)
+			Expect(waitForAttachResponse.Status).To(Equal("Success"))
+			Expect(waitForAttachResponse.Device).To(Equal(""))
+		})
+	})
+	Context(".Mount", func() {
+		It("fails before mounting when the pod mount layout is invalid", func() {
+			mountRequest := k8sresources.FlexVolumeMountRequest{MountPath: "/tmp/test/pod1/pv1", MountDevice: "pv1", Opts: map[string]string{"volumeName": "pv1", "backend": resources.SCBE, k8sresources.OptionNamePodMountLayout: "hardlink"}, Version: k8sresources.KubernetesVersion_1_6OrLater}
+			mountResponse := controller.Mount(mountRequest)
+			Expect(mountResponse.Status).To(Equal("Failure"))
+			Expect(mountResponse.Message).To(ContainSubstring("hardlink"))
+			Expect(mountResponse.Code).To(Equal(k8sresources.ErrorCodeInvalidRequest))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(0))
+		})
+		It("reports the failure on the pod of the mount path", func() {
+			podEventReporter := &fakePodEventReporter{}
+			controller.SetPodEventReporter(podEventReporter)
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("dial tcp 10.0.0.1:9999: getsockopt: connection refused"))
+			controller.SetPodMountLayouts(map[string]string{resources.SCBE: k8sresources.PodMountLayoutBind})
+			mountRequest := k8sresources.FlexVolumeMountRequest{MountPath: "/var/lib/kubelet/pods/uid1/volumes/ibm~ubiquity-k8s-flex/pv1", MountDevice: "pv1", Opts: map[string]string{"volumeName": "pv1", k8sresources.OptionNamePodName: "pod1", k8sresources.OptionNamePodNamespace: "default"}, Version: k8sresources.KubernetesVersion_1_6OrLater}
+			mountResponse := controller.Mount(mountRequest)
+			Expect(mountResponse.Status).To(Equal("Failure"))
+			Expect(podEventReporter.pods).To(Equal([]k8sresources.PodRef{{UID: "uid1", Name: "pod1", Namespace: "default"}}))
+			Expect(podEventReporter.reasons).To(Equal([]string{k8sresources.EventReasonBackendUnreachable}))
+			Expect(mountResponse.Code).To(Equal(k8sresources.ErrorCodeTransient))
+			Expect(mountResponse.Retryable).To(BeTrue())
+		})
+		It("fails before mounting when the backend of the volume cannot be fetched", func() {
+			controller.SetPodMountLayouts(map[string]string{resources.SCBE: k8sresources.PodMountLayoutBind})
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("error getting volume"))
+			mountRequest := k8sresources.FlexVolumeMountRequest{MountPath: "/tmp/test/pod1/pv1", MountDevice: "pv1", Opts: map[string]string{"volumeName": "pv1"}, Version: k8sresources.KubernetesVersion_1_6OrLater}
+			mountResponse := controller.Mount(mountRequest)
+			Expect(mountResponse.Status).To(Equal("Failure"))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(0))
+		})
+	})
+	Context(".UnmountDevice", func() {
+		It("succeeds without tearing down the volume when the global path is not mounted", func() {
+			unmountDeviceRequest := k8sresources.FlexVolumeUnmountDeviceRequest{Name: "/tmp/test/globalmount/pv1"}
+			unmountDeviceResponse := controller.UnmountDevice(unmountDeviceRequest)
+			Expect(unmountDeviceResponse.Status).To(Equal("Success"))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+	})
+	Context(".Unmount of the bind layout", func() {
+		var (
+			mountTable  *fakeMountTable
+			fakeMounter *fakes.FakeMounter
+			podPath     string
+		)
+		BeforeEach(func() {
+			podPath = "/var/lib/kubelet/pods/pod1/volumes/ibm~ubiquity-k8s-flex/pv1"
+			mountTable = &fakeMountTable{
+				mountpoints: map[string]string{podPath: "/dev/fs1"},
+				refs:        map[string][]string{podPath: {"/gpfs/fs1"}},
+			}
+			controller.SetMountTable(mountTable)
+			fakeMounter = new(fakes.FakeMounter)
+			controller.SetMounterForBackend(resources.SpectrumScale, fakeMounter)
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SpectrumScale, Mountpoint: "/gpfs/fs1/pv1"}, nil)
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: "node1"}, nil)
+		})
+		It("detaches the Spectrum Scale volume when no other pod uses it", func() {
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(1))
+			Expect(fakeClient.ListVolumesCallCount()).To(Equal(0))
+			Expect(fakeClient.DetachCallCount()).To(Equal(2))
+			Expect(fakeClient.DetachArgsForCall(0).Name).To(Equal("pv1"))
+			Expect(fakeClient.DetachArgsForCall(1)).To(Equal(resources.DetachRequest{Name: "pv1", Host: "node1"}))
+			Expect(fakeMounter.ActionAfterDetachCallCount()).To(Equal(1))
+		})
+		It("passes the credentials of the secret of the PV to every ubiquity call", func() {
+			credentials := resources.CredentialInfo{UserName: "admin", Password: "secret"}
+			controller.SetVolumeCredentialsGetter(&fakeCredentialsGetter{credentials: map[string]resources.CredentialInfo{"pv1": credentials}})
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			for i := 0; i < fakeClient.GetVolumeCallCount(); i++ {
+				Expect(fakeClient.GetVolumeArgsForCall(i).CredentialInfo).To(Equal(credentials))
+			}
+			for i := 0; i < fakeClient.GetVolumeConfigCallCount(); i++ {
+				Expect(fakeClient.GetVolumeConfigArgsForCall(i).CredentialInfo).To(Equal(credentials))
+			}
+			Expect(fakeClient.DetachCallCount()).To(Equal(2))
+			Expect(fakeClient.DetachArgsForCall(0).CredentialInfo).To(Equal(credentials))
+			Expect(fakeClient.DetachArgsForCall(1).CredentialInfo).To(Equal(credentials))
+		})
+		It("detaches the Spectrum Scale volume when its fileset is already unlinked", func() {
+			fakeClient.DetachReturnsOnCall(0, fmt.Errorf("fileset not linked"))
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.DetachCallCount()).To(Equal(2))
+		})
+		It("skips the detach while another pod uses the Spectrum Scale volume", func() {
+			mountTable.refs[podPath] = []string{"/gpfs/fs1", "/var/lib/kubelet/pods/pod2/volumes/ibm~ubiquity-k8s-flex/pv1"}
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(1))
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+		It("skips the detach of an SCBE volume while another pod uses it", func() {
+			realMountPoint := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "fakeWWN")
+			mountTable.refs[podPath] = []string{realMountPoint, "/var/lib/kubelet/pods/pod2/volumes/ibm~ubiquity-k8s-flex/pv1"}
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SCBE}, nil)
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{"Wwn": "fakeWWN", resources.ScbeKeyVolAttachToHost: "node1"}, nil)
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+		It("leaves the teardown to unmountdevice when the global path uses the volume", func() {
+			mountTable.refs[podPath] = []string{"/var/lib/kubelet/" + k8sresources.FlexDeviceMountDir + "/pv1"}
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.GetVolumeCallCount()).To(Equal(0))
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+	})
 	/*
 	Context(".Mount", func() {
 		AfterEach(func() {
@@ -0,0 +1,73 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"encoding/base64"
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+// credentialsFromOptions returns the ubiquity credentials of the SecretRef of the volume, which kubelet passes in the options.
+// It returns none without a SecretRef, the ubiquity server then uses the credentials of the node config.
+func credentialsFromOptions(opts map[string]string) (resources.CredentialInfo, error) {
+	encodedUsername, hasUsername := opts[k8sresources.OptionPrefixSecret+k8sresources.SecretKeyUsername]
+	encodedPassword, hasPassword := opts[k8sresources.OptionPrefixSecret+k8sresources.SecretKeyPassword]
+	if !hasUsername && !hasPassword {
+		return resources.CredentialInfo{}, nil
+	}
+	if !hasUsername || !hasPassword {
+		return resources.CredentialInfo{}, NewControllerError(k8sresources.ErrorCodeInvalidRequest, "the secret of the volume must have both the %s and %s keys", k8sresources.SecretKeyUsername, k8sresources.SecretKeyPassword)
+	}
+	username, err := base64.StdEncoding.DecodeString(encodedUsername)
+	if err != nil {
+		return resources.CredentialInfo{}, NewControllerError(k8sresources.ErrorCodeInvalidRequest, "the %s of the secret of the volume is not base64 encoded: %v", k8sresources.SecretKeyUsername, err)
+	}
+	password, err := base64.StdEncoding.DecodeString(encodedPassword)
+	if err != nil {
+		return resources.CredentialInfo{}, NewControllerError(k8sresources.ErrorCodeInvalidRequest, "the %s of the secret of the volume is not base64 encoded", k8sresources.SecretKeyPassword)
+	}
+	return resources.CredentialInfo{UserName: string(username), Password: string(password)}, nil
+}
+
+// credentialsOfVolume returns the ubiquity credentials of the SecretRef of the PV for the flows kubelet passes no options to, i.e unmount and detach.
+// It returns none without a credentials getter, the ubiquity server then uses the credentials of the node config.
+func (c *Controller) credentialsOfVolume(pvName string) (resources.CredentialInfo, error) {
+	if c.credentialsGetter == nil {
+		return resources.CredentialInfo{}, nil
+	}
+	credentials, err := c.credentialsGetter.GetVolumeCredentials(pvName)
+	if err != nil {
+		return resources.CredentialInfo{}, c.logger.ErrorRet(err, "GetVolumeCredentials failed", logs.Args{{"volume", pvName}})
+	}
+	return credentials, nil
+}
+
+// maskSecretOptions returns a copy of opts safe to log
+func maskSecretOptions(opts map[string]string) map[string]string {
+	masked := make(map[string]string, len(opts))
+	for key, value := range opts {
+		if strings.HasPrefix(key, k8sresources.OptionPrefixSecret) {
+			value = "****"
+		}
+		masked[key] = value
+	}
+	return masked
+}
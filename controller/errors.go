@@ -0,0 +1,116 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+)
+
+//ControllerError is a failure of the controller with one of the k8sresources.ErrorCode* codes
+type ControllerError struct {
+	Code    string
+	Message string
+	// Err is the cause of the failure, nil when the controller detected it by itself
+	Err error
+}
+
+func (e *ControllerError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+//NewControllerError allows to instantiate a controller error without a cause
+func NewControllerError(code string, format string, args ...interface{}) *ControllerError {
+	return &ControllerError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError adds context to the message of err and keeps its code
+func wrapError(err error, format string, args ...interface{}) error {
+	return &ControllerError{Code: ErrorCode(err), Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// The codes of the errors the ubiquity server and the node return as plain messages. The controller sets the code of its own errors,
+// so only the messages of the server and of the connection to it are matched, at their start or after the ": " of a wrapping message.
+var errorCodeMatchers = []struct {
+	code    string
+	matches func(message string) bool
+}{
+	{k8sresources.ErrorCodeTransient, func(message string) bool {
+		return k8sutils.ClassifyStorageFailure(message, "") == k8sresources.EventReasonBackendUnreachable
+	}},
+	{k8sresources.ErrorCodeTimeout, func(message string) bool {
+		return k8sutils.ContainsAny(message, "timeout", "timed out", "deadline exceeded")
+	}},
+	{k8sresources.ErrorCodeAttachedElsewhere, attachedElsewherePattern.MatchString},
+	{k8sresources.ErrorCodeBackendNotFound, backendNotFoundPattern.MatchString},
+	{k8sresources.ErrorCodeVolumeNotFound, volumeNotFoundPattern.MatchString},
+	{k8sresources.ErrorCodeSnapshotNotFound, snapshotNotFoundPattern.MatchString},
+	{k8sresources.ErrorCodeAlreadyExists, alreadyExistsPattern.MatchString},
+}
+
+// The messages of the ubiquity server, e.g "volume [pv1] is already attached to host [node2]", "Backend [gpfs] not found", "Volume [pv1] not found",
+// "snapshot [snap1] not found" and "snapshot [snap1] already exists"
+var (
+	attachedElsewherePattern = regexp.MustCompile(`(^|: )volume (\[[^\]]*\] |[^ ]+ )?is already attached to|attached to (another|a different) host`)
+	backendNotFoundPattern   = regexp.MustCompile(`(^|: )backend (\[[^\]]*\] |[^ ]+ )?(was )?(not found|does not exist|is not supported|not supported)`)
+	volumeNotFoundPattern    = regexp.MustCompile(`(^|: )volume (\[[^\]]*\] |[^ ]+ )?(was )?(not found|does not exist)`)
+	snapshotNotFoundPattern  = regexp.MustCompile(`(^|: )snapshot (\[[^\]]*\] |[^ ]+ )?(of volume (\[[^\]]*\]|[^ ]+) )?(was )?(not found|does not exist)`)
+	alreadyExistsPattern     = regexp.MustCompile(`(^|: )(volume|snapshot) (\[[^\]]*\] |[^ ]+ )?(of volume (\[[^\]]*\]|[^ ]+) )?already exists`)
+)
+
+//ErrorCode returns the code of err, the one of the ControllerError or the one classified from its message, empty when none matches
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if controllerError, ok := err.(*ControllerError); ok {
+		if controllerError.Code != "" || controllerError.Err == nil {
+			return controllerError.Code
+		}
+		return ErrorCode(controllerError.Err)
+	}
+	message := strings.ToLower(err.Error())
+	for _, matcher := range errorCodeMatchers {
+		if matcher.matches(message) {
+			return matcher.code
+		}
+	}
+	return ""
+}
+
+//IsRetryable tells if the same request may succeed later without any fix, i.e its error is transient or a timeout
+func IsRetryable(err error) bool {
+	code := ErrorCode(err)
+	return code == k8sresources.ErrorCodeTransient || code == k8sresources.ErrorCodeTimeout
+}
+
+// failureResponse is the flex response of err, with its message, its code and whether it is retryable
+func failureResponse(err error) k8sresources.FlexVolumeResponse {
+	return k8sresources.FlexVolumeResponse{
+		Status:    "Failure",
+		Message:   err.Error(),
+		Code:      ErrorCode(err),
+		Retryable: IsRetryable(err),
+	}
+}
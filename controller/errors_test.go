@@ -0,0 +1,64 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ctl "github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+)
+
+var _ = Describe("ErrorCode", func() {
+	It("returns the code of the controller errors", func() {
+		err := ctl.NewControllerError(k8sresources.ErrorCodeInvalidRequest, "volumeName not found")
+		Expect(ctl.ErrorCode(err)).To(Equal(k8sresources.ErrorCodeInvalidRequest))
+		Expect(err.Error()).To(Equal("volumeName not found"))
+	})
+	It("returns the code of the cause of a controller error without code", func() {
+		err := &ctl.ControllerError{Message: "Failed to attach volume [pv1]", Err: fmt.Errorf("dial tcp 10.0.0.1:9999: getsockopt: connection refused")}
+		Expect(ctl.ErrorCode(err)).To(Equal(k8sresources.ErrorCodeTransient))
+		Expect(err.Error()).To(Equal("Failed to attach volume [pv1]: dial tcp 10.0.0.1:9999: getsockopt: connection refused"))
+	})
+	It("classifies the plain errors from their message", func() {
+		failures := map[string]string{
+			"Post https://ubiquity:9999/ubiquity_storage/volumes: dial tcp 10.0.0.1:9999: getsockopt: connection refused": k8sresources.ErrorCodeTransient,
+			"Timeout waiting 3m0s for the lock of [pv1]":                                                                  k8sresources.ErrorCodeTimeout,
+			"volume [pv1] is already attached to host [node2]":                                                            k8sresources.ErrorCodeAttachedElsewhere,
+			"Backend [gpfs] not found":                                                                                    k8sresources.ErrorCodeBackendNotFound,
+			"Volume [pv1] not found":                                                                                      k8sresources.ErrorCodeVolumeNotFound,
+			"snapshot [snap1] of volume [pv1] not found":                                                                  k8sresources.ErrorCodeSnapshotNotFound,
+			"Snapshot snap1 already exists":                                                                               k8sresources.ErrorCodeAlreadyExists,
+			"exit status 32":                                                                                              "",
+			"Failed to unmount: volume [pv1] does not exist":                                                              k8sresources.ErrorCodeVolumeNotFound,
+			"volumeName not found in unmountRequest":                                                                      "",
+			"mounter not found for the volume directory":                                                                  "",
+		}
+		for message, code := range failures {
+			Expect(ctl.ErrorCode(fmt.Errorf(message))).To(Equal(code), message)
+		}
+	})
+	It("retries only the transient errors and the timeouts", func() {
+		Expect(ctl.IsRetryable(ctl.NewControllerError(k8sresources.ErrorCodeTransient, "connection refused"))).To(BeTrue())
+		Expect(ctl.IsRetryable(ctl.NewControllerError(k8sresources.ErrorCodeTimeout, "timeout"))).To(BeTrue())
+		Expect(ctl.IsRetryable(ctl.NewControllerError(k8sresources.ErrorCodeVolumeNotFound, "volume not found"))).To(BeFalse())
+		Expect(ctl.IsRetryable(fmt.Errorf("exit status 32"))).To(BeFalse())
+	})
+})
@@ -0,0 +1,452 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+// kubelet keeps the volumes of a pod in <kubelet root dir>/pods/<uid>/volumes/<vendor>~<driver>/<volume name>
+const flexPodVolumesDir = k8sresources.UbiquityK8sFlexVolumeDriverVendor + "~" + k8sresources.UbiquityK8sFlexVolumeDriverName
+
+//OrphanCleanupResult lists what CleanupOrphans removed, or would remove in a dry run
+type OrphanCleanupResult struct {
+	DryRun bool
+	// the volume paths of the dead pods, symlinks to a ubiquity mountpoint or bind mounts of it
+	PodVolumePaths []string
+	// the ubiquity mountpoints no live pod uses, of volumes the backend does not attach to the node
+	Mountpoints []string
+	// the multipath devices of ubiquity volumes neither mounted nor attached to the node
+	MultipathDevices []string
+	// the unused items left in place, as "<item>: <reason>"
+	Skipped []string
+}
+
+//Summary describes the result in one line
+func (r OrphanCleanupResult) Summary() string {
+	verb := "Removed"
+	if r.DryRun {
+		verb = "Dry run, would remove"
+	}
+	summary := fmt.Sprintf("%s the pod volume paths %v, the mountpoints %v and the multipath devices %v", verb, r.PodVolumePaths, r.Mountpoints, r.MultipathDevices)
+	if len(r.Skipped) > 0 {
+		summary += fmt.Sprintf(", skipped %v", r.Skipped)
+	}
+	return summary
+}
+
+// podVolume is a volume path of a pod that leads to a ubiquity mountpoint
+type podVolume struct {
+	path       string
+	podUID     string
+	mountpoint string
+	bind       bool
+}
+
+// scbeVolume is a SCBE volume of the ubiquity server as the orphan cleanup needs it
+type scbeVolume struct {
+	name     string
+	wwn      string
+	attachTo string
+}
+
+//CleanupOrphans removes what the unmount flows left behind on the node, e.g when the node crashed mid-unmount:
+//the volume paths of the dead pods, the ubiquity mountpoints no live pod uses and the multipath devices of the volumes the backend no longer attaches to the node.
+//In a dry run it only reports them.
+func (c *Controller) CleanupOrphans(dryRun bool) k8sresources.FlexVolumeResponse {
+	defer c.logger.Trace(logs.DEBUG)()
+	var response k8sresources.FlexVolumeResponse
+
+	result, err := c.doCleanupOrphans(dryRun)
+	if err != nil {
+		response = failureResponse(wrapError(err, "Orphan cleanup failed after: %s", result.Summary()))
+	} else {
+		response = k8sresources.FlexVolumeResponse{
+			Status:  "Success",
+			Message: result.Summary(),
+		}
+	}
+
+	c.logger.Info("Orphan cleanup done", logs.Args{{"response", response}})
+	return response
+}
+
+func (c *Controller) doCleanupOrphans(dryRun bool) (OrphanCleanupResult, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+	result := OrphanCleanupResult{DryRun: dryRun}
+
+	// the pods are listed after their directories, a pod created in between is live
+	podVolumes, err := c.listPodVolumes()
+	if err != nil {
+		return result, err
+	}
+	var livePods map[string]bool
+	if c.podLister != nil {
+		livePods, err = c.podLister.ListPodUIDs()
+		if err != nil {
+			return result, c.logger.ErrorRet(err, "podLister.ListPodUIDs failed")
+		}
+	}
+
+	var problems []string
+	usedMountpoints := make(map[string]bool)
+	orphanPodPaths := make(map[string]bool)
+	for _, podVolume := range podVolumes {
+		if livePods == nil || livePods[podVolume.podUID] {
+			usedMountpoints[podVolume.mountpoint] = true
+			continue
+		}
+		orphanPodPaths[podVolume.path] = true
+		if err := c.cleanupPodVolume(podVolume, dryRun); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		result.PodVolumePaths = append(result.PodVolumePaths, podVolume.path)
+	}
+
+	mountpoints, err := c.mountTable.ListMountPoints()
+	if err != nil {
+		return result, c.logger.ErrorRet(err, "ListMountPoints failed")
+	}
+	var volumes map[string]scbeVolume
+	getVolumes := func() (map[string]scbeVolume, error) {
+		if volumes != nil {
+			return volumes, nil
+		}
+		var err error
+		volumes, err = c.listScbeVolumes()
+		return volumes, err
+	}
+	nodeName := getHost(c.orphanCleanupConfig.NodeName)
+	if nodeName == "" {
+		err = fmt.Errorf("Cannot tell the node name the backend attaches the volumes to")
+		return result, c.logger.ErrorRet(err, "failed")
+	}
+
+	ubiquityMountPrefix := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "")
+	mountedWwns := make(map[string]bool)
+	cleanedWwns := make(map[string]bool)
+	for mountpoint := range mountpoints {
+		if !strings.HasPrefix(mountpoint, ubiquityMountPrefix) {
+			continue
+		}
+		wwn := strings.ToLower(path.Base(mountpoint))
+		mountedWwns[wwn] = true
+		if usedMountpoints[mountpoint] {
+			continue
+		}
+		used, err := c.isMountpointUsed(mountpoint, orphanPodPaths)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if used {
+			continue
+		}
+		scbeVolumes, err := getVolumes()
+		if err != nil {
+			return result, err
+		}
+		volume, known := scbeVolumes[wwn]
+		if known && IsAttachedTo(volume.attachTo, nodeName) {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s: the backend still attaches volume [%s] to node [%s]", mountpoint, volume.name, nodeName))
+			continue
+		}
+		if err := c.cleanupMountpoint(mountpoint, volume, known, dryRun); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		result.Mountpoints = append(result.Mountpoints, mountpoint)
+		cleanedWwns[wwn] = true
+	}
+
+	if containsString(c.config.Backends, resources.SCBE) {
+		devices, err := c.cleanupMultipathDevices(mountedWwns, cleanedWwns, getVolumes, nodeName, dryRun)
+		result.MultipathDevices = devices
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return result, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return result, nil
+}
+
+// listPodVolumes returns the volume paths of the pods of the node that are symlinks to a ubiquity mountpoint or bind mounts of it
+func (c *Controller) listPodVolumes() ([]podVolume, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	kubeletRootDir := c.orphanCleanupConfig.KubeletRootDir
+	if kubeletRootDir == "" {
+		kubeletRootDir = k8sresources.KubeletDefaultRootDir
+	}
+	paths, err := filepath.Glob(filepath.Join(kubeletRootDir, "pods", "*", "volumes", flexPodVolumesDir, "*"))
+	if err != nil {
+		return nil, c.logger.ErrorRet(err, "filepath.Glob failed")
+	}
+
+	ubiquityMountPrefix := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "")
+	var podVolumes []podVolume
+	for _, volumePath := range paths {
+		podUID := podUIDFromMountPath(volumePath)
+		info, err := os.Lstat(volumePath)
+		if err != nil {
+			// unmounted meanwhile
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(volumePath)
+			if err != nil {
+				return nil, c.logger.ErrorRet(err, "os.Readlink failed")
+			}
+			if strings.HasPrefix(target, ubiquityMountPrefix) {
+				podVolumes = append(podVolumes, podVolume{path: volumePath, podUID: podUID, mountpoint: filepath.Clean(target)})
+			}
+			continue
+		}
+		mounted, err := c.mountTable.IsMountPoint(volumePath)
+		if err != nil {
+			return nil, c.logger.ErrorRet(err, "IsMountPoint failed")
+		}
+		if !mounted {
+			continue
+		}
+		refs, err := c.mountTable.GetMountRefs(volumePath)
+		if err != nil {
+			return nil, c.logger.ErrorRet(err, "GetMountRefs failed")
+		}
+		for _, ref := range refs {
+			if strings.HasPrefix(ref, ubiquityMountPrefix) {
+				podVolumes = append(podVolumes, podVolume{path: volumePath, podUID: podUID, mountpoint: ref, bind: true})
+				break
+			}
+		}
+	}
+	return podVolumes, nil
+}
+
+// cleanupPodVolume removes the symlink or the bind mount of a dead pod, kubelet removes the directory of the pod once its volume paths are gone
+func (c *Controller) cleanupPodVolume(podVolume podVolume, dryRun bool) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	c.logger.Info("Orphan pod volume path", logs.Args{{"path", podVolume.path}, {"mountpoint", podVolume.mountpoint}, {"dryRun", dryRun}})
+	if dryRun {
+		return nil
+	}
+	unlock, err := c.lockVolume(volumeLockKey(podVolume.path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if podVolume.bind {
+		mounted, err := c.mountTable.IsMountPoint(podVolume.path)
+		if err != nil {
+			return c.logger.ErrorRet(err, "IsMountPoint failed")
+		}
+		if mounted {
+			if err := k8sutils.Unmount(c.exec, podVolume.path); err != nil {
+				return c.logger.ErrorRet(err, "Unmount failed")
+			}
+		}
+	}
+	// the directory left by the bind mount is empty, Remove does not remove a directory with content
+	err = c.exec.Remove(podVolume.path)
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("Failed to remove the pod volume path [%s]: %v", podVolume.path, err)
+		return c.logger.ErrorRet(err, "exec.Remove failed")
+	}
+	return nil
+}
+
+// isMountpointUsed tells whether a ubiquity mountpoint is bind mounted elsewhere than on the orphan pod volume paths, e.g on a live pod or the mountdevice global path
+func (c *Controller) isMountpointUsed(mountpoint string, orphanPodPaths map[string]bool) (bool, error) {
+	refs, err := c.mountTable.GetMountRefs(mountpoint)
+	if err != nil {
+		return false, c.logger.ErrorRet(err, "GetMountRefs failed")
+	}
+	ubiquityMountPrefix := fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "")
+	for _, ref := range refs {
+		if !strings.HasPrefix(ref, ubiquityMountPrefix) && !orphanPodPaths[ref] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cleanupMountpoint unmounts an unused ubiquity mountpoint, the mounter of a known volume also removes its multipath device
+func (c *Controller) cleanupMountpoint(mountpoint string, volume scbeVolume, known bool, dryRun bool) error {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	c.logger.Info("Orphan mountpoint", logs.Args{{"mountpoint", mountpoint}, {"volume", volume.name}, {"dryRun", dryRun}})
+	if dryRun {
+		return nil
+	}
+	if known {
+		unlock, err := c.lockVolume(volume.name)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		credentials, err := c.credentialsOfVolume(volume.name)
+		if err != nil {
+			return err
+		}
+		return c.doUnmountVolume(volume.name, credentials)
+	}
+
+	// the volume is gone from the ubiquity server, the multipath cleanup removes its device
+	err := k8sutils.Unmount(c.exec, mountpoint)
+	if err != nil {
+		return c.logger.ErrorRet(err, "Unmount failed")
+	}
+	err = c.exec.Remove(mountpoint)
+	if err != nil && !os.IsNotExist(err) {
+		return c.logger.ErrorRet(err, "exec.Remove failed")
+	}
+	return nil
+}
+
+// cleanupMultipathDevices flushes the multipath devices of the ubiquity volumes that are neither mounted nor attached to the node, it returns the flushed devices
+func (c *Controller) cleanupMultipathDevices(mountedWwns map[string]bool, cleanedWwns map[string]bool, getVolumes func() (map[string]scbeVolume, error), nodeName string, dryRun bool) ([]string, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	output, err := c.exec.Execute("multipath", []string{"-ll"})
+	if err != nil {
+		err = fmt.Errorf("multipath -ll failed: %s, Error: %v", string(output), err)
+		return nil, c.logger.ErrorRet(err, "failed")
+	}
+	multipathMaps := parseMultipathMaps(output)
+	if len(multipathMaps) == 0 {
+		return nil, nil
+	}
+	volumes, err := getVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	var problems []string
+	for _, multipathMap := range multipathMaps {
+		wwn, ok := findMultipathWwn(multipathMap, volumes, cleanedWwns)
+		if !ok {
+			// not a ubiquity volume
+			continue
+		}
+		volume, known := volumes[wwn]
+		if cleanedWwns[wwn] {
+			if known {
+				// the mounter removed the device with the mountpoint
+				continue
+			}
+		} else if mountedWwns[wwn] {
+			continue
+		} else if known && IsAttachedTo(volume.attachTo, nodeName) {
+			// attached and not mounted yet
+			continue
+		}
+		device := path.Join("/dev/mapper", multipathMap.name)
+		c.logger.Info("Orphan multipath device", logs.Args{{"device", device}, {"wwn", wwn}, {"dryRun", dryRun}})
+		if !dryRun {
+			if err := c.flushMultipathDevice(multipathMap.name); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+		}
+		devices = append(devices, device)
+	}
+	if len(problems) > 0 {
+		return devices, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return devices, nil
+}
+
+func (c *Controller) flushMultipathDevice(name string) error {
+	unlock, err := c.lockRescan()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	output, err := c.exec.Execute("multipath", []string{"-f", name})
+	if err != nil {
+		err = fmt.Errorf("multipath -f %s failed: %s, Error: %v", name, string(output), err)
+		return c.logger.ErrorRet(err, "failed")
+	}
+	return nil
+}
+
+// findMultipathWwn returns the WWN of the ubiquity volume of the map, the volume may be known to the ubiquity server or only by its mountpoint
+func findMultipathWwn(multipathMap multipathMap, volumes map[string]scbeVolume, cleanedWwns map[string]bool) (string, bool) {
+	for wwn := range volumes {
+		if multipathMap.matches(wwn) {
+			return wwn, true
+		}
+	}
+	for wwn := range cleanedWwns {
+		if multipathMap.matches(wwn) {
+			return wwn, true
+		}
+	}
+	return "", false
+}
+
+// listScbeVolumes returns the SCBE volumes of the ubiquity server by lower case WWN
+func (c *Controller) listScbeVolumes() (map[string]scbeVolume, error) {
+	defer c.logger.Trace(logs.DEBUG)()
+
+	volumes, err := c.Client.ListVolumes(resources.ListVolumesRequest{})
+	if err != nil {
+		return nil, c.logger.ErrorRet(err, "Client.ListVolumes failed")
+	}
+	scbeVolumes := make(map[string]scbeVolume)
+	for _, volume := range volumes {
+		if volume.Backend != resources.SCBE {
+			continue
+		}
+		volumeConfig, err := c.Client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: volume.Name})
+		if err != nil {
+			return nil, c.logger.ErrorRet(err, "Client.GetVolumeConfig failed", logs.Args{{"volume", volume.Name}})
+		}
+		wwn, _ := volumeConfig["Wwn"].(string)
+		if wwn == "" {
+			continue
+		}
+		attachTo, _ := volumeConfig[resources.ScbeKeyVolAttachToHost].(string)
+		scbeVolumes[strings.ToLower(wwn)] = scbeVolume{name: volume.Name, wwn: wwn, attachTo: attachTo}
+	}
+	return scbeVolumes, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ctl "github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+type fakePodLister struct {
+	uids map[string]bool
+	err  error
+}
+
+func (l *fakePodLister) ListPodUIDs() (map[string]bool, error) {
+	return l.uids, l.err
+}
+
+var _ = Describe("Orphan cleanup", func() {
+
+	var (
+		fakeClient     *fakes.FakeStorageClient
+		fakeExec       *fakes.FakeExecutor
+		controller     *ctl.Controller
+		kubeletRootDir string
+		multipathOut   string
+	)
+	const multipathHeader = "mpath%s (3%s) dm-%d IBM,2145\nsize=1.0G features='1 queue_if_no_path' hwhandler='0' wp=rw\n"
+
+	addPodVolume := func(podUID string, pvName string, wwn string) string {
+		dir := filepath.Join(kubeletRootDir, "pods", podUID, "volumes", "ibm~ubiquity-k8s-flex")
+		Expect(os.MkdirAll(dir, 0750)).To(Succeed())
+		podVolumePath := filepath.Join(dir, pvName)
+		Expect(os.Symlink(fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, wwn), podVolumePath)).To(Succeed())
+		return podVolumePath
+	}
+
+	BeforeEach(func() {
+		var err error
+		kubeletRootDir, err = ioutil.TempDir("", "kubelet")
+		Expect(err).NotTo(HaveOccurred())
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeExec = new(fakes.FakeExecutor)
+		multipathOut = ""
+		fakeExec.ExecuteStub = func(command string, args []string) ([]byte, error) {
+			if command == "multipath" && args[0] == "-ll" {
+				return []byte(multipathOut), nil
+			}
+			return nil, nil
+		}
+		controller = ctl.NewControllerWithConfig(testLogger, fakeClient, fakeExec, resources.UbiquityPluginConfig{Backends: []string{resources.SCBE}})
+		controller.SetOrphanCleanupConfig(k8sresources.OrphanCleanupConfig{KubeletRootDir: kubeletRootDir, NodeName: "node1"})
+	})
+	AfterEach(func() {
+		os.RemoveAll(kubeletRootDir)
+	})
+
+	Context("the pod volume paths", func() {
+		var livePath, deadPath string
+		BeforeEach(func() {
+			livePath = addPodVolume("uid-live", "pv1", "wwn1")
+			deadPath = addPodVolume("uid-dead", "pv2", "wwn2")
+		})
+
+		It("removes the symlinks of the dead pods only", func() {
+			controller.SetPodLister(&fakePodLister{uids: map[string]bool{"uid-live": true}})
+			response := controller.CleanupOrphans(false)
+			Expect(response.Status).To(Equal("Success"))
+			Expect(response.Message).To(ContainSubstring(deadPath))
+			Expect(response.Message).NotTo(ContainSubstring(livePath))
+			Expect(fakeExec.RemoveCallCount()).To(Equal(1))
+			Expect(fakeExec.RemoveArgsForCall(0)).To(Equal(deadPath))
+		})
+		It("only reports them in a dry run", func() {
+			controller.SetPodLister(&fakePodLister{uids: map[string]bool{"uid-live": true}})
+			response := controller.CleanupOrphans(true)
+			Expect(response.Status).To(Equal("Success"))
+			Expect(response.Message).To(HavePrefix("Dry run"))
+			Expect(response.Message).To(ContainSubstring(deadPath))
+			Expect(fakeExec.RemoveCallCount()).To(Equal(0))
+		})
+		It("keeps them all without a pod lister", func() {
+			response := controller.CleanupOrphans(false)
+			Expect(response.Status).To(Equal("Success"))
+			Expect(fakeExec.RemoveCallCount()).To(Equal(0))
+		})
+		It("removes nothing when the pods cannot be listed", func() {
+			controller.SetPodLister(&fakePodLister{err: fmt.Errorf("apiserver unreachable")})
+			response := controller.CleanupOrphans(false)
+			Expect(response.Status).To(Equal("Failure"))
+			Expect(response.Message).To(ContainSubstring("apiserver unreachable"))
+			Expect(fakeExec.RemoveCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("the multipath devices", func() {
+		BeforeEach(func() {
+			multipathOut = fmt.Sprintf(multipathHeader, "a", "6005076aaa", 0) + fmt.Sprintf(multipathHeader, "b", "6005076bbb", 1) + fmt.Sprintf(multipathHeader, "c", "6005076ccc", 2)
+			fakeClient.ListVolumesReturns([]resources.Volume{
+				{Name: "pv-elsewhere", Backend: resources.SCBE},
+				{Name: "pv-here", Backend: resources.SCBE},
+			}, nil)
+			fakeClient.GetVolumeConfigStub = func(request resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+				if request.Name == "pv-elsewhere" {
+					return map[string]interface{}{"Wwn": "6005076AAA", resources.ScbeKeyVolAttachToHost: "node2"}, nil
+				}
+				return map[string]interface{}{"Wwn": "6005076BBB", resources.ScbeKeyVolAttachToHost: "node1"}, nil
+			}
+		})
+
+		It("flushes the devices of the volumes attached to another node only", func() {
+			response := controller.CleanupOrphans(false)
+			Expect(response.Status).To(Equal("Success"))
+			Expect(response.Message).To(ContainSubstring("/dev/mapper/mpatha"))
+			var flushed []string
+			for i := 0; i < fakeExec.ExecuteCallCount(); i++ {
+				command, args := fakeExec.ExecuteArgsForCall(i)
+				if command == "multipath" && args[0] == "-f" {
+					flushed = append(flushed, args[1])
+				}
+			}
+			Expect(flushed).To(Equal([]string{"mpatha"}))
+		})
+		It("only reports them in a dry run", func() {
+			response := controller.CleanupOrphans(true)
+			Expect(response.Status).To(Equal("Success"))
+			Expect(response.Message).To(ContainSubstring("/dev/mapper/mpatha"))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(1))
+		})
+		It("fails when the volumes cannot be listed", func() {
+			fakeClient.ListVolumesReturns(nil, fmt.Errorf("ubiquity unreachable"))
+			response := controller.CleanupOrphans(false)
+			Expect(response.Status).To(Equal("Failure"))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controller_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	ctl "github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+// the state of the pod path a replayed call-out finds
+const (
+	podPathAbsent      = "absent"
+	podPathDirectory   = "directory"
+	podPathSymlink     = "symlink"
+	podPathOtherTarget = "symlink to another mountpoint"
+)
+
+// the node a replayed unmount runs on, the one it may detach the volume from
+var localNode, _ = os.Hostname()
+
+var _ = Describe("Replayed call-outs", func() {
+
+	var (
+		fakeClient  *fakes.FakeStorageClient
+		fakeExec    *fakes.FakeExecutor
+		fakeMounter *fakes.FakeMounter
+		controller  *ctl.Controller
+		podDir      string
+		podPath     string
+		mountpoint  string
+	)
+
+	preparePodPath := func(state string) {
+		switch state {
+		case podPathDirectory:
+			Expect(os.Mkdir(podPath, 0750)).To(Succeed())
+		case podPathSymlink:
+			Expect(os.Symlink(mountpoint, podPath)).To(Succeed())
+		case podPathOtherTarget:
+			Expect(os.Symlink(fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "OTHERWWN"), podPath)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeExec = new(fakes.FakeExecutor)
+		fakeMounter = new(fakes.FakeMounter)
+		controller = ctl.NewControllerWithClient(testLogger, fakeClient, fakeExec)
+		controller.SetMounterForBackend(resources.SCBE, fakeMounter)
+		fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SCBE}, nil)
+
+		var err error
+		podDir, err = ioutil.TempDir("", "pod")
+		Expect(err).NotTo(HaveOccurred())
+		podPath = filepath.Join(podDir, "pv1")
+		mountpoint = fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "WWN1")
+	})
+	AfterEach(func() {
+		os.RemoveAll(podDir)
+	})
+
+	DescribeTable(".Mount",
+		func(state string, expectedStatus string, expectedTarget string) {
+			preparePodPath(state)
+			fakeMounter.MountReturns(mountpoint, nil)
+			mountRequest := k8sresources.FlexVolumeMountRequest{MountPath: podPath, MountDevice: "pv1", Opts: map[string]string{"volumeName": "pv1", "backend": resources.SCBE, "Wwn": "WWN1"}, Version: k8sresources.KubernetesVersion_1_6OrLater}
+
+			mountResponse := controller.Mount(mountRequest)
+			Expect(mountResponse.Status).To(Equal(expectedStatus), mountResponse.Message)
+			target, err := os.Readlink(podPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).To(Equal(expectedTarget))
+		},
+		Entry("links the directory kubelet created", podPathDirectory, "Success", "/ubiquity/WWN1"),
+		Entry("links again after the directory was removed", podPathAbsent, "Success", "/ubiquity/WWN1"),
+		Entry("succeeds when the symlink is there already", podPathSymlink, "Success", "/ubiquity/WWN1"),
+		Entry("fails without touching a symlink to another mountpoint", podPathOtherTarget, "Failure", "/ubiquity/OTHERWWN"),
+	)
+
+	DescribeTable(".Unmount",
+		func(state string, attachTo string, dangling bool, expectedDetaches int) {
+			preparePodPath(state)
+			if dangling {
+				fakeExec.EvalSymlinksReturns("", &os.PathError{Op: "lstat", Path: mountpoint, Err: os.ErrNotExist})
+			}
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{"Wwn": "WWN1", resources.ScbeKeyVolAttachToHost: attachTo}, nil)
+
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"), unmountResponse.Message)
+			Expect(fakeMounter.UnmountCallCount()).To(Equal(0))
+			Expect(fakeClient.DetachCallCount()).To(Equal(expectedDetaches))
+			if expectedDetaches > 0 {
+				Expect(fakeClient.DetachArgsForCall(0).Host).To(Equal(attachTo))
+			}
+			if dangling {
+				Expect(fakeExec.RemoveCallCount()).To(Equal(1))
+				Expect(fakeExec.RemoveArgsForCall(0)).To(Equal(podPath))
+			}
+		},
+		Entry("succeeds when the pod path is gone and the volume detached", podPathAbsent, "", false, 0),
+		Entry("completes the detach when the pod path is gone and the volume still attached", podPathAbsent, localNode, false, 1),
+		Entry("succeeds without detaching when the pod path is gone and the volume attached to another node", podPathAbsent, "node2", false, 0),
+		Entry("succeeds when the pod path was never linked", podPathDirectory, "", false, 0),
+		Entry("removes the symlink to the unmounted volume", podPathSymlink, "", true, 0),
+		Entry("removes the symlink to the unmounted volume and completes the detach", podPathSymlink, localNode, true, 1),
+	)
+
+	Context(".Unmount of a gone pod path", func() {
+		It("succeeds when the volume was deleted", func() {
+			fakeClient.GetVolumeConfigReturns(nil, fmt.Errorf("volume pv1 not found"))
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Success"))
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+		It("fails when the backend cannot tell whether the volume is detached", func() {
+			fakeClient.GetVolumeConfigReturns(nil, fmt.Errorf("dial tcp 10.0.0.1:9999: getsockopt: connection refused"))
+			unmountResponse := controller.Unmount(k8sresources.FlexVolumeUnmountRequest{MountPath: podPath})
+			Expect(unmountResponse.Status).To(Equal("Failure"))
+			Expect(unmountResponse.Code).To(Equal(k8sresources.ErrorCodeTransient))
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+	})
+
+	DescribeTable(".Attach",
+		func(attachTo string, expectedStatus string) {
+			fakeClient.AttachReturns("", fmt.Errorf("volume pv1 is already attached to host %s", attachTo))
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: attachTo}, nil)
+
+			attachResponse := controller.Attach(k8sresources.FlexVolumeAttachRequest{Name: "pv1", Host: "node1", Opts: map[string]string{"volumeName": "pv1"}})
+			Expect(attachResponse.Status).To(Equal(expectedStatus))
+		},
+		Entry("succeeds when the volume is attached to the host already", "node1", "Success"),
+		Entry("fails when the volume is attached to another host", "node2", "Failure"),
+	)
+})
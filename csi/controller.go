@@ -0,0 +1,287 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csi
+
+import (
+	"fmt"
+
+	"github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	bytesPerMiB = 1024 * 1024
+	bytesPerGiB = 1024 * bytesPerMiB
+)
+
+var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+	csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+}
+
+//CreateVolume creates the volume on the backend given in the parameters, the same way the dynamic provisioner does
+func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume name is missing in the request")
+	}
+	if len(req.VolumeCapabilities) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities are missing in the request")
+	}
+
+	// SCBE sizes its volumes in GiB, the requested capacity is rounded up to the next GiB so that the volume is not smaller than requested
+	var capacityBytes int64
+	if req.CapacityRange != nil {
+		capacityBytes = (req.CapacityRange.RequiredBytes + bytesPerGiB - 1) / bytesPerGiB * bytesPerGiB
+		if limit := req.CapacityRange.LimitBytes; limit != 0 && limit < capacityBytes {
+			return nil, status.Errorf(codes.OutOfRange, "Volume capacity limit %d is below the required capacity %d rounded up to %d", limit, req.CapacityRange.RequiredBytes, capacityBytes)
+		}
+	}
+
+	// CreateVolume must be idempotent, the sidecar retries it with the same name after a timeout
+	if _, err := d.Client.GetVolume(resources.GetVolumeRequest{Name: req.Name}); err == nil {
+		d.logger.Debug("Volume already exists", logs.Args{{"name", req.Name}})
+	} else if controller.ErrorCode(err) != k8sresources.ErrorCodeVolumeNotFound {
+		d.logger.ErrorRet(err, "Client.GetVolume failed")
+		return nil, errorStatus(err, "error retrieving volume info")
+	} else {
+		ubiquityParams := make(map[string]interface{})
+		if capacityBytes != 0 {
+			ubiquityParams["quota"] = fmt.Sprintf("%dM", capacityBytes/bytesPerMiB) // SSc backend expect quota option
+			ubiquityParams["size"] = fmt.Sprintf("%d", capacityBytes/bytesPerGiB)   // SCBE backend expect size option
+		}
+		for key, value := range req.Parameters {
+			if k8sutils.IsBackendParameter(key) {
+				ubiquityParams[key] = value
+			}
+		}
+		if err := k8sutils.ValidateStorageClassParameters(req.Parameters); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+		}
+		backend := req.Parameters["backend"]
+
+		createVolumeRequest := resources.CreateVolumeRequest{Name: req.Name, Backend: backend, Opts: ubiquityParams}
+		if err := d.Client.CreateVolume(createVolumeRequest); err != nil {
+			d.logger.ErrorRet(err, "Client.CreateVolume failed")
+			return nil, errorStatus(err, "error creating volume")
+		}
+	}
+
+	attributes, err := d.getVolumeAttributes(req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting volume config details: %v", err)
+	}
+
+	response := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			Id:            req.Name,
+			CapacityBytes: capacityBytes,
+			Attributes:    attributes,
+		},
+	}
+	d.logger.Debug("", logs.Args{{"response", response}})
+	return response, nil
+}
+
+//DeleteVolume removes the volume from the backend
+func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+
+	// DeleteVolume must be idempotent, a volume deleted already is deleted
+	volume, err := d.Client.GetVolume(resources.GetVolumeRequest{Name: req.VolumeId})
+	if err != nil {
+		if controller.ErrorCode(err) == k8sresources.ErrorCodeVolumeNotFound {
+			d.logger.Debug("Volume already deleted", logs.Args{{"name", req.VolumeId}})
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		d.logger.ErrorRet(err, "Client.GetVolume failed")
+		return nil, errorStatus(err, "error retrieving volume info")
+	}
+
+	removeVolumeRequest := resources.RemoveVolumeRequest{Name: volume.Name}
+	if err := d.Client.RemoveVolume(removeVolumeRequest); err != nil {
+		d.logger.ErrorRet(err, "Client.RemoveVolume failed")
+		return nil, errorStatus(err, "error removing volume")
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+//ControllerPublishVolume attaches the volume to the node
+func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Node id is missing in the request")
+	}
+
+	attachRequest := resources.AttachRequest{Name: req.VolumeId, Host: req.NodeId}
+	if _, err := d.Client.Attach(attachRequest); err != nil {
+		// ControllerPublishVolume must be idempotent, a replay of an attach that went through already succeeds
+		volumeConfig, configErr := d.Client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: req.VolumeId})
+		if attachTo, ok := volumeConfig[resources.ScbeKeyVolAttachToHost].(string); configErr == nil && ok && controller.IsAttachedTo(attachTo, req.NodeId) {
+			d.logger.Debug("Volume already attached to the node", logs.Args{{"name", req.VolumeId}, {"node", req.NodeId}})
+			return &csi.ControllerPublishVolumeResponse{}, nil
+		}
+		d.logger.ErrorRet(err, "Client.Attach failed")
+		return nil, errorStatus(err, "Failed to attach volume [%s] to host [%s]", req.VolumeId, req.NodeId)
+	}
+
+	return &csi.ControllerPublishVolumeResponse{}, nil
+}
+
+//ControllerUnpublishVolume detaches the volume from the node
+func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+
+	// ControllerUnpublishVolume must be idempotent, a volume deleted already or not attached to the node is detached
+	volumeConfig, err := d.Client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: req.VolumeId})
+	if err != nil {
+		if controller.ErrorCode(err) == k8sresources.ErrorCodeVolumeNotFound {
+			d.logger.Debug("Volume already deleted", logs.Args{{"name", req.VolumeId}})
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+		d.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
+		return nil, errorStatus(err, "error retrieving volume config details")
+	}
+	// only the block volumes tell their host, the detach of the others is done by the node
+	if attachTo, ok := volumeConfig[resources.ScbeKeyVolAttachToHost].(string); ok && (attachTo == "" || req.NodeId != "" && !controller.IsAttachedTo(attachTo, req.NodeId)) {
+		d.logger.Debug("Volume already detached from the node", logs.Args{{"name", req.VolumeId}, {"node", req.NodeId}, {"attachTo", attachTo}})
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	detachRequest := resources.DetachRequest{Name: req.VolumeId, Host: req.NodeId}
+	if err := d.Client.Detach(detachRequest); err != nil {
+		d.logger.ErrorRet(err, "Client.Detach failed")
+		return nil, errorStatus(err, "Failed to detach volume [%s] from host [%s]", req.VolumeId, req.NodeId)
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+//ValidateVolumeCapabilities checks that the backend of the volume supports the requested access modes
+func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+
+	volume, err := d.Client.GetVolume(resources.GetVolumeRequest{Name: req.VolumeId})
+	if err != nil {
+		d.logger.ErrorRet(err, "Client.GetVolume failed")
+		return nil, status.Errorf(codes.NotFound, "error retrieving volume info: %v", err)
+	}
+
+	for _, capability := range req.VolumeCapabilities {
+		if capability.AccessMode == nil {
+			continue
+		}
+		mode := capability.AccessMode.Mode
+		multiNode := mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY ||
+			mode == csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER ||
+			mode == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+		if multiNode && volume.Backend == resources.SCBE {
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Supported: false,
+				Message:   fmt.Sprintf("Access mode %s is not supported by backend %s", mode, volume.Backend),
+			}, nil
+		}
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{Supported: true}, nil
+}
+
+//ListVolumes lists the volumes known to ubiquity
+func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	volumes, err := d.Client.ListVolumes(resources.ListVolumesRequest{})
+	if err != nil {
+		d.logger.ErrorRet(err, "Client.ListVolumes failed")
+		return nil, status.Errorf(codes.Internal, "Error getting the volume list from ubiquity server: %v", err)
+	}
+
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(volumes))
+	for _, volume := range volumes {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{Volume: &csi.Volume{Id: volume.Name}})
+	}
+
+	return &csi.ListVolumesResponse{Entries: entries}, nil
+}
+
+//GetCapacity is not supported, ubiquity does not expose the free capacity of its backends
+func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "GetCapacity is not supported")
+}
+
+//ControllerGetCapabilities returns the controller capabilities of the plugin
+func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	capabilities := make([]*csi.ControllerServiceCapability, 0, len(controllerCapabilities))
+	for _, capability := range controllerCapabilities {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{Type: capability},
+			},
+		})
+	}
+
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+//getVolumeAttributes returns the volume config as the attributes passed to the node, they are the same options the provisioner puts on the flex PV
+func (d *Driver) getVolumeAttributes(name string) (map[string]string, error) {
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: name}
+	volumeConfig, err := d.Client.GetVolumeConfig(getVolumeConfigRequest)
+	if err != nil {
+		return nil, d.logger.ErrorRet(err, "Client.GetVolumeConfig failed")
+	}
+
+	attributes := make(map[string]string)
+	attributes["volumeName"] = name
+	for key, value := range volumeConfig {
+		attributes[key] = fmt.Sprintf("%v", value)
+	}
+	return attributes, nil
+}
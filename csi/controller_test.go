@@ -0,0 +1,222 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csi_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ubiquitycsi "github.com/IBM/ubiquity-k8s/csi"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var _ = Describe("Controller service", func() {
+
+	var (
+		fakeClient     *fakes.FakeStorageClient
+		fakeExec       *fakes.FakeExecutor
+		driver         *ubiquitycsi.Driver
+		ubiquityConfig resources.UbiquityPluginConfig
+		capabilities   []*csi.VolumeCapability
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeExec = new(fakes.FakeExecutor)
+		ubiquityConfig = resources.UbiquityPluginConfig{Backends: []string{resources.SCBE}}
+		driver = ubiquitycsi.NewDriverWithClient(testLogger, fakeClient, fakeExec, ubiquityConfig, "node1")
+		capabilities = []*csi.VolumeCapability{
+			{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}},
+		}
+	})
+
+	Context(".CreateVolume", func() {
+		It("fails when backend is not specified", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("volume not found"))
+			req := &csi.CreateVolumeRequest{Name: "pv1", VolumeCapabilities: capabilities}
+			_, err := driver.CreateVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("creates the volume with size and quota and returns its config as attributes", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("volume not found"))
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{"Wwn": "fakewwn"}, nil)
+			req := &csi.CreateVolumeRequest{
+				Name:               "pv1",
+				VolumeCapabilities: capabilities,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 2 * 1024 * 1024 * 1024},
+				Parameters:         map[string]string{"backend": resources.SCBE, "profile": "gold"},
+			}
+			res, err := driver.CreateVolume(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(1))
+			createVolumeRequest := fakeClient.CreateVolumeArgsForCall(0)
+			Expect(createVolumeRequest.Backend).To(Equal(resources.SCBE))
+			Expect(createVolumeRequest.Opts["size"]).To(Equal("2"))
+			Expect(createVolumeRequest.Opts["quota"]).To(Equal("2048M"))
+			Expect(createVolumeRequest.Opts["profile"]).To(Equal("gold"))
+			Expect(res.Volume.Id).To(Equal("pv1"))
+			Expect(res.Volume.Attributes["volumeName"]).To(Equal("pv1"))
+			Expect(res.Volume.Attributes["Wwn"]).To(Equal("fakewwn"))
+		})
+		It("rounds the size up to the next GiB", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("volume not found"))
+			req := &csi.CreateVolumeRequest{
+				Name:               "pv1",
+				VolumeCapabilities: capabilities,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 1536 * 1024 * 1024},
+				Parameters:         map[string]string{"backend": resources.SCBE},
+			}
+			res, err := driver.CreateVolume(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			createVolumeRequest := fakeClient.CreateVolumeArgsForCall(0)
+			Expect(createVolumeRequest.Opts["size"]).To(Equal("2"))
+			Expect(createVolumeRequest.Opts["quota"]).To(Equal("2048M"))
+			Expect(res.Volume.CapacityBytes).To(Equal(int64(2 * 1024 * 1024 * 1024)))
+		})
+		It("fails without creating the volume when the limit is below the rounded size", func() {
+			req := &csi.CreateVolumeRequest{
+				Name:               "pv1",
+				VolumeCapabilities: capabilities,
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 512 * 1024 * 1024, LimitBytes: 768 * 1024 * 1024},
+				Parameters:         map[string]string{"backend": resources.SCBE},
+			}
+			_, err := driver.CreateVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.OutOfRange))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("does not create the volume again if it already exists", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SCBE}, nil)
+			req := &csi.CreateVolumeRequest{Name: "pv1", VolumeCapabilities: capabilities, Parameters: map[string]string{"backend": resources.SCBE}}
+			_, err := driver.CreateVolume(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails without creating the volume when a parameter is unknown", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("volume not found"))
+			req := &csi.CreateVolumeRequest{Name: "pv1", VolumeCapabilities: capabilities, Parameters: map[string]string{"backend": resources.SCBE, "profil": "gold"}}
+			_, err := driver.CreateVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails when the client fails to create the volume", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("volume not found"))
+			fakeClient.CreateVolumeReturns(fmt.Errorf("error creating volume"))
+			req := &csi.CreateVolumeRequest{Name: "pv1", VolumeCapabilities: capabilities, Parameters: map[string]string{"backend": resources.SCBE}}
+			_, err := driver.CreateVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.Internal))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(0))
+		})
+		It("fails without creating the volume when ubiquity is unreachable", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("dial tcp 10.0.0.1:9999: getsockopt: connection refused"))
+			req := &csi.CreateVolumeRequest{Name: "pv1", VolumeCapabilities: capabilities, Parameters: map[string]string{"backend": resources.SCBE}}
+			_, err := driver.CreateVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.Unavailable))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+	})
+
+	Context(".DeleteVolume", func() {
+		It("removes the volume", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1"}, nil)
+			_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "pv1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(1))
+			Expect(fakeClient.RemoveVolumeArgsForCall(0).Name).To(Equal("pv1"))
+		})
+		It("fails when the client fails to remove the volume", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1"}, nil)
+			fakeClient.RemoveVolumeReturns(fmt.Errorf("error removing volume"))
+			_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "pv1"})
+			Expect(grpc.Code(err)).To(Equal(codes.Internal))
+		})
+		It("succeeds without removing the volume when it was already deleted", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("Volume [pv1] not found"))
+			_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "pv1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+		})
+	})
+
+	Context(".ControllerPublishVolume", func() {
+		It("attaches the volume to the node", func() {
+			_, err := driver.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(err).ToNot(HaveOccurred())
+			attachRequest := fakeClient.AttachArgsForCall(0)
+			Expect(attachRequest.Name).To(Equal("pv1"))
+			Expect(attachRequest.Host).To(Equal("node1"))
+		})
+		It("fails when node id is missing", func() {
+			_, err := driver.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "pv1"})
+			Expect(grpc.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(fakeClient.AttachCallCount()).To(Equal(0))
+		})
+		It("fails with a retryable code when ubiquity is unreachable", func() {
+			fakeClient.AttachReturns("", fmt.Errorf("dial tcp 10.0.0.1:9999: getsockopt: connection refused"))
+			_, err := driver.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(grpc.Code(err)).To(Equal(codes.Unavailable))
+		})
+		It("succeeds on a replay of an attach that went through already", func() {
+			fakeClient.AttachReturns("", fmt.Errorf("volume pv1 is already attached to host node1"))
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: "node1"}, nil)
+			_, err := driver.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.GetVolumeConfigArgsForCall(0).Name).To(Equal("pv1"))
+		})
+		It("fails when the volume is attached to another node", func() {
+			fakeClient.AttachReturns("", fmt.Errorf("volume pv1 is already attached to host node2"))
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: "node2"}, nil)
+			_, err := driver.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context(".ControllerUnpublishVolume", func() {
+		It("detaches the volume from the node", func() {
+			_, err := driver.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(err).ToNot(HaveOccurred())
+			detachRequest := fakeClient.DetachArgsForCall(0)
+			Expect(detachRequest.Name).To(Equal("pv1"))
+			Expect(detachRequest.Host).To(Equal("node1"))
+		})
+		It("fails when the client fails to detach", func() {
+			fakeClient.DetachReturns(fmt.Errorf("error detaching volume"))
+			_, err := driver.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(grpc.Code(err)).To(Equal(codes.Internal))
+		})
+		It("succeeds without detaching when the volume was already deleted", func() {
+			fakeClient.GetVolumeConfigReturns(nil, fmt.Errorf("Volume [pv1] not found"))
+			_, err := driver.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+		It("succeeds without detaching when the volume is not attached to the node", func() {
+			for _, attachTo := range []string{"", "node2"} {
+				fakeClient.GetVolumeConfigReturns(map[string]interface{}{resources.ScbeKeyVolAttachToHost: attachTo}, nil)
+				_, err := driver.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{VolumeId: "pv1", NodeId: "node1"})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(fakeClient.DetachCallCount()).To(Equal(0))
+		})
+	})
+})
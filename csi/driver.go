@@ -0,0 +1,153 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csi
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/IBM/ubiquity-k8s/controller"
+	k8sremote "github.com/IBM/ubiquity-k8s/remote"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils"
+	"github.com/IBM/ubiquity/utils/logs"
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Driver serves the CSI Identity, Controller and Node services on top of the ubiquity storage client
+type Driver struct {
+	Client         resources.StorageClient
+	exec           utils.Executor
+	logger         logs.Logger
+	legacyLogger   *log.Logger
+	config         resources.UbiquityPluginConfig
+	nodeID         string
+	flexController *controller.Controller
+	mountTable     controller.MountTable
+	server         *grpc.Server
+}
+
+//NewDriver allows to instantiate a CSI driver, its ubiquity client retries the transient failures as set by retryConfig
+func NewDriver(logger *log.Logger, config resources.UbiquityPluginConfig, retryConfig k8sresources.ClientRetryConfig, nodeID string) (*Driver, error) {
+	remoteClient, err := k8sremote.NewRemoteClient(logger, config)
+	if err != nil {
+		return nil, err
+	}
+	client := k8sutils.NewRetryingStorageClient(remoteClient, retryConfig, controller.ErrorCode)
+	return NewDriverWithClient(logger, client, utils.NewExecutor(), config, nodeID), nil
+}
+
+//NewDriverWithClient is made for unit testing purposes where we can pass a fake client
+func NewDriverWithClient(logger *log.Logger, client resources.StorageClient, exec utils.Executor, config resources.UbiquityPluginConfig, nodeID string) *Driver {
+	return &Driver{
+		Client:         client,
+		exec:           exec,
+		logger:         logs.GetLogger(),
+		legacyLogger:   logger,
+		config:         config,
+		nodeID:         nodeID,
+		flexController: controller.NewControllerWithConfig(logger, client, exec, config),
+		mountTable:     k8sutils.ProcMountTable{},
+	}
+}
+
+//SetMountTable is made for unit testing purposes where we can pass a fake mount table
+func (d *Driver) SetMountTable(mountTable controller.MountTable) {
+	d.mountTable = mountTable
+	d.flexController.SetMountTable(mountTable)
+}
+
+//Run listens on the given endpoint (unix://<path> or tcp://<address>) and serves the CSI services until the server stops
+func (d *Driver) Run(endpoint string) error {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	proto, addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		return d.logger.ErrorRet(err, "parseEndpoint failed")
+	}
+	if proto == "unix" {
+		// a stale socket is left behind when the previous instance was killed
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("Failed to remove the stale socket %s. Error: %v", addr, err)
+			return d.logger.ErrorRet(err, "failed")
+		}
+	}
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		return d.logger.ErrorRet(err, "net.Listen failed", logs.Args{{"endpoint", endpoint}})
+	}
+
+	d.server = grpc.NewServer()
+	csi.RegisterIdentityServer(d.server, d)
+	csi.RegisterControllerServer(d.server, d)
+	csi.RegisterNodeServer(d.server, d)
+
+	d.logger.Info("Serving CSI", logs.Args{{"endpoint", endpoint}, {"driver", k8sresources.UbiquityK8sCsiDriverFullName}})
+	return d.server.Serve(listener)
+}
+
+//Stop stops the gRPC server
+func (d *Driver) Stop() {
+	if d.server != nil {
+		d.server.GracefulStop()
+	}
+}
+
+func parseEndpoint(endpoint string) (string, string, error) {
+	for _, proto := range []string{"unix", "tcp"} {
+		prefix := proto + "://"
+		if strings.HasPrefix(strings.ToLower(endpoint), prefix) {
+			addr := endpoint[len(prefix):]
+			if addr == "" {
+				break
+			}
+			return proto, addr, nil
+		}
+	}
+	return "", "", fmt.Errorf("Invalid endpoint [%s], expected unix://<path> or tcp://<address>", endpoint)
+}
+
+// errorStatus returns the gRPC status of a storage failure, with the code matching its controller error code.
+// The sidecars retry Unavailable and DeadlineExceeded with a backoff, and report the other codes as final.
+func errorStatus(err error, format string, args ...interface{}) error {
+	message := fmt.Sprintf("%s: %v", fmt.Sprintf(format, args...), err)
+	switch controller.ErrorCode(err) {
+	case k8sresources.ErrorCodeTransient:
+		return status.Error(codes.Unavailable, message)
+	case k8sresources.ErrorCodeTimeout:
+		return status.Error(codes.DeadlineExceeded, message)
+	case k8sresources.ErrorCodeInvalidRequest, k8sresources.ErrorCodeBackendNotFound:
+		return status.Error(codes.InvalidArgument, message)
+	case k8sresources.ErrorCodeVolumeNotFound, k8sresources.ErrorCodeSnapshotNotFound:
+		return status.Error(codes.NotFound, message)
+	case k8sresources.ErrorCodeAlreadyExists:
+		return status.Error(codes.AlreadyExists, message)
+	case k8sresources.ErrorCodeAttachedElsewhere:
+		return status.Error(codes.FailedPrecondition, message)
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
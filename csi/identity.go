@@ -0,0 +1,69 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csi
+
+import (
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//GetPluginInfo returns the name and version of the plugin
+func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	return &csi.GetPluginInfoResponse{
+		Name:          k8sresources.UbiquityK8sCsiDriverFullName,
+		VendorVersion: k8sresources.UbiquityK8sCsiDriverVersion,
+	}, nil
+}
+
+//GetPluginCapabilities reports that the plugin provides the controller service
+func (d *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	return &csi.GetPluginCapabilitiesResponse{
+		Capabilities: []*csi.PluginCapability{
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+//Probe checks the connectivity to ubiquity, the same way the flex testubiquity command does
+func (d *Driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	activateRequest := resources.ActivateRequest{Backends: d.config.Backends}
+	d.logger.Debug("", logs.Args{{"request", activateRequest}})
+	err := d.Client.Activate(activateRequest)
+	if err != nil {
+		d.logger.ErrorRet(err, "Client.Activate failed")
+		return nil, status.Errorf(codes.FailedPrecondition, "Test ubiquity failed %v", err)
+	}
+
+	return &csi.ProbeResponse{}, nil
+}
@@ -0,0 +1,207 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csi
+
+import (
+	"os"
+
+	"github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/utils/logs"
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//NodeStageVolume mounts the volume once on the node at its ubiquity mountpoint (PathToMountUbiquityBlockDevices for SCBE) and bind mounts it on the staging path
+func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+	if req.StagingTargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path is missing in the request")
+	}
+
+	mounted, err := d.mountTable.IsMountPoint(req.StagingTargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check the staging target path [%s]: %v", req.StagingTargetPath, err)
+	}
+	if mounted {
+		d.logger.Debug("Volume already staged", logs.Args{{"volume", req.VolumeId}, {"stagingTargetPath", req.StagingTargetPath}})
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	realMountPoint, err := d.flexController.MountVolume(req.VolumeId, req.VolumeAttributes)
+	if err != nil {
+		return nil, errorStatus(err, "Failed to mount volume [%s]", req.VolumeId)
+	}
+
+	if err := d.exec.MkdirAll(req.StagingTargetPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create the staging target path [%s]: %v", req.StagingTargetPath, err)
+	}
+	if err := k8sutils.BindMount(d.exec, realMountPoint, req.StagingTargetPath, false); err != nil {
+		d.logger.ErrorRet(err, "BindMount failed")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+//NodeUnstageVolume unmounts the staging path and then the volume from its ubiquity mountpoint
+func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+	if req.StagingTargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path is missing in the request")
+	}
+
+	// NodeUnstageVolume must be idempotent, a replay of an unstage that went through already finds nothing mounted
+	stagingMounted, err := d.mountTable.IsMountPoint(req.StagingTargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check the staging target path [%s]: %v", req.StagingTargetPath, err)
+	}
+	volumeMounted := false
+	mountpoint, err := d.flexController.VolumeMountpoint(req.VolumeId)
+	if err != nil && controller.ErrorCode(err) != k8sresources.ErrorCodeVolumeNotFound {
+		return nil, errorStatus(err, "Failed to get the mountpoint of volume [%s]", req.VolumeId)
+	}
+	if err == nil {
+		volumeMounted, err = d.mountTable.IsMountPoint(mountpoint)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to check the mountpoint [%s] of volume [%s]: %v", mountpoint, req.VolumeId, err)
+		}
+	}
+	if !stagingMounted && !volumeMounted {
+		d.logger.Debug("Volume already unstaged", logs.Args{{"volume", req.VolumeId}, {"stagingTargetPath", req.StagingTargetPath}})
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
+	if err := d.unmountIfMounted(req.StagingTargetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := d.flexController.UnmountVolume(req.VolumeId); err != nil {
+		return nil, errorStatus(err, "Failed to unmount volume [%s]", req.VolumeId)
+	}
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+//NodePublishVolume bind mounts the staged volume into the pod target path
+func (d *Driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+	if req.StagingTargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path is missing in the request")
+	}
+	if req.TargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path is missing in the request")
+	}
+
+	mounted, err := d.mountTable.IsMountPoint(req.TargetPath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to check the target path [%s]: %v", req.TargetPath, err)
+	}
+	if mounted {
+		d.logger.Debug("Volume already published", logs.Args{{"volume", req.VolumeId}, {"targetPath", req.TargetPath}})
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if err := d.exec.MkdirAll(req.TargetPath, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create the target path [%s]: %v", req.TargetPath, err)
+	}
+	if err := k8sutils.BindMount(d.exec, req.StagingTargetPath, req.TargetPath, req.Readonly); err != nil {
+		d.logger.ErrorRet(err, "BindMount failed")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+//NodeUnpublishVolume unmounts the volume from the pod target path
+func (d *Driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+	d.logger.Debug("", logs.Args{{"request", req}})
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume id is missing in the request")
+	}
+	if req.TargetPath == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path is missing in the request")
+	}
+
+	if err := d.unmountIfMounted(req.TargetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := d.exec.Remove(req.TargetPath); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "Failed to remove the target path [%s]: %v", req.TargetPath, err)
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+//NodeGetId returns the node id used as the host in ubiquity attach requests
+func (d *Driver) NodeGetId(ctx context.Context, req *csi.NodeGetIdRequest) (*csi.NodeGetIdResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	return &csi.NodeGetIdResponse{NodeId: d.nodeID}, nil
+}
+
+//NodeGetCapabilities reports that the node service stages volumes
+func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	defer d.logger.Trace(logs.DEBUG)()
+
+	return &csi.NodeGetCapabilitiesResponse{
+		Capabilities: []*csi.NodeServiceCapability{
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func (d *Driver) unmountIfMounted(path string) error {
+	mounted, err := d.mountTable.IsMountPoint(path)
+	if err != nil {
+		return d.logger.ErrorRet(err, "IsMountPoint failed", logs.Args{{"path", path}})
+	}
+	if !mounted {
+		d.logger.Debug("Path is not mounted (skipping)", logs.Args{{"path", path}})
+		return nil
+	}
+	if err := k8sutils.Unmount(d.exec, path); err != nil {
+		return d.logger.ErrorRet(err, "Unmount failed")
+	}
+	return nil
+}
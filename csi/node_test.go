@@ -0,0 +1,153 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package csi_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ubiquitycsi "github.com/IBM/ubiquity-k8s/csi"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+	csi "github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+type fakeMountTable struct {
+	mountpoints map[string]string
+}
+
+func (t *fakeMountTable) IsMountPoint(path string) (bool, error) {
+	_, ok := t.mountpoints[path]
+	return ok, nil
+}
+
+func (t *fakeMountTable) GetMountRefs(path string) ([]string, error) {
+	return nil, nil
+}
+
+func (t *fakeMountTable) ListMountPoints() (map[string]string, error) {
+	return t.mountpoints, nil
+}
+
+var _ = Describe("Node service", func() {
+
+	var (
+		fakeClient *fakes.FakeStorageClient
+		fakeExec   *fakes.FakeExecutor
+		driver     *ubiquitycsi.Driver
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeExec = new(fakes.FakeExecutor)
+		driver = ubiquitycsi.NewDriverWithClient(testLogger, fakeClient, fakeExec, resources.UbiquityPluginConfig{}, "node1")
+	})
+
+	Context(".NodeStageVolume", func() {
+		It("fails when staging target path is missing", func() {
+			_, err := driver.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{VolumeId: "pv1"})
+			Expect(grpc.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(0))
+		})
+		It("fails when the volume config cannot be fetched", func() {
+			fakeClient.GetVolumeConfigReturns(nil, fmt.Errorf("error getting volume config"))
+			req := &csi.NodeStageVolumeRequest{VolumeId: "pv1", StagingTargetPath: "/tmp/test/csi/staging/pv1"}
+			_, err := driver.NodeStageVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.Internal))
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+		})
+	})
+
+	Context(".NodeUnstageVolume", func() {
+		var (
+			mountTable  *fakeMountTable
+			stagingPath string
+			mountpoint  string
+		)
+		BeforeEach(func() {
+			stagingPath = "/tmp/test/csi/staging/pv1"
+			mountpoint = fmt.Sprintf(resources.PathToMountUbiquityBlockDevices, "fakeWWN")
+			mountTable = &fakeMountTable{mountpoints: map[string]string{}}
+			driver.SetMountTable(mountTable)
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1", Backend: resources.SCBE}, nil)
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{"Wwn": "fakeWWN"}, nil)
+		})
+		It("succeeds on a replay of an unstage that went through already", func() {
+			_, err := driver.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "pv1", StagingTargetPath: stagingPath})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+			Expect(fakeClient.GetVolumeCallCount()).To(Equal(1))
+		})
+		It("succeeds on a replay when the volume was deleted already", func() {
+			fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("Volume [pv1] not found"))
+			_, err := driver.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "pv1", StagingTargetPath: stagingPath})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+		})
+		It("unmounts the staging path and then the volume when they are mounted", func() {
+			mountTable.mountpoints[stagingPath] = "/dev/mapper/mpatha"
+			mountTable.mountpoints[mountpoint] = "/dev/mapper/mpatha"
+			fakeClient.GetVolumeReturnsOnCall(1, resources.Volume{}, fmt.Errorf("error getting volume"))
+			_, err := driver.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "pv1", StagingTargetPath: stagingPath})
+			Expect(grpc.Code(err)).To(Equal(codes.Internal))
+			command, args := fakeExec.ExecuteArgsForCall(0)
+			Expect(command).To(Equal("umount"))
+			Expect(args).To(Equal([]string{stagingPath}))
+			Expect(fakeClient.GetVolumeCallCount()).To(Equal(2))
+		})
+	})
+
+	Context(".NodePublishVolume", func() {
+		It("bind mounts the staging path on the target path", func() {
+			req := &csi.NodePublishVolumeRequest{VolumeId: "pv1", StagingTargetPath: "/tmp/test/csi/staging/pv1", TargetPath: "/tmp/test/csi/pod1/pv1"}
+			_, err := driver.NodePublishVolume(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeExec.MkdirAllCallCount()).To(Equal(1))
+			command, args := fakeExec.ExecuteArgsForCall(0)
+			Expect(command).To(Equal("mount"))
+			Expect(args).To(Equal([]string{"--bind", "/tmp/test/csi/staging/pv1", "/tmp/test/csi/pod1/pv1"}))
+		})
+		It("remounts the target path as read only when requested", func() {
+			req := &csi.NodePublishVolumeRequest{VolumeId: "pv1", StagingTargetPath: "/tmp/test/csi/staging/pv1", TargetPath: "/tmp/test/csi/pod1/pv1", Readonly: true}
+			_, err := driver.NodePublishVolume(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(2))
+			_, args := fakeExec.ExecuteArgsForCall(1)
+			Expect(args).To(Equal([]string{"-o", "remount,bind,ro", "/tmp/test/csi/pod1/pv1"}))
+		})
+		It("fails when the bind mount fails", func() {
+			fakeExec.ExecuteReturns([]byte("mount failed"), fmt.Errorf("exit status 32"))
+			req := &csi.NodePublishVolumeRequest{VolumeId: "pv1", StagingTargetPath: "/tmp/test/csi/staging/pv1", TargetPath: "/tmp/test/csi/pod1/pv1"}
+			_, err := driver.NodePublishVolume(context.Background(), req)
+			Expect(grpc.Code(err)).To(Equal(codes.Internal))
+		})
+	})
+
+	Context(".NodeUnpublishVolume", func() {
+		It("removes the target path when it is not mounted", func() {
+			req := &csi.NodeUnpublishVolumeRequest{VolumeId: "pv1", TargetPath: "/tmp/test/csi/pod1/pv1"}
+			_, err := driver.NodeUnpublishVolume(context.Background(), req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeExec.ExecuteCallCount()).To(Equal(0))
+			Expect(fakeExec.RemoveArgsForCall(0)).To(Equal("/tmp/test/csi/pod1/pv1"))
+		})
+	})
+})
@@ -49,6 +49,18 @@ function generate_flex_conf_from_envs_and_install_it()
     [ -z "$UBIQUITY_PLUGIN_SSL_MODE" ] && UBIQUITY_PLUGIN_SSL_MODE="verify-full" || :
     [ -z "$UBIQUITY_PORT" ] && UBIQUITY_PORT=9999 || :
     [ -z "$UBIQUITY_BACKEND" ] && UBIQUITY_BACKEND=scbe || :
+    [ -z "$WAIT_FOR_ATTACH_TIMEOUT_SECONDS" ] && WAIT_FOR_ATTACH_TIMEOUT_SECONDS=60 || :
+    [ -z "$WAIT_FOR_ATTACH_INITIAL_BACKOFF_MS" ] && WAIT_FOR_ATTACH_INITIAL_BACKOFF_MS=500 || :
+    [ -z "$WAIT_FOR_ATTACH_MAX_BACKOFF_MS" ] && WAIT_FOR_ATTACH_MAX_BACKOFF_MS=5000 || :
+    [ -z "$POD_MOUNT_LAYOUT" ] && POD_MOUNT_LAYOUT=symlink || :
+    [ -z "$METRICS_TEXTFILE_DIR" ] && METRICS_TEXTFILE_DIR=/var/lib/node_exporter/textfile_collector || :
+    # EVENTS_KUBECONFIG empty (the default) disables the Events on the pods whose volume fails to mount or unmount
+    # ORPHAN_CLEANUP_INTERVAL_SECONDS 0 (the default) disables the periodic orphan cleanup of the flex agent
+    [ -z "$ORPHAN_CLEANUP_INTERVAL_SECONDS" ] && ORPHAN_CLEANUP_INTERVAL_SECONDS=0 || :
+    [ -z "$ORPHAN_CLEANUP_DRY_RUN" ] && ORPHAN_CLEANUP_DRY_RUN=true || :
+    # CLIENT_CALL_TIMEOUT_MS 0 (the default) waits for the ubiquity calls as long as the ubiquity client does
+    [ -z "$CLIENT_MAX_RETRIES" ] && CLIENT_MAX_RETRIES=3 || :
+    [ -z "$CLIENT_CALL_TIMEOUT_MS" ] && CLIENT_CALL_TIMEOUT_MS=0 || :
 
     cat > $FLEX_TMP << EOF
 # This file was generated automatically by the $DRIVER Pod.
@@ -68,6 +80,29 @@ password = "$UBIQUITY_PASSWORD"
 [ScbeRemoteConfig]
 SkipRescanISCSI = $SKIP_RESCAN_ISCSI
 
+[WaitForAttach]
+TimeoutSeconds = $WAIT_FOR_ATTACH_TIMEOUT_SECONDS
+InitialBackoffMilliseconds = $WAIT_FOR_ATTACH_INITIAL_BACKOFF_MS
+MaxBackoffMilliseconds = $WAIT_FOR_ATTACH_MAX_BACKOFF_MS
+
+[PodMountLayout]
+$UBIQUITY_BACKEND = "$POD_MOUNT_LAYOUT"
+
+[Metrics]
+TextfileDir = "$METRICS_TEXTFILE_DIR"
+
+[Events]
+Kubeconfig = "$EVENTS_KUBECONFIG"
+NodeName = "$EVENTS_NODE_NAME"
+
+[OrphanCleanup]
+IntervalSeconds = $ORPHAN_CLEANUP_INTERVAL_SECONDS
+DryRun = $ORPHAN_CLEANUP_DRY_RUN
+
+[ClientRetry]
+MaxRetries = $CLIENT_MAX_RETRIES
+CallTimeoutMilliseconds = $CLIENT_CALL_TIMEOUT_MS
+
 [SslConfig]
 UseSsl = $UBIQUITY_PLUGIN_USE_SSL
 SslMode = "$UBIQUITY_PLUGIN_SSL_MODE"
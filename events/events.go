@@ -0,0 +1,209 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package events
+
+import (
+	"fmt"
+	"os"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+//PodEventReporter posts the volume failures of the flex call-outs as Warning Events of their pods.
+//A call-out exits right after it reports, so the Events are created synchronously rather than through a broadcaster.
+type PodEventReporter struct {
+	client   kubernetes.Interface
+	nodeName string
+}
+
+//NewPodEventReporter returns a reporter using the kubeconfig credentials, nodeName defaults to the hostname
+func NewPodEventReporter(kubeconfig string, nodeName string) (*PodEventReporter, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if nodeName == "" {
+		nodeName, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewPodEventReporterWithClient(client, nodeName), nil
+}
+
+//NewPodEventReporterWithClient is made for unit testing purposes where we can pass a fake client
+func NewPodEventReporterWithClient(client kubernetes.Interface, nodeName string) *PodEventReporter {
+	return &PodEventReporter{client: client, nodeName: nodeName}
+}
+
+//ReportPodVolumeFailure posts a Warning Event of reason on the pod
+func (r *PodEventReporter) ReportPodVolumeFailure(pod k8sresources.PodRef, volumeName string, reason string, message string) error {
+	involvedPod, err := r.getPod(pod)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", involvedPod.Name, now.UnixNano()),
+			Namespace: involvedPod.Namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:            "Pod",
+			APIVersion:      "v1",
+			Name:            involvedPod.Name,
+			Namespace:       involvedPod.Namespace,
+			UID:             involvedPod.UID,
+			ResourceVersion: involvedPod.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        fmt.Sprintf("Volume %s: %s", volumeName, message),
+		Source:         v1.EventSource{Component: k8sresources.UbiquityK8sFlexVolumeDriverName, Host: r.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeWarning,
+	}
+	_, err = r.client.CoreV1().Events(involvedPod.Namespace).Create(event)
+	return err
+}
+
+func (r *PodEventReporter) getPod(pod k8sresources.PodRef) (*v1.Pod, error) {
+	if pod.Name != "" && pod.Namespace != "" {
+		return r.client.CoreV1().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{})
+	}
+
+	// unmount passes only the pod UID, the pod is one of this node
+	nodeSelector := fields.OneTermEqualSelector("spec.nodeName", r.nodeName).String()
+	pods, err := r.client.CoreV1().Pods(v1.NamespaceAll).List(metav1.ListOptions{FieldSelector: nodeSelector})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		if string(pods.Items[i].UID) == pod.UID {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("pod with UID %s not found on node %s", pod.UID, r.nodeName)
+}
+
+//NodePodLister lists the pods of a node, the orphan cleanup of the flex driver keeps their volumes
+type NodePodLister struct {
+	client   kubernetes.Interface
+	nodeName string
+}
+
+//NewNodePodLister returns a lister using the kubeconfig credentials, nodeName defaults to the hostname
+func NewNodePodLister(kubeconfig string, nodeName string) (*NodePodLister, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if nodeName == "" {
+		nodeName, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewNodePodListerWithClient(client, nodeName), nil
+}
+
+//NewNodePodListerWithClient is made for unit testing purposes where we can pass a fake client
+func NewNodePodListerWithClient(client kubernetes.Interface, nodeName string) *NodePodLister {
+	return &NodePodLister{client: client, nodeName: nodeName}
+}
+
+//ListPodUIDs returns the UIDs of the pods of the node
+func (l *NodePodLister) ListPodUIDs() (map[string]bool, error) {
+	nodeSelector := fields.OneTermEqualSelector("spec.nodeName", l.nodeName).String()
+	pods, err := l.client.CoreV1().Pods(v1.NamespaceAll).List(metav1.ListOptions{FieldSelector: nodeSelector})
+	if err != nil {
+		return nil, err
+	}
+	uids := make(map[string]bool, len(pods.Items))
+	for _, pod := range pods.Items {
+		// the server filters on the node already, not every client does
+		if pod.Spec.NodeName == l.nodeName {
+			uids[string(pod.UID)] = true
+		}
+	}
+	return uids, nil
+}
+
+//VolumeCredentialsGetter reads the ubiquity credentials of the SecretRef of the flex PVs, for the unmount and detach call-outs kubelet passes no options to.
+//The secret is in the namespace of the claim of the PV, as kubelet reads it for the mount.
+type VolumeCredentialsGetter struct {
+	client kubernetes.Interface
+}
+
+//NewVolumeCredentialsGetter returns a getter using the kubeconfig credentials, they need to get the PVs and the secrets
+func NewVolumeCredentialsGetter(kubeconfig string) (*VolumeCredentialsGetter, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewVolumeCredentialsGetterWithClient(client), nil
+}
+
+//NewVolumeCredentialsGetterWithClient is made for unit testing purposes where we can pass a fake client
+func NewVolumeCredentialsGetterWithClient(client kubernetes.Interface) *VolumeCredentialsGetter {
+	return &VolumeCredentialsGetter{client: client}
+}
+
+//GetVolumeCredentials returns the credentials of the SecretRef of the PV, or none when the PV has no SecretRef or is gone already
+func (g *VolumeCredentialsGetter) GetVolumeCredentials(pvName string) (resources.CredentialInfo, error) {
+	pv, err := g.client.CoreV1().PersistentVolumes().Get(pvName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return resources.CredentialInfo{}, nil
+		}
+		return resources.CredentialInfo{}, err
+	}
+	if pv.Spec.FlexVolume == nil || pv.Spec.FlexVolume.SecretRef == nil || pv.Spec.ClaimRef == nil {
+		return resources.CredentialInfo{}, nil
+	}
+
+	secret, err := g.client.CoreV1().Secrets(pv.Spec.ClaimRef.Namespace).Get(pv.Spec.FlexVolume.SecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return resources.CredentialInfo{}, err
+	}
+	username, hasUsername := secret.Data[k8sresources.SecretKeyUsername]
+	password, hasPassword := secret.Data[k8sresources.SecretKeyPassword]
+	if !hasUsername || !hasPassword {
+		return resources.CredentialInfo{}, fmt.Errorf("the secret %s/%s of PV %s must have both the %s and %s keys", secret.Namespace, secret.Name, pvName, k8sresources.SecretKeyUsername, k8sresources.SecretKeyPassword)
+	}
+	return resources.CredentialInfo{UserName: string(username), Password: string(password)}, nil
+}
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package events_test
+
+import (
+	"github.com/IBM/ubiquity-k8s/events"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("PodEventReporter", func() {
+	var (
+		clientset *k8sfake.Clientset
+		reporter  *events.PodEventReporter
+	)
+	BeforeEach(func() {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default", UID: "uid1"},
+			Spec:       v1.PodSpec{NodeName: "node1"},
+		}
+		clientset = k8sfake.NewSimpleClientset(pod)
+		reporter = events.NewPodEventReporterWithClient(clientset, "node1")
+	})
+
+	It("posts a Warning Event on the pod named by the mount options", func() {
+		err := reporter.ReportPodVolumeFailure(k8sresources.PodRef{UID: "uid1", Name: "pod1", Namespace: "default"}, "pv1", k8sresources.EventReasonLunNotFound, "LUN not found")
+		Expect(err).ToNot(HaveOccurred())
+		eventList, err := clientset.CoreV1().Events("default").List(metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eventList.Items).To(HaveLen(1))
+		Expect(eventList.Items[0].Reason).To(Equal(k8sresources.EventReasonLunNotFound))
+		Expect(eventList.Items[0].Type).To(Equal(v1.EventTypeWarning))
+		Expect(eventList.Items[0].InvolvedObject.Name).To(Equal("pod1"))
+		Expect(eventList.Items[0].Message).To(ContainSubstring("pv1"))
+	})
+	It("fails when no pod has the UID", func() {
+		err := reporter.ReportPodVolumeFailure(k8sresources.PodRef{UID: "uid2"}, "pv1", "FailedUnmount", "exit status 32")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("NodePodLister", func() {
+	It("lists the UIDs of the pods of the node", func() {
+		clientset := k8sfake.NewSimpleClientset(
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default", UID: "uid1"}, Spec: v1.PodSpec{NodeName: "node1"}},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: "other", UID: "uid2"}, Spec: v1.PodSpec{NodeName: "node1"}},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod3", Namespace: "default", UID: "uid3"}, Spec: v1.PodSpec{NodeName: "node2"}},
+		)
+		uids, err := events.NewNodePodListerWithClient(clientset, "node1").ListPodUIDs()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(uids).To(Equal(map[string]bool{"uid1": true, "uid2": true}))
+	})
+})
+
+var _ = Describe("VolumeCredentialsGetter", func() {
+	var clientset *k8sfake.Clientset
+	BeforeEach(func() {
+		clientset = k8sfake.NewSimpleClientset(
+			&v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv1"},
+				Spec: v1.PersistentVolumeSpec{
+					PersistentVolumeSource: v1.PersistentVolumeSource{FlexVolume: &v1.FlexVolumeSource{SecretRef: &v1.LocalObjectReference{Name: "secret1"}}},
+					ClaimRef:               &v1.ObjectReference{Name: "pvc1", Namespace: "tenant1"},
+				},
+			},
+			&v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv2"},
+				Spec:       v1.PersistentVolumeSpec{PersistentVolumeSource: v1.PersistentVolumeSource{FlexVolume: &v1.FlexVolumeSource{}}},
+			},
+			&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "secret1", Namespace: "tenant1"},
+				Data:       map[string][]byte{k8sresources.SecretKeyUsername: []byte("user1"), k8sresources.SecretKeyPassword: []byte("password1")},
+			},
+		)
+	})
+
+	It("returns the credentials of the secret of the PV in the namespace of its claim", func() {
+		credentials, err := events.NewVolumeCredentialsGetterWithClient(clientset).GetVolumeCredentials("pv1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(credentials.UserName).To(Equal("user1"))
+		Expect(credentials.Password).To(Equal("password1"))
+	})
+	It("returns no credentials when the PV has no SecretRef or is gone", func() {
+		for _, pvName := range []string{"pv2", "pv3"} {
+			credentials, err := events.NewVolumeCredentialsGetterWithClient(clientset).GetVolumeCredentials(pvName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(credentials.UserName).To(BeEmpty())
+		}
+	})
+	It("fails when the secret of the PV is missing", func() {
+		Expect(clientset.CoreV1().Secrets("tenant1").Delete("secret1", &metav1.DeleteOptions{})).To(Succeed())
+		_, err := events.NewVolumeCredentialsGetterWithClient(clientset).GetVolumeCredentials("pv1")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	provisionerOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ubiquity",
+		Subsystem: "provisioner",
+		Name:      "operations_total",
+		Help:      "Provision and Delete operations of the provisioner by backend and result.",
+	}, []string{"operation", "backend", "result"})
+	provisionerOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ubiquity",
+		Subsystem: "provisioner",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the Provision and Delete operations of the provisioner by backend and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"operation", "backend", "result"})
+	storageClientCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ubiquity",
+		Subsystem: "storage_client",
+		Name:      "calls_total",
+		Help:      "Calls to the ubiquity server by call and result.",
+	}, []string{"call", "result"})
+	storageClientCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ubiquity",
+		Subsystem: "storage_client",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of the calls to the ubiquity server by call and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"call", "result"})
+	orphanedVolumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ubiquity",
+		Subsystem: "provisioner",
+		Name:      "orphaned_volumes",
+		Help:      "Volumes of the ubiquity server no PV references, by backend, as of the last run of the orphan collector.",
+	}, []string{"backend"})
+)
+
+func init() {
+	prometheus.MustRegister(provisionerOperations, provisionerOperationDuration, storageClientCalls, storageClientCallDuration, orphanedVolumes)
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+//ObserveProvisionerOperation records a Provision or a Delete that started at start and returned err
+func ObserveProvisionerOperation(operation string, backend string, start time.Time, err error) {
+	provisionerOperations.WithLabelValues(operation, backend, result(err)).Inc()
+	provisionerOperationDuration.WithLabelValues(operation, backend, result(err)).Observe(time.Since(start).Seconds())
+}
+
+//SetOrphanedVolumes records the number of orphaned volumes of each backend
+func SetOrphanedVolumes(orphansPerBackend map[string]int) {
+	orphanedVolumes.Reset()
+	for backend, orphans := range orphansPerBackend {
+		orphanedVolumes.WithLabelValues(backend).Set(float64(orphans))
+	}
+}
+
+func observeStorageClientCall(call string, start time.Time, err error) {
+	storageClientCalls.WithLabelValues(call, result(err)).Inc()
+	storageClientCallDuration.WithLabelValues(call, result(err)).Observe(time.Since(start).Seconds())
+}
+
+//Serve exposes the metrics on http://<address>/metrics, it returns only on failure
+func Serve(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(address, mux)
+}
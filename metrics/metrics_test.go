@@ -0,0 +1,93 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package metrics_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/IBM/ubiquity-k8s/metrics"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	Context(".TextfileRecorder", func() {
+		var (
+			textfileDir string
+			stateDir    string
+			recorder    *metrics.TextfileRecorder
+		)
+		BeforeEach(func() {
+			var err error
+			textfileDir, err = ioutil.TempDir("", "textfile")
+			Expect(err).ToNot(HaveOccurred())
+			stateDir, err = ioutil.TempDir("", "state")
+			Expect(err).ToNot(HaveOccurred())
+			recorder = metrics.NewTextfileRecorder(textfileDir, stateDir)
+		})
+		AfterEach(func() {
+			os.RemoveAll(textfileDir)
+			os.RemoveAll(stateDir)
+		})
+		It("accumulates the call-outs of every process in the textfile", func() {
+			Expect(recorder.Record("mount", true, 2*time.Second)).To(Succeed())
+			Expect(metrics.NewTextfileRecorder(textfileDir, stateDir).Record("mount", true, 20*time.Second)).To(Succeed())
+			Expect(recorder.Record("detach", false, 100*time.Millisecond)).To(Succeed())
+
+			textfile, err := ioutil.ReadFile(filepath.Join(textfileDir, "ubiquity_k8s_flex.prom"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(textfile)).To(ContainSubstring("ubiquity_flex_operations_total{operation=\"mount\",result=\"success\"} 2\n"))
+			Expect(string(textfile)).To(ContainSubstring("ubiquity_flex_operations_total{operation=\"detach\",result=\"failure\"} 1\n"))
+			Expect(string(textfile)).To(ContainSubstring("ubiquity_flex_operation_duration_seconds_bucket{operation=\"mount\",result=\"success\",le=\"5\"} 1\n"))
+			Expect(string(textfile)).To(ContainSubstring("ubiquity_flex_operation_duration_seconds_bucket{operation=\"mount\",result=\"success\",le=\"+Inf\"} 2\n"))
+			Expect(string(textfile)).To(ContainSubstring("ubiquity_flex_operation_duration_seconds_sum{operation=\"mount\",result=\"success\"} 22\n"))
+		})
+		It("leaves no temporary file in the textfile directory", func() {
+			Expect(recorder.Record("unmount", true, time.Second)).To(Succeed())
+			files, err := ioutil.ReadDir(textfileDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(HaveLen(1))
+		})
+	})
+	Context(".NewInstrumentedStorageClient", func() {
+		var fakeClient *fakes.FakeStorageClient
+		BeforeEach(func() {
+			fakeClient = new(fakes.FakeStorageClient)
+		})
+		It("returns the results of the client", func() {
+			fakeClient.AttachReturns("", fmt.Errorf("error attaching volume"))
+			client := metrics.NewInstrumentedStorageClient(fakeClient)
+			_, err := client.Attach(resources.AttachRequest{Name: "vol1"})
+			Expect(err).To(MatchError("error attaching volume"))
+			Expect(fakeClient.AttachCallCount()).To(Equal(1))
+		})
+		It("fails the optional calls the client does not support", func() {
+			client := metrics.NewInstrumentedStorageClient(fakeClient)
+			snapshotter, ok := client.(k8sresources.VolumeSnapshotter)
+			Expect(ok).To(BeTrue())
+			err := snapshotter.CreateSnapshot(k8sresources.CreateSnapshotRequest{Name: "snap1", VolumeName: "vol1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not support"))
+		})
+	})
+})
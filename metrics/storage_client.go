@@ -0,0 +1,100 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package metrics
+
+import (
+	"time"
+
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+)
+
+type instrumentedStorageClient struct {
+	k8sutils.OptionalStorageCalls
+	client resources.StorageClient
+}
+
+//NewInstrumentedStorageClient counts and times the calls of client
+func NewInstrumentedStorageClient(client resources.StorageClient) resources.StorageClient {
+	return &instrumentedStorageClient{
+		OptionalStorageCalls: k8sutils.OptionalStorageCalls{
+			Client: func() resources.StorageClient { return client },
+			Call: func(name string, call func() error) error {
+				start := time.Now()
+				err := call()
+				observeStorageClientCall(name, start, err)
+				return err
+			},
+		},
+		client: client,
+	}
+}
+
+func (c *instrumentedStorageClient) Activate(activateRequest resources.ActivateRequest) error {
+	start := time.Now()
+	err := c.client.Activate(activateRequest)
+	observeStorageClientCall("Activate", start, err)
+	return err
+}
+
+func (c *instrumentedStorageClient) CreateVolume(createVolumeRequest resources.CreateVolumeRequest) error {
+	start := time.Now()
+	err := c.client.CreateVolume(createVolumeRequest)
+	observeStorageClientCall("CreateVolume", start, err)
+	return err
+}
+
+func (c *instrumentedStorageClient) RemoveVolume(removeVolumeRequest resources.RemoveVolumeRequest) error {
+	start := time.Now()
+	err := c.client.RemoveVolume(removeVolumeRequest)
+	observeStorageClientCall("RemoveVolume", start, err)
+	return err
+}
+
+func (c *instrumentedStorageClient) ListVolumes(listVolumeRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	start := time.Now()
+	volumes, err := c.client.ListVolumes(listVolumeRequest)
+	observeStorageClientCall("ListVolumes", start, err)
+	return volumes, err
+}
+
+func (c *instrumentedStorageClient) GetVolume(getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	start := time.Now()
+	volume, err := c.client.GetVolume(getVolumeRequest)
+	observeStorageClientCall("GetVolume", start, err)
+	return volume, err
+}
+
+func (c *instrumentedStorageClient) GetVolumeConfig(getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	start := time.Now()
+	volumeConfig, err := c.client.GetVolumeConfig(getVolumeConfigRequest)
+	observeStorageClientCall("GetVolumeConfig", start, err)
+	return volumeConfig, err
+}
+
+func (c *instrumentedStorageClient) Attach(attachRequest resources.AttachRequest) (string, error) {
+	start := time.Now()
+	device, err := c.client.Attach(attachRequest)
+	observeStorageClientCall("Attach", start, err)
+	return device, err
+}
+
+func (c *instrumentedStorageClient) Detach(detachRequest resources.DetachRequest) error {
+	start := time.Now()
+	err := c.client.Detach(detachRequest)
+	observeStorageClientCall("Detach", start, err)
+	return err
+}
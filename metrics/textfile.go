@@ -0,0 +1,169 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+)
+
+// node_exporter reads the *.prom files of its textfile collector directory
+const textfileName = "ubiquity_k8s_flex.prom"
+const textfileLockTimeout = 5 * time.Second
+
+var textfileBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type textfileSeries struct {
+	Operation string
+	Result    string
+	Count     uint64
+	Sum       float64
+	// cumulative, one per textfileBuckets
+	Buckets []uint64
+}
+
+//TextfileRecorder accumulates the results and durations of the flex call-outs in a node_exporter textfile.
+//Every call-out is a process of its own, so the series are kept in a state file and the textfile is rewritten from it.
+type TextfileRecorder struct {
+	textfileDir string
+	statePath   string
+	locker      *k8sutils.KeyedLocker
+}
+
+//NewTextfileRecorder returns a recorder writing to textfileDir, with its state in stateDir.
+//The counters restart with the state, e.g when stateDir is a tmpfs and the node reboots.
+func NewTextfileRecorder(textfileDir string, stateDir string) *TextfileRecorder {
+	return &TextfileRecorder{
+		textfileDir: textfileDir,
+		statePath:   filepath.Join(stateDir, "ubiquity_k8s_flex_metrics.json"),
+		locker:      k8sutils.NewKeyedLocker(stateDir, "ubiquity"),
+	}
+}
+
+//Record adds a call-out of operation that took duration
+func (r *TextfileRecorder) Record(operation string, success bool, duration time.Duration) error {
+	unlock, err := r.locker.Lock("metrics", textfileLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	series, err := r.readState()
+	if err != nil {
+		return err
+	}
+	resultLabel := ResultFailure
+	if success {
+		resultLabel = ResultSuccess
+	}
+	key := operation + "/" + resultLabel
+	s, ok := series[key]
+	if !ok {
+		s = &textfileSeries{Operation: operation, Result: resultLabel, Buckets: make([]uint64, len(textfileBuckets))}
+		series[key] = s
+	}
+	s.Count++
+	s.Sum += duration.Seconds()
+	for i, bound := range textfileBuckets {
+		if duration.Seconds() <= bound {
+			s.Buckets[i]++
+		}
+	}
+
+	stateBytes, err := json.Marshal(series)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.statePath, stateBytes); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(r.textfileDir, textfileName), renderTextfile(series))
+}
+
+func (r *TextfileRecorder) readState() (map[string]*textfileSeries, error) {
+	series := make(map[string]*textfileSeries)
+	stateBytes, err := ioutil.ReadFile(r.statePath)
+	if os.IsNotExist(err) {
+		return series, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(stateBytes, &series); err != nil {
+		// a state we cannot read restarts the counters rather than blocking the metrics for good
+		return make(map[string]*textfileSeries), nil
+	}
+	return series, nil
+}
+
+func renderTextfile(series map[string]*textfileSeries) []byte {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.WriteString("# HELP ubiquity_flex_operations_total Flex call-outs by operation and result.\n")
+	buf.WriteString("# TYPE ubiquity_flex_operations_total counter\n")
+	for _, key := range keys {
+		s := series[key]
+		fmt.Fprintf(&buf, "ubiquity_flex_operations_total{operation=%q,result=%q} %d\n", s.Operation, s.Result, s.Count)
+	}
+	buf.WriteString("# HELP ubiquity_flex_operation_duration_seconds Duration of the flex call-outs by operation and result.\n")
+	buf.WriteString("# TYPE ubiquity_flex_operation_duration_seconds histogram\n")
+	for _, key := range keys {
+		s := series[key]
+		for i, bound := range textfileBuckets {
+			fmt.Fprintf(&buf, "ubiquity_flex_operation_duration_seconds_bucket{operation=%q,result=%q,le=%q} %d\n", s.Operation, s.Result, strconv.FormatFloat(bound, 'g', -1, 64), s.Buckets[i])
+		}
+		fmt.Fprintf(&buf, "ubiquity_flex_operation_duration_seconds_bucket{operation=%q,result=%q,le=\"+Inf\"} %d\n", s.Operation, s.Result, s.Count)
+		fmt.Fprintf(&buf, "ubiquity_flex_operation_duration_seconds_sum{operation=%q,result=%q} %s\n", s.Operation, s.Result, strconv.FormatFloat(s.Sum, 'g', -1, 64))
+		fmt.Fprintf(&buf, "ubiquity_flex_operation_duration_seconds_count{operation=%q,result=%q} %d\n", s.Operation, s.Result, s.Count)
+	}
+	return buf.Bytes()
+}
+
+// writeFileAtomic renames a complete file over path, node_exporter never reads a partial one
+func writeFileAtomic(path string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
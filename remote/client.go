@@ -0,0 +1,38 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package remote
+
+import (
+	"log"
+	"sync"
+
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/remote"
+	"github.com/IBM/ubiquity/resources"
+)
+
+// sslEnvLock serializes the clients reading their SSL settings from the environment
+var sslEnvLock sync.Mutex
+
+//NewRemoteClient allows to instantiate the client of the ubiquity server of config.
+//The ubiquity client has no clone or snapshot call, the VolumeCloner and VolumeSnapshotter calls fail as not supported with it.
+func NewRemoteClient(logger *log.Logger, config resources.UbiquityPluginConfig) (resources.StorageClient, error) {
+	// the ubiquity client reads the SSL settings from the environment when it is created, they are set from config for it only
+	sslEnvLock.Lock()
+	defer sslEnvLock.Unlock()
+	k8sutils.SetSslEnv(config.SslConfig)
+	return remote.NewRemoteClientSecure(logger, config)
+}
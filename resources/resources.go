@@ -1,8 +1,13 @@
 package resources
 
+import (
+	"github.com/IBM/ubiquity/resources"
+)
+
 const KubernetesVersion_1_5 = "1.5"
 const KubernetesVersion_1_6OrLater = "atLeast1.6"
 const ProvisionerName = "ubiquity/flex"
+
 // This ubiquity flexvolume name must be part of the flexvol CLI directory and CLI name in the minions.
 // Here is template of the path:
 // /usr/libexec/kubernetes/kubelet-plugins/volume/exec/${UbiquityK8sFlexVolumeDriverVendor}~${UbiquityK8sFlexVolumeDriverName}/${UbiquityK8sFlexVolumeDriverName}
@@ -17,12 +22,238 @@ const FlexDir = "/usr/libexec/kubernetes/kubelet-plugins/volume/exec/" + Ubiquit
 const FlexLogFilePath = FlexDir + "/" + UbiquityFlexLogFileName
 const FlexConfPath = FlexDir + "/" + UbiquityK8sFlexVolumeDriverName + ".conf"
 
+// The flex agent (<driver executable> agent) serves the call-outs of the node on this socket, the flex executable forwards them to it
+const FlexAgentSocketPath = FlexDir + "/" + UbiquityK8sFlexVolumeDriverName + "-agent.sock"
+
+// The flex agent reloads its config this often, the plain call-outs read it at each call
+const FlexAgentConfigReloadIntervalSeconds = 30
+
+// The kubelet global mount path of a flex volume (mountdevice) is <kubelet root dir>/${FlexDeviceMountDir}/<volume name>
+const FlexDeviceMountDir = "plugins/kubernetes.io/flexvolume/" + UbiquityK8sFlexVolumeDriverFullName + "/mounts"
+
+// The CSI plugin name as reported by GetPluginInfo, it must be the driver name used by the external-provisioner and external-attacher sidecars.
+const UbiquityK8sCsiDriverName = "ubiquity-k8s-csi"
+const UbiquityK8sCsiDriverFullName = UbiquityK8sFlexVolumeDriverVendor + "." + UbiquityK8sCsiDriverName
+const UbiquityK8sCsiDriverVersion = "1.0.0"
+const UbiquityCsiLogFileName = UbiquityK8sCsiDriverName + ".log"
+const CsiDefaultEndpoint = "unix:///var/lib/kubelet/plugins/" + UbiquityK8sCsiDriverFullName + "/csi.sock"
+
+// WaitForAttachConfig is read from the [WaitForAttach] section of the flex config file.
+// The waitforattach call-out polls for the volume device, starting with InitialBackoffMilliseconds between retries and doubling it up to MaxBackoffMilliseconds, until TimeoutSeconds elapsed.
+type WaitForAttachConfig struct {
+	TimeoutSeconds             int
+	InitialBackoffMilliseconds int
+	MaxBackoffMilliseconds     int
+}
+
+//FlexConfig holds the sections of the flex config file that only ubiquity-k8s reads, the rest is the ubiquity plugin config
+type FlexConfig struct {
+	WaitForAttach WaitForAttachConfig
+	// backend name to PodMountLayoutSymlink or PodMountLayoutBind
+	PodMountLayout map[string]string
+	Metrics        MetricsConfig
+	Events         EventsConfig
+	OrphanCleanup  OrphanCleanupConfig
+	ClientRetry    ClientRetryConfig
+}
+
+//ProvisionerConfig holds the sections of the provisioner config file that only ubiquity-k8s reads, the rest is the ubiquity plugin config
+type ProvisionerConfig struct {
+	Topology    []TopologyConfig
+	ClientRetry ClientRetryConfig
+}
+
+//CsiConfig holds the sections of the CSI driver config file that only ubiquity-k8s reads, the rest is the ubiquity plugin config
+type CsiConfig struct {
+	ClientRetry ClientRetryConfig
+}
+
+//TopologyConfig is a part of the storage reachable only from the nodes with all the Labels, e.g a Spectrum Scale filesystem mounted on some nodes or a SCBE service of a fabric.
+//It is read from a [[Topology]] section of the provisioner config file, the provisioner creates the volumes of a storage class with the first one reachable from the pod node and allowed by the class.
+type TopologyConfig struct {
+	Labels  map[string]string
+	Backend string
+	// the storage class parameters of the volumes created there, e.g filesystem or profile
+	Parameters map[string]string
+}
+
+//TopologySelectorTerm stands for the v1.TopologySelectorTerm of the storage class allowedTopologies, which the vendored API (release-1.8) does not have yet.
+//A topology matches the term when it matches all its expressions.
+type TopologySelectorTerm struct {
+	MatchLabelExpressions []TopologySelectorLabelRequirement `json:"matchLabelExpressions"`
+}
+
+//TopologySelectorLabelRequirement matches the topologies whose label Key has one of the Values
+type TopologySelectorLabelRequirement struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+//EventsConfig enables the Events on the pods whose volume fails to mount or unmount, the call-outs post them with the Kubeconfig credentials
+//The flex driver also sets the capacity of the claims whose filesystem it grew with these credentials
+type EventsConfig struct {
+	Kubeconfig string
+	// the node of the Events source, the hostname by default
+	NodeName string
+}
+
+//OrphanCleanupConfig is read from the [OrphanCleanup] section of the flex config file.
+//The flex agent looks every IntervalSeconds for the pod symlinks, the mounts and the multipath devices ubiquity left behind (e.g a node crashed mid-unmount), and removes them unless DryRun.
+//The live pods are listed with the Kubeconfig of the [Events] section, without it every pod directory of the node counts as live.
+type OrphanCleanupConfig struct {
+	// 0 disables the periodic cleanup, the cleanup command still runs it on demand
+	IntervalSeconds int
+	DryRun          bool
+	// KubeletDefaultRootDir by default
+	KubeletRootDir string
+	// the node name the backend attaches the volumes to, the one of the [Events] section or the hostname by default
+	NodeName string
+}
+
+const KubeletDefaultRootDir = "/var/lib/kubelet"
+
+//ClientRetryConfig is read from the [ClientRetry] section of the flex, provisioner and CSI config files.
+//A ubiquity client call failing as transient (e.g connection refused while the ubiquity server restarts) is retried up to MaxRetries times, waiting a jittered backoff starting with InitialBackoffMilliseconds and doubling up to MaxBackoffMilliseconds.
+//After CircuitBreakerThreshold consecutive transient or timed out calls the calls fail fast for CircuitBreakerOpenMilliseconds, then the next call tries the server again.
+//The zero values keep the defaults.
+type ClientRetryConfig struct {
+	// -1 disables the retries
+	MaxRetries                 int
+	InitialBackoffMilliseconds int
+	MaxBackoffMilliseconds     int
+	// a call not returning by then fails as timed out, 0 waits as long as the ubiquity client does.
+	// The ubiquity client has no cancellable transport, the abandoned request still runs and a write may still be done by the server.
+	CallTimeoutMilliseconds int
+	// -1 disables the circuit breaker
+	CircuitBreakerThreshold        int
+	CircuitBreakerOpenMilliseconds int
+}
+
+const ClientRetryDefaultMaxRetries = 3
+const ClientRetryDefaultInitialBackoffMilliseconds = 500
+const ClientRetryDefaultMaxBackoffMilliseconds = 5000
+const ClientRetryDefaultCircuitBreakerThreshold = 5
+const ClientRetryDefaultCircuitBreakerOpenMilliseconds = 30000
+
+//PodRef identifies the pod of a flex call-out, kubelet passes only the UID (in the pod volume path) to unmount
+type PodRef struct {
+	UID       string
+	Name      string
+	Namespace string
+}
+
+// The reasons of the Events of storage failures, classified from the error messages of the backends and the node
+const (
+	EventReasonBackendUnreachable = "BackendUnreachable"
+	EventReasonLunNotFound        = "LunNotFound"
+	EventReasonMultipathTimeout   = "MultipathTimeout"
+	EventReasonFilesetNotLinked   = "FilesetNotLinked"
+)
+
+// The codes of the failed FlexVolumeResponses. Transient and timeout failures are worth a retry, the others need a fix of the request or of the configuration
+const (
+	ErrorCodeInvalidRequest    = "InvalidRequest"
+	ErrorCodeBackendNotFound   = "BackendNotFound"
+	ErrorCodeVolumeNotFound    = "VolumeNotFound"
+	ErrorCodeSnapshotNotFound  = "SnapshotNotFound"
+	ErrorCodeAlreadyExists     = "AlreadyExists"
+	ErrorCodeAttachedElsewhere = "AttachedElsewhere"
+	ErrorCodeTimeout           = "Timeout"
+	ErrorCodeTransient         = "Transient"
+)
+
+// The options kubelet adds to the json options of the mount call-out (v>=1.8)
+const OptionNamePodName = "kubernetes.io/pod.name"
+const OptionNamePodNamespace = "kubernetes.io/pod.namespace"
+
+// The storage class parameters of the secret holding the ubiquity credentials of its volumes, in the style of csi.storage.k8s.io/provisioner-secret-name.
+// The name and the namespace may contain ${pvc.name} and ${pvc.namespace}, the namespace is the one of the claim by default.
+// The secret has the SecretKeyUsername and SecretKeyPassword keys, the PVs reference it as the SecretRef of their flex volume.
+const ParameterSecretName = "ubiquity.ibm.com/secret-name"
+const ParameterSecretNamespace = "ubiquity.ibm.com/secret-namespace"
+
+// The storage class parameter standing for allowedTopologies, a JSON list of TopologySelectorTerm
+const ParameterAllowedTopologies = "ubiquity.ibm.com/allowed-topologies"
+const SecretKeyUsername = "username"
+const SecretKeyPassword = "password"
+
+// kubelet passes each key of the SecretRef of a flex volume base64 encoded in the option <prefix><key> of the mount call-out
+const OptionPrefixSecret = "kubernetes.io/secret/"
+
+//MetricsConfig tells where the flex call-outs record their results and durations for the node_exporter textfile collector
+type MetricsConfig struct {
+	TextfileDir string
+}
+
+const MetricsDefaultTextfileDir = "/var/lib/node_exporter/textfile_collector"
+
+const WaitForAttachDefaultTimeoutSeconds = 60
+const WaitForAttachDefaultInitialBackoffMilliseconds = 500
+const WaitForAttachDefaultMaxBackoffMilliseconds = 5000
+
+// The pod volume path is a symlink to the ubiquity mountpoint of the volume (default) or a bind mount of it.
+// The layout is set per backend in the [PodMountLayout] section of the flex config file, and per storage class with the podMountLayout parameter.
+const PodMountLayoutSymlink = "symlink"
+const PodMountLayoutBind = "bind"
+const OptionNamePodMountLayout = "podMountLayout"
+
+//CloneVolumeRequest asks the backend to create the volume Name as a copy of SourceName, i.e a snapshot-and-copy of a Spectrum Scale fileset or a FlashCopy of a SCBE LUN.
+//The clone and snapshot requests carry the credentials of their volume like the ubiquity requests do.
+type CloneVolumeRequest struct {
+	Name           string
+	SourceName     string
+	Backend        string
+	Opts           map[string]interface{}
+	CredentialInfo resources.CredentialInfo
+}
+
+//VolumeCloner is implemented by the ubiquity storage clients that can clone volumes, the others fail the clone as not supported
+type VolumeCloner interface {
+	CloneVolume(cloneVolumeRequest CloneVolumeRequest) error
+}
+
+//CreateSnapshotRequest asks the backend to take the snapshot Name of a volume, i.e a Spectrum Scale fileset snapshot or a SCBE volume snapshot
+type CreateSnapshotRequest struct {
+	Name           string
+	VolumeName     string
+	Backend        string
+	CredentialInfo resources.CredentialInfo
+}
+
+type DeleteSnapshotRequest struct {
+	Name           string
+	VolumeName     string
+	Backend        string
+	CredentialInfo resources.CredentialInfo
+}
+
+//RestoreSnapshotRequest asks the backend to create the volume Name with the content of the snapshot SnapshotName of SourceVolumeName
+type RestoreSnapshotRequest struct {
+	Name             string
+	SnapshotName     string
+	SourceVolumeName string
+	Backend          string
+	Opts             map[string]interface{}
+	CredentialInfo   resources.CredentialInfo
+}
+
+//VolumeSnapshotter is implemented by the ubiquity storage clients that can snapshot volumes, the others fail the snapshot calls as not supported
+type VolumeSnapshotter interface {
+	CreateSnapshot(createSnapshotRequest CreateSnapshotRequest) error
+	DeleteSnapshot(deleteSnapshotRequest DeleteSnapshotRequest) error
+	RestoreSnapshot(restoreSnapshotRequest RestoreSnapshotRequest) error
+}
+
 type FlexVolumeResponse struct {
 	Status     string `json:"status"`
 	Message    string `json:"message"`
 	Device     string `json:"device"`
 	VolumeName string `json:"volumeName"`
 	Attached   bool   `json:"attached"`
+	// Code classifies a failure, empty when the failure is not classified
+	Code string `json:"code,omitempty"`
+	// Retryable tells the same call-out may succeed later without any fix, i.e its failure is transient or a timeout
+	Retryable bool `json:"retryable,omitempty"`
 }
 
 type FlexVolumeMountRequest struct {
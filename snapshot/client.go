@@ -0,0 +1,58 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package snapshot
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/rest"
+)
+
+//NewClient returns a REST client of the VolumeSnapshot CRD
+func NewClient(config *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	crdConfig := *config
+	crdConfig.GroupVersion = &SchemeGroupVersion
+	crdConfig.APIPath = "/apis"
+	crdConfig.ContentType = runtime.ContentTypeJSON
+	crdConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	return rest.RESTClientFor(&crdConfig)
+}
+
+//Get returns the VolumeSnapshot namespace/name
+func Get(client rest.Interface, namespace string, name string) (*VolumeSnapshot, error) {
+	var volumeSnapshot VolumeSnapshot
+	err := client.Get().Namespace(namespace).Resource(VolumeSnapshotResourcePlural).Name(name).Do().Into(&volumeSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &volumeSnapshot, nil
+}
+
+//Update writes the VolumeSnapshot back, the CRD has no status subresource so it also carries the status
+func Update(client rest.Interface, volumeSnapshot *VolumeSnapshot) (*VolumeSnapshot, error) {
+	var result VolumeSnapshot
+	err := client.Put().Namespace(volumeSnapshot.Namespace).Resource(VolumeSnapshotResourcePlural).Name(volumeSnapshot.Name).Body(volumeSnapshot).Do().Into(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,127 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package snapshot
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GroupName = "ubiquity.ibm.com"
+	//VolumeSnapshotResourcePlural is the resource name of the VolumeSnapshot CRD, see deploy/volume_snapshot_crd.yml
+	VolumeSnapshotResourcePlural = "volumesnapshots"
+	VolumeSnapshotKind           = "VolumeSnapshot"
+)
+
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1"}
+
+var (
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion, &VolumeSnapshot{}, &VolumeSnapshotList{})
+	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+	return nil
+}
+
+//VolumeSnapshot is a point-in-time snapshot of the ubiquity volume bound to a claim of its namespace
+type VolumeSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeSnapshotSpec   `json:"spec"`
+	Status VolumeSnapshotStatus `json:"status,omitempty"`
+}
+
+type VolumeSnapshotSpec struct {
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
+}
+
+//VolumeSnapshotStatus is filled by the snapshot controller, Ready once the backend took the snapshot
+type VolumeSnapshotStatus struct {
+	Ready bool `json:"ready"`
+	// the name of the fileset snapshot or the SCBE volume snapshot on the backend
+	SnapshotName     string            `json:"snapshotName,omitempty"`
+	SourceVolumeName string            `json:"sourceVolumeName,omitempty"`
+	Backend          string            `json:"backend,omitempty"`
+	RestoreSize      resource.Quantity `json:"restoreSize,omitempty"`
+	CreationTime     *metav1.Time      `json:"creationTime,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+type VolumeSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []VolumeSnapshot `json:"items"`
+}
+
+func (in *VolumeSnapshot) DeepCopyInto(out *VolumeSnapshot) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	out.Status = in.Status
+	out.Status.RestoreSize = in.Status.RestoreSize.DeepCopy()
+	if in.Status.CreationTime != nil {
+		creationTime := *in.Status.CreationTime
+		out.Status.CreationTime = &creationTime
+	}
+}
+
+func (in *VolumeSnapshot) DeepCopy() *VolumeSnapshot {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSnapshot)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *VolumeSnapshot) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *VolumeSnapshotList) DeepCopyInto(out *VolumeSnapshotList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]VolumeSnapshot, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *VolumeSnapshotList) DeepCopy() *VolumeSnapshotList {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSnapshotList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *VolumeSnapshotList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
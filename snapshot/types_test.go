@@ -0,0 +1,49 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package snapshot_test
+
+import (
+	"github.com/IBM/ubiquity-k8s/snapshot"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("VolumeSnapshot", func() {
+	Context(".DeepCopy", func() {
+		It("does not share the metadata and the status with the copy", func() {
+			now := metav1.Now()
+			volumeSnapshot := &snapshot.VolumeSnapshot{
+				ObjectMeta: metav1.ObjectMeta{Name: "snap1", Namespace: "default", Labels: map[string]string{"app": "db"}},
+				Spec:       snapshot.VolumeSnapshotSpec{PersistentVolumeClaimName: "pvc1"},
+				Status:     snapshot.VolumeSnapshotStatus{Ready: true, RestoreSize: resource.MustParse("1Gi"), CreationTime: &now},
+			}
+			volumeSnapshotCopy := volumeSnapshot.DeepCopy()
+			Expect(volumeSnapshotCopy).To(Equal(volumeSnapshot))
+
+			volumeSnapshotCopy.Labels["app"] = "web"
+			Expect(volumeSnapshot.Labels["app"]).To(Equal("db"))
+			Expect(volumeSnapshotCopy.Status.CreationTime).ToNot(BeIdenticalTo(volumeSnapshot.Status.CreationTime))
+		})
+		It("copies the items of a list", func() {
+			list := &snapshot.VolumeSnapshotList{Items: []snapshot.VolumeSnapshot{{Spec: snapshot.VolumeSnapshotSpec{PersistentVolumeClaimName: "pvc1"}}}}
+			listCopy := list.DeepCopyObject().(*snapshot.VolumeSnapshotList)
+			listCopy.Items[0].Spec.PersistentVolumeClaimName = "pvc2"
+			Expect(list.Items[0].Spec.PersistentVolumeClaimName).To(Equal("pvc1"))
+		})
+	})
+})
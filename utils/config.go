@@ -0,0 +1,275 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/IBM/ubiquity/remote"
+	"github.com/IBM/ubiquity/resources"
+	"gopkg.in/yaml.v2"
+)
+
+// The environment variables override the config file, the provisioner and the CSI driver may get their whole config from them
+var configEnvs = []struct {
+	name  string
+	apply func(config *resources.UbiquityPluginConfig, value string) error
+}{
+	{"LOG_PATH", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.LogPath = value
+		return nil
+	}},
+	{"LOG_LEVEL", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.LogLevel = value
+		return nil
+	}},
+	{"BACKENDS", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.Backends = nil
+		for _, backend := range strings.Split(value, ",") {
+			if backend = strings.TrimSpace(backend); backend != "" {
+				config.Backends = append(config.Backends, backend)
+			}
+		}
+		return nil
+	}},
+	{"UBIQUITY_ADDRESS", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.UbiquityServer.Address = value
+		return nil
+	}},
+	{"UBIQUITY_PORT", func(config *resources.UbiquityPluginConfig, value string) error {
+		port, err := strconv.ParseInt(value, 0, 32)
+		config.UbiquityServer.Port = int(port)
+		return err
+	}},
+	{"UBIQUITY_USERNAME", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.CredentialInfo.UserName = value
+		return nil
+	}},
+	{"UBIQUITY_PASSWORD", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.CredentialInfo.Password = value
+		return nil
+	}},
+	// the directory of the mounted credentials secret, its username and password keys win over the variables, and follow the secret updates
+	{"UBIQUITY_CREDENTIALS_DIR", func(config *resources.UbiquityPluginConfig, value string) error {
+		username, err := ioutil.ReadFile(filepath.Join(value, "username"))
+		if err != nil {
+			return err
+		}
+		password, err := ioutil.ReadFile(filepath.Join(value, "password"))
+		if err != nil {
+			return err
+		}
+		config.CredentialInfo.UserName = strings.TrimSpace(string(username))
+		config.CredentialInfo.Password = strings.TrimSpace(string(password))
+		return nil
+	}},
+	{"SPECTRUM_NFS_REMOTE_CONFIG", func(config *resources.UbiquityPluginConfig, value string) error {
+		config.SpectrumNfsRemoteConfig.ClientConfig = value
+		return nil
+	}},
+	{"SCBE_SKIP_RESCAN_ISCSI", func(config *resources.UbiquityPluginConfig, value string) (err error) {
+		config.ScbeRemoteConfig.SkipRescanISCSI, err = strconv.ParseBool(value)
+		return err
+	}},
+	{remote.KeyUseSsl, func(config *resources.UbiquityPluginConfig, value string) (err error) {
+		config.SslConfig.UseSsl, err = strconv.ParseBool(value)
+		return err
+	}},
+	{resources.KeySslMode, func(config *resources.UbiquityPluginConfig, value string) error {
+		config.SslConfig.SslMode = value
+		return nil
+	}},
+	{remote.KeyVerifyCA, func(config *resources.UbiquityPluginConfig, value string) error {
+		config.SslConfig.VerifyCa = value
+		return nil
+	}},
+}
+
+// The SSL variables are also the settings of the ubiquity client, SetSslEnv sets them from the config it connects with.
+// A load then takes the value the process started with rather than the one set from the previous config, so that the SSL settings of the config file can be reloaded.
+var (
+	startupSslEnvs = map[string]string{
+		remote.KeyUseSsl:     os.Getenv(remote.KeyUseSsl),
+		resources.KeySslMode: os.Getenv(resources.KeySslMode),
+		remote.KeyVerifyCA:   os.Getenv(remote.KeyVerifyCA),
+	}
+	sslEnvsSet     = map[string]string{}
+	sslEnvsSetLock sync.Mutex
+)
+
+var logLevels = []string{"debug", "info", "error"}
+
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([-a-zA-Z0-9]*[a-zA-Z0-9])?)*$`)
+
+//LoadConfig loads the ubiquity config from the environment variables only
+func LoadConfig() (resources.UbiquityPluginConfig, error) {
+	return LoadConfigFile("")
+}
+
+//LoadConfigFile loads the ubiquity config shared by the provisioner, the CSI driver and the flex driver.
+//It reads the TOML or YAML configFile if any, applies the environment variables over it, and validates the result.
+//The error tells all the problems found at once.
+func LoadConfigFile(configFile string) (resources.UbiquityPluginConfig, error) {
+	var config resources.UbiquityPluginConfig
+	if configFile != "" {
+		if err := DecodeConfigFile(configFile, &config); err != nil {
+			return resources.UbiquityPluginConfig{}, err
+		}
+	}
+
+	var problems []string
+	for _, env := range configEnvs {
+		value := envValue(env.name)
+		if value == "" {
+			continue
+		}
+		if err := env.apply(&config, value); err != nil {
+			problems = append(problems, fmt.Sprintf("environment variable %s=%q is invalid: %v", env.name, value, err))
+		}
+	}
+	problems = append(problems, validateConfig(config)...)
+	if len(problems) > 0 {
+		return resources.UbiquityPluginConfig{}, fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+
+	return config, nil
+}
+
+//SetSslEnv sets the SSL variables the ubiquity client reads its settings from when it is created, see k8sremote.NewRemoteClient
+func SetSslEnv(sslConfig resources.UbiquityPluginSslConfig) {
+	sslEnvsSetLock.Lock()
+	defer sslEnvsSetLock.Unlock()
+	for name, value := range map[string]string{
+		remote.KeyUseSsl:     strconv.FormatBool(sslConfig.UseSsl),
+		resources.KeySslMode: sslConfig.SslMode,
+		remote.KeyVerifyCA:   sslConfig.VerifyCa,
+	} {
+		os.Setenv(name, value)
+		sslEnvsSet[name] = value
+	}
+}
+
+// envValue is the value of the environment variable, or the startup one of an SSL variable SetSslEnv set
+func envValue(name string) string {
+	value := os.Getenv(name)
+	sslEnvsSetLock.Lock()
+	defer sslEnvsSetLock.Unlock()
+	if setValue, ok := sslEnvsSet[name]; ok && setValue == value {
+		return startupSslEnvs[name]
+	}
+	return value
+}
+
+//DecodeConfigFile decodes the YAML config files (.yml or .yaml) and the TOML ones (any other extension) into v.
+//The keys match the field names of v whatever their case, in both formats.
+func DecodeConfigFile(configFile string, v interface{}) error {
+	extension := strings.ToLower(filepath.Ext(configFile))
+	if extension != ".yml" && extension != ".yaml" {
+		if _, err := toml.DecodeFile(configFile, v); err != nil {
+			return fmt.Errorf("error decoding config file %s: %v", configFile, err)
+		}
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("error reading config file %s: %v", configFile, err)
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("error decoding config file %s: %v", configFile, err)
+	}
+	// json matches the keys to the fields case insensitively, as toml does
+	jsonDocument, err := json.Marshal(yamlToJSON(document))
+	if err != nil {
+		return fmt.Errorf("error decoding config file %s: %v", configFile, err)
+	}
+	if err := json.Unmarshal(jsonDocument, v); err != nil {
+		return fmt.Errorf("error decoding config file %s: %v", configFile, err)
+	}
+	return nil
+}
+
+// yamlToJSON turns the maps of a YAML document into maps json can marshal
+func yamlToJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			object[fmt.Sprintf("%v", key)] = yamlToJSON(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range value {
+			value[i] = yamlToJSON(item)
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+func validateConfig(config resources.UbiquityPluginConfig) []string {
+	var problems []string
+
+	if len(config.Backends) == 0 {
+		problems = append(problems, "no backend is configured")
+	}
+	for _, backend := range config.Backends {
+		if _, ok := backendParameters[backend]; !ok {
+			problems = append(problems, fmt.Sprintf("backend %q is not supported, expected one of %s", backend, strings.Join(supportedBackends(), ", ")))
+		}
+	}
+
+	address := config.UbiquityServer.Address
+	if address == "" {
+		problems = append(problems, "the ubiquity server address is not configured")
+	} else if net.ParseIP(address) == nil && !hostnamePattern.MatchString(address) {
+		problems = append(problems, fmt.Sprintf("the ubiquity server address %q is neither an IP address nor a host name, it must not have a scheme or a port", address))
+	}
+	if port := config.UbiquityServer.Port; port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("the ubiquity server port %d is not between 1 and 65535", port))
+	}
+
+	if sslMode := config.SslConfig.SslMode; config.SslConfig.UseSsl || sslMode != "" {
+		if sslMode != resources.SslModeRequire && sslMode != resources.SslModeVerifyFull {
+			problems = append(problems, fmt.Sprintf("SSL mode %q is invalid, expected %s or %s", sslMode, resources.SslModeRequire, resources.SslModeVerifyFull))
+		}
+	}
+	// the images default to a CA path that exists only when the certificate is mounted, without it the server is not verified
+	if verifyCa := config.SslConfig.VerifyCa; verifyCa != "" {
+		if !filepath.IsAbs(verifyCa) {
+			problems = append(problems, fmt.Sprintf("the CA certificate path %s is not absolute", verifyCa))
+		} else if info, err := os.Stat(verifyCa); err == nil && !info.Mode().IsRegular() {
+			problems = append(problems, fmt.Sprintf("the CA certificate %s is not a regular file", verifyCa))
+		}
+	}
+
+	if config.LogLevel != "" && !containsString(logLevels, strings.ToLower(config.LogLevel)) {
+		problems = append(problems, fmt.Sprintf("log level %q is invalid, expected one of %s", config.LogLevel, strings.Join(logLevels, ", ")))
+	}
+	return problems
+}
@@ -0,0 +1,173 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("LoadConfigFile", func() {
+	var (
+		configDir string
+		envs      = []string{"LOG_PATH", "LOG_LEVEL", "BACKENDS", "UBIQUITY_ADDRESS", "UBIQUITY_PORT", "UBIQUITY_USERNAME", "UBIQUITY_PASSWORD",
+			"UBIQUITY_CREDENTIALS_DIR", "SPECTRUM_NFS_REMOTE_CONFIG", "SCBE_SKIP_RESCAN_ISCSI", "UBIQUITY_PLUGIN_USE_SSL", "UBIQUITY_PLUGIN_SSL_MODE", "UBIQUITY_PLUGIN_VERIFY_CA"}
+		savedEnvs map[string]string
+	)
+	writeConfigFile := func(name string, content string) string {
+		configFile := filepath.Join(configDir, name)
+		Expect(ioutil.WriteFile(configFile, []byte(content), 0600)).To(Succeed())
+		return configFile
+	}
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir("", "ubiquity-k8s-config")
+		Expect(err).ToNot(HaveOccurred())
+		savedEnvs = map[string]string{}
+		for _, env := range envs {
+			savedEnvs[env] = os.Getenv(env)
+			os.Unsetenv(env)
+		}
+	})
+	AfterEach(func() {
+		os.RemoveAll(configDir)
+		for env, value := range savedEnvs {
+			os.Setenv(env, value)
+		}
+	})
+
+	It("reads a TOML file and lets the environment override it", func() {
+		configFile := writeConfigFile("ubiquity-k8s-flex.conf", `
+logPath = "/var/log"
+backends = ["scbe"]
+logLevel = "info"
+
+[UbiquityServer]
+address = "ubiquity"
+port = 9999
+
+[SslConfig]
+UseSsl = true
+SslMode = "verify-full"
+`)
+		os.Setenv("UBIQUITY_ADDRESS", "10.0.0.1")
+		config, err := k8sutils.LoadConfigFile(configFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Backends).To(Equal([]string{resources.SCBE}))
+		Expect(config.UbiquityServer).To(Equal(resources.UbiquityServerConnectionInfo{Address: "10.0.0.1", Port: 9999}))
+		Expect(config.SslConfig.SslMode).To(Equal(resources.SslModeVerifyFull))
+		Expect(os.Getenv("UBIQUITY_PLUGIN_SSL_MODE")).To(BeEmpty())
+	})
+	It("reloads the SSL settings of the file the remote client was set with", func() {
+		configFile := writeConfigFile("ubiquity-k8s-flex.conf", `
+backends = ["scbe"]
+
+[UbiquityServer]
+address = "ubiquity"
+port = 9999
+`)
+		config, err := k8sutils.LoadConfigFile(configFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.SslConfig.UseSsl).To(BeFalse())
+		k8sutils.SetSslEnv(config.SslConfig)
+
+		writeConfigFile("ubiquity-k8s-flex.conf", `
+backends = ["scbe"]
+
+[UbiquityServer]
+address = "ubiquity"
+port = 9999
+
+[SslConfig]
+UseSsl = true
+SslMode = "require"
+`)
+		config, err = k8sutils.LoadConfigFile(configFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.SslConfig).To(Equal(resources.UbiquityPluginSslConfig{UseSsl: true, SslMode: resources.SslModeRequire}))
+	})
+	It("reads a YAML file whatever the case of its keys", func() {
+		configFile := writeConfigFile("ubiquity.yml", `
+logPath: /var/log
+backends: [spectrum-scale, scbe]
+ubiquityServer:
+  address: ubiquity.ubiquity.svc
+  port: 9999
+credentialInfo:
+  userName: admin
+podMountLayout:
+  scbe: bind
+`)
+		config, err := k8sutils.LoadConfigFile(configFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Backends).To(Equal([]string{resources.SpectrumScale, resources.SCBE}))
+		Expect(config.UbiquityServer.Address).To(Equal("ubiquity.ubiquity.svc"))
+		Expect(config.CredentialInfo.UserName).To(Equal("admin"))
+
+		var flexConfig k8sresources.FlexConfig
+		Expect(k8sutils.DecodeConfigFile(configFile, &flexConfig)).To(Succeed())
+		Expect(flexConfig.PodMountLayout).To(Equal(map[string]string{resources.SCBE: k8sresources.PodMountLayoutBind}))
+	})
+	It("reads the environment only without a file", func() {
+		os.Setenv("BACKENDS", "scbe, spectrum-scale")
+		os.Setenv("UBIQUITY_ADDRESS", "ubiquity")
+		os.Setenv("UBIQUITY_PORT", "9999")
+		config, err := k8sutils.LoadConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Backends).To(Equal([]string{resources.SCBE, resources.SpectrumScale}))
+		Expect(config.UbiquityServer.Port).To(Equal(9999))
+	})
+	It("reads the credentials of the mounted secret over the environment", func() {
+		writeConfigFile("username", "admin\n")
+		writeConfigFile("password", "secret\n")
+		os.Setenv("BACKENDS", "scbe")
+		os.Setenv("UBIQUITY_ADDRESS", "ubiquity")
+		os.Setenv("UBIQUITY_PORT", "9999")
+		os.Setenv("UBIQUITY_USERNAME", "user")
+		os.Setenv("UBIQUITY_CREDENTIALS_DIR", configDir)
+		config, err := k8sutils.LoadConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.CredentialInfo).To(Equal(resources.CredentialInfo{UserName: "admin", Password: "secret"}))
+	})
+	It("returns all the problems at once", func() {
+		os.Setenv("BACKENDS", "scbe,gpfs")
+		os.Setenv("UBIQUITY_ADDRESS", "https://ubiquity:9999")
+		os.Setenv("UBIQUITY_PORT", "99a")
+		os.Setenv("UBIQUITY_PLUGIN_SSL_MODE", "verify")
+		os.Setenv("LOG_LEVEL", "verbose")
+		_, err := k8sutils.LoadConfig()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`environment variable UBIQUITY_PORT="99a" is invalid`))
+		Expect(err.Error()).To(ContainSubstring(`backend "gpfs" is not supported`))
+		Expect(err.Error()).To(ContainSubstring(`address "https://ubiquity:9999" is neither an IP address nor a host name`))
+		Expect(err.Error()).To(ContainSubstring(`SSL mode "verify" is invalid`))
+		Expect(err.Error()).To(ContainSubstring(`log level "verbose" is invalid`))
+	})
+	It("fails when the file cannot be decoded", func() {
+		configFile := writeConfigFile("ubiquity.yaml", "backends: [scbe\n")
+		_, err := k8sutils.LoadConfigFile(configFile)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("error decoding config file"))
+	})
+})
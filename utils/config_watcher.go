@@ -0,0 +1,158 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+const maskedSecret = "****"
+
+// The fields of the config never logged in clear
+var secretConfigFields = map[string]bool{"Password": true}
+
+//ConfigWatcher reloads the ubiquity config periodically, and swaps in the new config when it changed and every handler accepted it.
+//Polling the content, rather than watching the files, also sees the secrets kubelet updates by swapping symlinks.
+type ConfigWatcher struct {
+	load     func() (resources.UbiquityPluginConfig, error)
+	lock     sync.RWMutex
+	config   resources.UbiquityPluginConfig
+	handlers []func(oldConfig, newConfig resources.UbiquityPluginConfig) error
+}
+
+//NewConfigWatcher allows to instantiate a watcher of the config load returns, config being the one in use
+func NewConfigWatcher(load func() (resources.UbiquityPluginConfig, error), config resources.UbiquityPluginConfig) *ConfigWatcher {
+	return &ConfigWatcher{load: load, config: config}
+}
+
+//Config returns the config in use
+func (w *ConfigWatcher) Config() resources.UbiquityPluginConfig {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.config
+}
+
+//OnChange adds a handler of the config changes. A handler error keeps the old config, the change is retried at the next reload.
+func (w *ConfigWatcher) OnChange(handler func(oldConfig, newConfig resources.UbiquityPluginConfig) error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.handlers = append(w.handlers, handler)
+}
+
+//Run reloads the config every interval until stopCh is closed
+func (w *ConfigWatcher) Run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			w.Reload()
+		}
+	}
+}
+
+//Reload loads the config and swaps it in if it changed, it tells whether it did.
+//An invalid config is logged and ignored, the config in use stays.
+func (w *ConfigWatcher) Reload() (bool, error) {
+	logger := logs.GetLogger()
+	newConfig, err := w.load()
+	if err != nil {
+		return false, logger.ErrorRet(err, "failed to reload the config, keeping the current one")
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	oldConfig := w.config
+	changes := ConfigChanges(oldConfig, newConfig)
+	if len(changes) == 0 {
+		return false, nil
+	}
+	for _, handler := range w.handlers {
+		if err := handler(oldConfig, newConfig); err != nil {
+			return false, logger.ErrorRet(err, "failed to apply the config change, keeping the current config", logs.Args{{"changes", changes}})
+		}
+	}
+	w.config = newConfig
+	logger.Info("config reloaded", logs.Args{{"changes", changes}})
+	return true, nil
+}
+
+//ConfigChanges lists the fields that differ between the configs as "<field>: <old> -> <new>", the secrets are masked
+func ConfigChanges(oldConfig, newConfig resources.UbiquityPluginConfig) []string {
+	return ValueChanges(oldConfig, newConfig)
+}
+
+//ValueChanges lists the fields that differ between two values of the same type as "<field>: <old> -> <new>", the secrets are masked
+func ValueChanges(oldValue, newValue interface{}) []string {
+	return diffValues("", reflect.ValueOf(oldValue), reflect.ValueOf(newValue), nil)
+}
+
+//ConnectionChanged tells whether the remote client of oldConfig cannot serve newConfig, i.e the server, the credentials or the SSL settings changed
+func ConnectionChanged(oldConfig, newConfig resources.UbiquityPluginConfig) bool {
+	return oldConfig.UbiquityServer != newConfig.UbiquityServer ||
+		oldConfig.CredentialInfo != newConfig.CredentialInfo ||
+		oldConfig.SslConfig != newConfig.SslConfig
+}
+
+//ChangesBesidesConnection lists the changes a new remote client does not apply, i.e besides the server, the credentials and the SSL settings
+func ChangesBesidesConnection(oldConfig, newConfig resources.UbiquityPluginConfig) []string {
+	newConfig.UbiquityServer = oldConfig.UbiquityServer
+	newConfig.CredentialInfo = oldConfig.CredentialInfo
+	newConfig.SslConfig = oldConfig.SslConfig
+	return ConfigChanges(oldConfig, newConfig)
+}
+
+//MaskSecrets returns a copy of config safe to log
+func MaskSecrets(config resources.UbiquityPluginConfig) resources.UbiquityPluginConfig {
+	config.CredentialInfo.Password = maskedSecret
+	return config
+}
+
+func diffValues(name string, oldValue, newValue reflect.Value, changes []string) []string {
+	if oldValue.Kind() == reflect.Struct {
+		for i := 0; i < oldValue.NumField(); i++ {
+			field := oldValue.Type().Field(i)
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+			fieldName := field.Name
+			if name != "" {
+				fieldName = name + "." + field.Name
+			}
+			if secretConfigFields[field.Name] {
+				if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+					changes = append(changes, fmt.Sprintf("%s: %s -> %s", fieldName, maskedSecret, maskedSecret))
+				}
+				continue
+			}
+			changes = diffValues(fieldName, oldValue.Field(i), newValue.Field(i), changes)
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+		changes = append(changes, fmt.Sprintf("%s: %v -> %v", name, oldValue.Interface(), newValue.Interface()))
+	}
+	return changes
+}
@@ -0,0 +1,153 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("ConfigWatcher", func() {
+	var (
+		config        resources.UbiquityPluginConfig
+		loadedConfig  resources.UbiquityPluginConfig
+		loadErr       error
+		configWatcher *k8sutils.ConfigWatcher
+	)
+	BeforeEach(func() {
+		config = resources.UbiquityPluginConfig{
+			Backends:       []string{resources.SCBE},
+			LogLevel:       "info",
+			UbiquityServer: resources.UbiquityServerConnectionInfo{Address: "ubiquity", Port: 9999},
+			CredentialInfo: resources.CredentialInfo{UserName: "admin", Password: "secret"},
+		}
+		loadedConfig = config
+		loadErr = nil
+		configWatcher = k8sutils.NewConfigWatcher(func() (resources.UbiquityPluginConfig, error) {
+			return loadedConfig, loadErr
+		}, config)
+	})
+
+	It("keeps the config when it did not change", func() {
+		handled := false
+		configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+			handled = true
+			return nil
+		})
+		changed, err := configWatcher.Reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(handled).To(BeFalse())
+	})
+	It("swaps in the changed config after the handlers", func() {
+		loadedConfig.LogLevel = "debug"
+		var handledOld, handledNew resources.UbiquityPluginConfig
+		configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+			handledOld, handledNew = oldConfig, newConfig
+			return nil
+		})
+		changed, err := configWatcher.Reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(handledOld.LogLevel).To(Equal("info"))
+		Expect(handledNew.LogLevel).To(Equal("debug"))
+		Expect(configWatcher.Config().LogLevel).To(Equal("debug"))
+	})
+	It("keeps the config when a handler fails, and retries at the next reload", func() {
+		loadedConfig.CredentialInfo.Password = "rotated"
+		handlerErr := fmt.Errorf("cannot create the remote client")
+		configWatcher.OnChange(func(oldConfig, newConfig resources.UbiquityPluginConfig) error {
+			return handlerErr
+		})
+		changed, err := configWatcher.Reload()
+		Expect(err).To(Equal(handlerErr))
+		Expect(changed).To(BeFalse())
+		Expect(configWatcher.Config().CredentialInfo.Password).To(Equal("secret"))
+
+		handlerErr = nil
+		changed, err = configWatcher.Reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(configWatcher.Config().CredentialInfo.Password).To(Equal("rotated"))
+	})
+	It("keeps the config when it fails to load", func() {
+		loadedConfig.LogLevel = "debug"
+		loadErr = fmt.Errorf("invalid config")
+		changed, err := configWatcher.Reload()
+		Expect(err).To(Equal(loadErr))
+		Expect(changed).To(BeFalse())
+		Expect(configWatcher.Config().LogLevel).To(Equal("info"))
+	})
+	It("stops reloading when stopped", func() {
+		stopCh := make(chan struct{})
+		close(stopCh)
+		configWatcher.Run(1, stopCh)
+	})
+})
+
+var _ = Describe("ConfigChanges", func() {
+	config := resources.UbiquityPluginConfig{
+		Backends:       []string{resources.SCBE},
+		UbiquityServer: resources.UbiquityServerConnectionInfo{Address: "ubiquity", Port: 9999},
+		CredentialInfo: resources.CredentialInfo{UserName: "admin", Password: "secret"},
+	}
+
+	It("lists the changed fields with the secrets masked", func() {
+		newConfig := config
+		newConfig.UbiquityServer.Port = 9998
+		newConfig.CredentialInfo.Password = "rotated"
+		changes := k8sutils.ConfigChanges(config, newConfig)
+		Expect(changes).To(ConsistOf("UbiquityServer.Port: 9999 -> 9998", "CredentialInfo.Password: **** -> ****"))
+		Expect(fmt.Sprint(changes)).ToNot(ContainSubstring("secret"))
+		Expect(fmt.Sprint(changes)).ToNot(ContainSubstring("rotated"))
+	})
+	It("lists no change of equal configs", func() {
+		Expect(k8sutils.ConfigChanges(config, config)).To(BeEmpty())
+	})
+	It("masks the password of the config to log", func() {
+		Expect(k8sutils.MaskSecrets(config).CredentialInfo).To(Equal(resources.CredentialInfo{UserName: "admin", Password: "****"}))
+		Expect(config.CredentialInfo.Password).To(Equal("secret"))
+	})
+	It("tells the changes the remote client cannot serve", func() {
+		newConfig := config
+		newConfig.LogLevel = "debug"
+		Expect(k8sutils.ConnectionChanged(config, newConfig)).To(BeFalse())
+		newConfig.CredentialInfo.Password = "rotated"
+		Expect(k8sutils.ConnectionChanged(config, newConfig)).To(BeTrue())
+		newConfig = config
+		newConfig.SslConfig.SslMode = resources.SslModeRequire
+		Expect(k8sutils.ConnectionChanged(config, newConfig)).To(BeTrue())
+	})
+	It("lists the changes besides the connection", func() {
+		newConfig := config
+		newConfig.CredentialInfo.Password = "rotated"
+		newConfig.UbiquityServer.Port = 9998
+		Expect(k8sutils.ChangesBesidesConnection(config, newConfig)).To(BeEmpty())
+		newConfig.LogLevel = "debug"
+		Expect(k8sutils.ChangesBesidesConnection(config, newConfig)).To(ConsistOf("LogLevel:  -> debug"))
+	})
+	It("lists the changed fields of any struct", func() {
+		oldValue := k8sresources.FlexConfig{WaitForAttach: k8sresources.WaitForAttachConfig{TimeoutSeconds: 60}}
+		newValue := k8sresources.FlexConfig{WaitForAttach: k8sresources.WaitForAttachConfig{TimeoutSeconds: 120}}
+		Expect(k8sutils.ValueChanges(oldValue, newValue)).To(ConsistOf("WaitForAttach.TimeoutSeconds: 60 -> 120"))
+	})
+})
@@ -0,0 +1,61 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+)
+
+var storageFailureReasons = []struct {
+	reason  string
+	matches func(message string) bool
+}{
+	{k8sresources.EventReasonBackendUnreachable, func(message string) bool {
+		return ContainsAny(message, "connection refused", "no such host", "i/o timeout", "no route to host", "network is unreachable", "connection reset", "server is unavailable")
+	}},
+	{k8sresources.EventReasonMultipathTimeout, func(message string) bool {
+		return strings.Contains(message, "multipath") && ContainsAny(message, "timeout", "timed out")
+	}},
+	{k8sresources.EventReasonLunNotFound, func(message string) bool {
+		return ContainsAny(message, "lun", "wwn") && ContainsAny(message, "not found", "did not appear", "does not exist", "no such device")
+	}},
+	{k8sresources.EventReasonFilesetNotLinked, func(message string) bool {
+		return strings.Contains(message, "not linked")
+	}},
+}
+
+//ClassifyStorageFailure returns the Event reason matching the error message of a storage operation, defaultReason when none matches
+func ClassifyStorageFailure(message string, defaultReason string) string {
+	message = strings.ToLower(message)
+	for _, failureReason := range storageFailureReasons {
+		if failureReason.matches(message) {
+			return failureReason.reason
+		}
+	}
+	return defaultReason
+}
+
+//ContainsAny tells whether message contains one of the substrings
+func ContainsAny(message string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(message, substring) {
+			return true
+		}
+	}
+	return false
+}
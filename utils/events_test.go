@@ -0,0 +1,41 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+)
+
+var _ = Describe("ClassifyStorageFailure", func() {
+	It("classifies the known storage failures", func() {
+		failures := map[string]string{
+			"Post https://ubiquity:9999/ubiquity_storage/volumes: dial tcp 10.0.0.1:9999: getsockopt: connection refused":    k8sresources.EventReasonBackendUnreachable,
+			"Timeout waiting for multipath device of volume [pv1]":                                                           k8sresources.EventReasonMultipathTimeout,
+			"Timeout waiting for volume [pv1] to be attached, the LUN with WWN [6005] did not appear on the node after 1m0s": k8sresources.EventReasonLunNotFound,
+			"fileset not linked": k8sresources.EventReasonFilesetNotLinked,
+		}
+		for message, reason := range failures {
+			Expect(k8sutils.ClassifyStorageFailure(message, "FailedMount")).To(Equal(reason), message)
+		}
+	})
+	It("returns the default reason of unknown failures", func() {
+		Expect(k8sutils.ClassifyStorageFailure("exit status 32", "FailedMount")).To(Equal("FailedMount"))
+	})
+})
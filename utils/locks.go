@@ -0,0 +1,111 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+)
+
+const lockFilePollInterval = 100 * time.Millisecond
+
+//KeyedLocker serializes the operations on the same key (a volume name, a WWN) while operations on different keys run in parallel.
+//The goroutines of a process wait in memory, the processes (e.g the flex call-outs kubelet runs) on a lockfile per key in dir.
+type KeyedLocker struct {
+	dir    string
+	prefix string
+	lock   sync.Mutex
+	keys   map[string]*keyLock
+}
+
+type keyLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+//NewKeyedLocker returns a locker whose lockfiles are <dir>/<prefix>.<key>.lock, dir must be an absolute path
+func NewKeyedLocker(dir string, prefix string) *KeyedLocker {
+	return &KeyedLocker{dir: dir, prefix: prefix, keys: make(map[string]*keyLock)}
+}
+
+//Lock waits up to timeout for the key and returns the function that releases it.
+//A holder that never releases (e.g a hung process) fails the waiters with a timeout error instead of blocking them forever.
+func (l *KeyedLocker) Lock(key string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	kl := l.ref(key)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case kl.sem <- struct{}{}:
+	case <-timer.C:
+		l.unref(key)
+		return nil, fmt.Errorf("Timeout waiting %s for the lock of [%s]", timeout, key)
+	}
+
+	flock, err := lockfile.New(filepath.Join(l.dir, l.prefix+"."+strings.Replace(key, "/", "~", -1)+".lock"))
+	if err != nil {
+		<-kl.sem
+		l.unref(key)
+		return nil, err
+	}
+	for {
+		err = flock.TryLock()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			<-kl.sem
+			l.unref(key)
+			return nil, fmt.Errorf("Timeout waiting %s for the lockfile of [%s]: %v", timeout, key, err)
+		}
+		time.Sleep(lockFilePollInterval)
+	}
+
+	return func() {
+		flock.Unlock()
+		<-kl.sem
+		l.unref(key)
+	}, nil
+}
+
+func (l *KeyedLocker) ref(key string) *keyLock {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	kl, ok := l.keys[key]
+	if !ok {
+		kl = &keyLock{sem: make(chan struct{}, 1)}
+		l.keys[key] = kl
+	}
+	kl.refs++
+	return kl
+}
+
+func (l *KeyedLocker) unref(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	kl := l.keys[key]
+	kl.refs--
+	if kl.refs == 0 {
+		delete(l.keys, key)
+	}
+}
@@ -0,0 +1,79 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+)
+
+var _ = Describe("KeyedLocker", func() {
+
+	var locker *k8sutils.KeyedLocker
+	BeforeEach(func() {
+		os.MkdirAll("/tmp/test/locks", 0777)
+		locker = k8sutils.NewKeyedLocker("/tmp/test/locks", "test")
+	})
+
+	It("does not block the lock of another key", func() {
+		unlock1, err := locker.Lock("pv1", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock1()
+
+		unlock2, err := locker.Lock("pv2", 100*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		unlock2()
+	})
+	It("fails with a timeout while the key is locked", func() {
+		unlock, err := locker.Lock("pv1", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock()
+
+		_, err = locker.Lock("pv1", 100*time.Millisecond)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("pv1"))
+	})
+	It("hands the key over once it is released", func() {
+		unlock, err := locker.Lock("pv1", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+
+		locked := make(chan error)
+		go func() {
+			unlock2, err := locker.Lock("pv1", time.Second)
+			if err == nil {
+				unlock2()
+			}
+			locked <- err
+		}()
+		Consistently(locked, 100*time.Millisecond).ShouldNot(Receive())
+		unlock()
+		Eventually(locked).Should(Receive(BeNil()))
+	})
+	It("is held between lockers of different processes through the lockfile", func() {
+		unlock, err := locker.Lock("pv1", time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock()
+
+		_, err = os.Stat("/tmp/test/locks/test.pv1.lock")
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
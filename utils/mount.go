@@ -0,0 +1,167 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ubiquityutils "github.com/IBM/ubiquity/utils"
+)
+
+const procMountsPath = "/proc/mounts"
+const procMountInfoPath = "/proc/self/mountinfo"
+
+//BindMount bind mounts source on target, target must already exist
+func BindMount(exec ubiquityutils.Executor, source string, target string, readOnly bool) error {
+	args := []string{"--bind", source, target}
+	if output, err := exec.Execute("mount", args); err != nil {
+		return fmt.Errorf("Failed to bind mount [%s] on [%s]: %s, Error: %v", source, target, string(output), err)
+	}
+	if readOnly {
+		// the ro flag is ignored on the initial bind, it takes effect only on remount
+		args = []string{"-o", "remount,bind,ro", target}
+		if output, err := exec.Execute("mount", args); err != nil {
+			return fmt.Errorf("Failed to remount [%s] as read only: %s, Error: %v", target, string(output), err)
+		}
+	}
+	return nil
+}
+
+//Unmount unmounts the given mountpoint
+func Unmount(exec ubiquityutils.Executor, target string) error {
+	if output, err := exec.Execute("umount", []string{target}); err != nil {
+		return fmt.Errorf("Failed to unmount [%s]: %s, Error: %v", target, string(output), err)
+	}
+	return nil
+}
+
+//IsMountPoint checks in /proc/mounts whether the given path is a mountpoint
+func IsMountPoint(path string) (bool, error) {
+	mountpoints, err := listMountPoints()
+	if err != nil {
+		return false, err
+	}
+	_, ok := mountpoints[filepath.Clean(path)]
+	return ok, nil
+}
+
+//ListMountPoints returns the mountpoints of the node mapped to their source device
+func ListMountPoints() (map[string]string, error) {
+	return listMountPoints()
+}
+
+//ProcMountTable reads the mounts of the node from /proc
+type ProcMountTable struct{}
+
+func (ProcMountTable) IsMountPoint(path string) (bool, error) {
+	return IsMountPoint(path)
+}
+
+func (ProcMountTable) GetMountRefs(path string) ([]string, error) {
+	return GetMountRefs(path)
+}
+
+func (ProcMountTable) ListMountPoints() (map[string]string, error) {
+	return ListMountPoints()
+}
+
+//GetMountRefs returns the other mountpoints of the same device and root as the given mountpoint, i.e. its bind mounts
+func GetMountRefs(path string) ([]string, error) {
+	file, err := os.Open(procMountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// id parent major:minor root mountpoint options ... - fstype source super-options
+	type mountInfo struct{ device, root, mountpoint string }
+	var infos []mountInfo
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		infos = append(infos, mountInfo{device: fields[2], root: unescapeMountPath(fields[3]), mountpoint: unescapeMountPath(fields[4])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	path = filepath.Clean(path)
+	var target *mountInfo
+	for i := range infos {
+		if infos[i].mountpoint == path {
+			target = &infos[i]
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("[%s] is not a mountpoint", path)
+	}
+
+	var refs []string
+	for _, info := range infos {
+		if info.mountpoint != path && info.device == target.device && info.root == target.root {
+			refs = append(refs, info.mountpoint)
+		}
+	}
+	return refs, nil
+}
+
+func listMountPoints() (map[string]string, error) {
+	file, err := os.Open(procMountsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// device mountpoint fstype options dump pass
+	mountpoints := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		mountpoints[unescapeMountPath(fields[1])] = fields[0]
+	}
+	return mountpoints, scanner.Err()
+}
+
+//unescapeMountPath decodes the octal escapes (e.g \040 for space) the kernel uses in /proc/mounts
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+	var unescaped []byte
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			var c byte
+			if _, err := fmt.Sscanf(path[i+1:i+4], "%03o", &c); err == nil {
+				unescaped = append(unescaped, c)
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, path[i])
+	}
+	return string(unescaped)
+}
@@ -0,0 +1,167 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+)
+
+type parameterSchema struct {
+	integer bool
+	// the accepted values, any value when empty
+	values []string
+	// parses the value, when it is not a plain string
+	parse func(value string) error
+}
+
+func (s parameterSchema) check(key string, value string) string {
+	if s.integer {
+		if number, err := strconv.ParseInt(value, 10, 64); err != nil || number < 0 {
+			return fmt.Sprintf("parameter %q must be a non negative integer, got %q", key, value)
+		}
+	}
+	if len(s.values) > 0 && !containsString(s.values, value) {
+		return fmt.Sprintf("parameter %q must be one of %s, got %q", key, strings.Join(s.values, ", "), value)
+	}
+	if s.parse != nil {
+		if err := s.parse(value); err != nil {
+			return fmt.Sprintf("parameter %q is invalid: %v", key, err)
+		}
+	}
+	return ""
+}
+
+// The parameters of all the backends, handled by the provisioner and the flex driver
+var commonParameters = map[string]parameterSchema{
+	"backend":                             {},
+	k8sresources.OptionNamePodMountLayout: {values: []string{k8sresources.PodMountLayoutSymlink, k8sresources.PodMountLayoutBind}},
+	k8sresources.ParameterSecretName:      {},
+	k8sresources.ParameterSecretNamespace: {},
+	k8sresources.ParameterAllowedTopologies: {parse: func(value string) error {
+		_, err := ParseAllowedTopologies(value)
+		return err
+	}},
+}
+
+var spectrumScaleParameters = map[string]parameterSchema{
+	"filesystem":  {},
+	"type":        {values: []string{"fileset", "lightweight"}},
+	"fileset":     {},
+	"directory":   {},
+	"quota":       {},
+	"uid":         {integer: true},
+	"gid":         {integer: true},
+	"inode-limit": {integer: true},
+}
+
+// The storage class parameters each backend of the ubiquity server accepts as volume options
+var backendParameters = map[string]map[string]parameterSchema{
+	resources.SCBE: {
+		"profile": {},
+		"fstype":  {values: []string{"ext4", "xfs"}},
+		"size":    {integer: true},
+	},
+	resources.SpectrumScale:    spectrumScaleParameters,
+	resources.SpectrumScaleNFS: spectrumScaleParameters,
+	resources.SoftlayerNFS: {
+		"size": {integer: true},
+	},
+}
+
+//ValidateStorageClassParameters checks the storage class parameters against the schema of their backend, the error tells all the invalid parameters at once
+func ValidateStorageClassParameters(parameters map[string]string) error {
+	backend, ok := parameters["backend"]
+	if !ok {
+		return fmt.Errorf("backend is not specified")
+	}
+	schema, ok := backendParameters[backend]
+	if !ok {
+		return fmt.Errorf("backend %q is not supported, expected one of %s", backend, strings.Join(supportedBackends(), ", "))
+	}
+
+	var keys []string
+	for key := range parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var problems []string
+	for _, key := range keys {
+		parameter, ok := commonParameters[key]
+		if !ok {
+			parameter, ok = schema[key]
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown parameter %q for backend %s, expected one of %s", key, backend, strings.Join(supportedParameters(schema), ", ")))
+			continue
+		}
+		if problem := parameter.check(key, parameters[key]); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// The parameters the provisioner and the flex driver handle by themselves
+var kubernetesParameters = []string{
+	k8sresources.OptionNamePodMountLayout,
+	k8sresources.ParameterSecretName,
+	k8sresources.ParameterSecretNamespace,
+	k8sresources.ParameterAllowedTopologies,
+}
+
+//IsBackendParameter tells whether the storage class parameter is a volume option for the ubiquity server, rather than one the provisioner or the flex driver handles
+func IsBackendParameter(key string) bool {
+	return !containsString(kubernetesParameters, key)
+}
+
+func supportedBackends() []string {
+	var backends []string
+	for backend := range backendParameters {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	return backends
+}
+
+func supportedParameters(schema map[string]parameterSchema) []string {
+	var keys []string
+	for key := range commonParameters {
+		keys = append(keys, key)
+	}
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("ValidateStorageClassParameters", func() {
+	It("accepts the parameters of each backend", func() {
+		Expect(k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SCBE, "profile": "gold", "fstype": "xfs"})).To(Succeed())
+		Expect(k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SpectrumScale, "filesystem": "gold", "type": "lightweight", "uid": "1000", "gid": "1000", "inode-limit": "1024"})).To(Succeed())
+		Expect(k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SpectrumScaleNFS, "filesystem": "gold", "type": "fileset", "podMountLayout": "bind"})).To(Succeed())
+		Expect(k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SoftlayerNFS})).To(Succeed())
+		Expect(k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SCBE, "ubiquity.ibm.com/secret-name": "scbe-credentials", "ubiquity.ibm.com/secret-namespace": "${pvc.namespace}"})).To(Succeed())
+	})
+	It("fails when the backend is missing or unknown", func() {
+		Expect(k8sutils.ValidateStorageClassParameters(map[string]string{"profile": "gold"})).To(MatchError("backend is not specified"))
+		err := k8sutils.ValidateStorageClassParameters(map[string]string{"backend": "scbee"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`backend "scbee" is not supported`))
+	})
+	It("fails on the unknown parameters", func() {
+		err := k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SpectrumScale, "filesytem": "gold"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`unknown parameter "filesytem"`))
+		Expect(err.Error()).To(ContainSubstring("filesystem"))
+	})
+	It("fails on the values of a bad type or not in the accepted ones", func() {
+		err := k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SCBE, "fstype": "ntfs", "size": "big"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`parameter "fstype" must be one of ext4, xfs, got "ntfs"`))
+		Expect(err.Error()).To(ContainSubstring(`parameter "size" must be a non negative integer, got "big"`))
+		err = k8sutils.ValidateStorageClassParameters(map[string]string{"backend": resources.SCBE, "ubiquity.ibm.com/allowed-topologies": "zone=z1"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(`parameter "ubiquity.ibm.com/allowed-topologies" is invalid`))
+	})
+})
+
+var _ = Describe("IsBackendParameter", func() {
+	It("tells the volume options of the ubiquity server from the parameters of the provisioner and the flex driver", func() {
+		Expect(k8sutils.IsBackendParameter("profile")).To(BeTrue())
+		Expect(k8sutils.IsBackendParameter("backend")).To(BeTrue())
+		Expect(k8sutils.IsBackendParameter("podMountLayout")).To(BeFalse())
+		Expect(k8sutils.IsBackendParameter("ubiquity.ibm.com/secret-name")).To(BeFalse())
+		Expect(k8sutils.IsBackendParameter("ubiquity.ibm.com/secret-namespace")).To(BeFalse())
+	})
+})
@@ -0,0 +1,82 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"sync"
+
+	"github.com/IBM/ubiquity/resources"
+)
+
+//ReloadableStorageClient forwards the calls to the client in use, which SetClient swaps when the config changes.
+//A call in flight keeps the client it started with.
+type ReloadableStorageClient struct {
+	OptionalStorageCalls
+	lock   sync.RWMutex
+	client resources.StorageClient
+}
+
+//NewReloadableStorageClient allows to instantiate a reloadable client, client being the one in use
+func NewReloadableStorageClient(client resources.StorageClient) *ReloadableStorageClient {
+	c := &ReloadableStorageClient{client: client}
+	c.OptionalStorageCalls = OptionalStorageCalls{Client: c.current}
+	return c
+}
+
+//SetClient swaps in the client of the next calls
+func (c *ReloadableStorageClient) SetClient(client resources.StorageClient) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.client = client
+}
+
+func (c *ReloadableStorageClient) current() resources.StorageClient {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.client
+}
+
+func (c *ReloadableStorageClient) Activate(activateRequest resources.ActivateRequest) error {
+	return c.current().Activate(activateRequest)
+}
+
+func (c *ReloadableStorageClient) CreateVolume(createVolumeRequest resources.CreateVolumeRequest) error {
+	return c.current().CreateVolume(createVolumeRequest)
+}
+
+func (c *ReloadableStorageClient) RemoveVolume(removeVolumeRequest resources.RemoveVolumeRequest) error {
+	return c.current().RemoveVolume(removeVolumeRequest)
+}
+
+func (c *ReloadableStorageClient) ListVolumes(listVolumeRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	return c.current().ListVolumes(listVolumeRequest)
+}
+
+func (c *ReloadableStorageClient) GetVolume(getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	return c.current().GetVolume(getVolumeRequest)
+}
+
+func (c *ReloadableStorageClient) GetVolumeConfig(getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	return c.current().GetVolumeConfig(getVolumeConfigRequest)
+}
+
+func (c *ReloadableStorageClient) Attach(attachRequest resources.AttachRequest) (string, error) {
+	return c.current().Attach(attachRequest)
+}
+
+func (c *ReloadableStorageClient) Detach(detachRequest resources.DetachRequest) error {
+	return c.current().Detach(detachRequest)
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("ReloadableStorageClient", func() {
+	var (
+		fakeClient     *fakes.FakeStorageClient
+		reloadedClient *fakes.FakeStorageClient
+		client         *k8sutils.ReloadableStorageClient
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		reloadedClient = new(fakes.FakeStorageClient)
+		client = k8sutils.NewReloadableStorageClient(fakeClient)
+	})
+
+	It("forwards the calls to the client set last", func() {
+		Expect(client.RemoveVolume(resources.RemoveVolumeRequest{Name: "vol1"})).To(Succeed())
+		client.SetClient(reloadedClient)
+		Expect(client.RemoveVolume(resources.RemoveVolumeRequest{Name: "vol2"})).To(Succeed())
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(1))
+		Expect(fakeClient.RemoveVolumeArgsForCall(0).Name).To(Equal("vol1"))
+		Expect(reloadedClient.RemoveVolumeCallCount()).To(Equal(1))
+		Expect(reloadedClient.RemoveVolumeArgsForCall(0).Name).To(Equal("vol2"))
+	})
+	It("fails the optional calls as not supported through the clients it wraps", func() {
+		client.SetClient(k8sutils.NewReloadableStorageClient(reloadedClient))
+		err := client.CloneVolume(k8sresources.CloneVolumeRequest{Name: "vol2", SourceName: "vol1"})
+		Expect(k8sutils.IsNotSupported(err)).To(BeTrue())
+	})
+	It("tells the optional calls cannot succeed through the clients it wraps", func() {
+		client.SetClient(k8sutils.NewReloadableStorageClient(reloadedClient))
+		Expect(k8sutils.SupportsCloning(client)).To(BeFalse())
+		Expect(k8sutils.SupportsCloning(reloadedClient)).To(BeFalse())
+		Expect(k8sutils.SupportsSnapshots(client)).To(BeFalse())
+	})
+	It("fails the optional calls the client does not support", func() {
+		err := client.CreateSnapshot(k8sresources.CreateSnapshotRequest{Name: "snap1", VolumeName: "vol1"})
+		Expect(err).To(HaveOccurred())
+		Expect(k8sutils.IsNotSupported(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("does not support"))
+	})
+})
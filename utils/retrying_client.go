@@ -0,0 +1,266 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	"github.com/IBM/ubiquity/utils/logs"
+)
+
+//RetryingStorageClient forwards the calls to client, retrying those failing as transient with a jittered exponential backoff, see ClientRetryConfig.
+//A circuit breaker fails the calls fast while the ubiquity server looks down, rather than letting every call-out wait for its own retries.
+//The errors are classified by errorCode (i.e controller.ErrorCode), only ErrorCodeTransient is retried since a timed out call may have been done by the server.
+//The calls changing the backend are retried only when the connection failed before the request was sent, the reads on any transient failure.
+type RetryingStorageClient struct {
+	OptionalStorageCalls
+	client           resources.StorageClient
+	errorCode        func(err error) string
+	maxRetries       int
+	initialBackoff   time.Duration
+	maxBackoff       time.Duration
+	callTimeout      time.Duration
+	breakerThreshold int
+	breakerOpen      time.Duration
+
+	lock sync.Mutex
+	// the consecutive transient or timed out calls, the circuit is open from breakerThreshold on
+	failures    int
+	lastFailure error
+	openUntil   time.Time
+}
+
+//NewRetryingStorageClient allows to instantiate a retrying client, the zero values of config keep the defaults
+func NewRetryingStorageClient(client resources.StorageClient, config k8sresources.ClientRetryConfig, errorCode func(err error) string) *RetryingStorageClient {
+	maxRetries := config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = k8sresources.ClientRetryDefaultMaxRetries
+	}
+	initialBackoff := config.InitialBackoffMilliseconds
+	if initialBackoff <= 0 {
+		initialBackoff = k8sresources.ClientRetryDefaultInitialBackoffMilliseconds
+	}
+	maxBackoff := config.MaxBackoffMilliseconds
+	if maxBackoff <= 0 {
+		maxBackoff = k8sresources.ClientRetryDefaultMaxBackoffMilliseconds
+	}
+	if maxBackoff < initialBackoff {
+		maxBackoff = initialBackoff
+	}
+	breakerThreshold := config.CircuitBreakerThreshold
+	if breakerThreshold == 0 {
+		breakerThreshold = k8sresources.ClientRetryDefaultCircuitBreakerThreshold
+	}
+	breakerOpen := config.CircuitBreakerOpenMilliseconds
+	if breakerOpen <= 0 {
+		breakerOpen = k8sresources.ClientRetryDefaultCircuitBreakerOpenMilliseconds
+	}
+	c := &RetryingStorageClient{
+		client:           client,
+		errorCode:        errorCode,
+		maxRetries:       maxRetries,
+		initialBackoff:   time.Duration(initialBackoff) * time.Millisecond,
+		maxBackoff:       time.Duration(maxBackoff) * time.Millisecond,
+		callTimeout:      time.Duration(config.CallTimeoutMilliseconds) * time.Millisecond,
+		breakerThreshold: breakerThreshold,
+		breakerOpen:      time.Duration(breakerOpen) * time.Millisecond,
+	}
+	c.OptionalStorageCalls = OptionalStorageCalls{
+		Client: func() resources.StorageClient { return client },
+		Call: func(name string, call func() error) error {
+			_, err := c.call(name, false, func() (interface{}, error) {
+				return nil, call()
+			})
+			return err
+		},
+	}
+	return c
+}
+
+// call runs the call until it succeeds, fails as non transient, runs out of retries or the circuit opens.
+// A call that is not replayable is retried only when its request did not reach the server.
+func (c *RetryingStorageClient) call(name string, replayable bool, call func() (interface{}, error)) (interface{}, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.allow(); err != nil {
+			return nil, err
+		}
+		value, err := c.callWithTimeout(name, call)
+		code := c.record(err)
+		if err == nil || code != k8sresources.ErrorCodeTransient || !replayable && !isNotSent(err) || attempt >= c.maxRetries {
+			return value, err
+		}
+		backoff := c.backoff(attempt)
+		logs.GetLogger().Info("retrying the ubiquity call failing as transient", logs.Args{{"call", name}, {"attempt", attempt + 1}, {"backoff", backoff}, {"error", err}})
+		time.Sleep(backoff)
+	}
+}
+
+// isNotSent tells if err is a failure to connect, the ones of a request sent already (e.g "i/o timeout", "connection reset") may have been done by the server
+func isNotSent(err error) bool {
+	return ContainsAny(strings.ToLower(err.Error()), "connection refused", "no such host", "no route to host")
+}
+
+// callWithTimeout abandons the call after callTimeout, its goroutine ends whenever the ubiquity client returns.
+// The ubiquity client cannot be cancelled, a timed out write may still be done by the server, so the timed out calls are not retried.
+func (c *RetryingStorageClient) callWithTimeout(name string, call func() (interface{}, error)) (interface{}, error) {
+	if c.callTimeout <= 0 {
+		return call()
+	}
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := call()
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-time.After(c.callTimeout):
+		return nil, fmt.Errorf("the ubiquity %s call timed out after %s", name, c.callTimeout)
+	}
+}
+
+// allow fails while the circuit is open, once it has been open for breakerOpen it lets a single call try the server again
+func (c *RetryingStorageClient) allow() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.breakerThreshold < 0 || c.failures < c.breakerThreshold {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(c.openUntil) {
+		return fmt.Errorf("the ubiquity server is unavailable, failing fast for %s after %d consecutive failures, the last one: %v", c.openUntil.Sub(now), c.failures, c.lastFailure)
+	}
+	// the other calls keep failing fast until this one tells whether the server is back
+	c.openUntil = now.Add(c.breakerOpen)
+	return nil
+}
+
+// record counts the consecutive transient or timed out calls, opening the circuit at breakerThreshold, and returns the code of err
+func (c *RetryingStorageClient) record(err error) string {
+	code := ""
+	if err != nil {
+		code = c.errorCode(err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if code != k8sresources.ErrorCodeTransient && code != k8sresources.ErrorCodeTimeout {
+		if c.breakerThreshold > 0 && c.failures >= c.breakerThreshold {
+			logs.GetLogger().Info("the ubiquity server is back, closing the circuit breaker")
+		}
+		c.failures = 0
+		c.lastFailure = nil
+		return code
+	}
+	c.failures++
+	c.lastFailure = err
+	if c.breakerThreshold > 0 && c.failures >= c.breakerThreshold {
+		c.openUntil = time.Now().Add(c.breakerOpen)
+		if c.failures == c.breakerThreshold {
+			logs.GetLogger().Error("the ubiquity server looks down, opening the circuit breaker", logs.Args{{"failures", c.failures}, {"open", c.breakerOpen}, {"error", err}})
+		}
+	}
+	return code
+}
+
+// backoff doubles from initialBackoff up to maxBackoff, with an equal jitter spreading the retries of the call-outs failing together
+func (c *RetryingStorageClient) backoff(attempt int) time.Duration {
+	backoff := c.initialBackoff
+	for i := 0; i < attempt && backoff < c.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (c *RetryingStorageClient) Activate(activateRequest resources.ActivateRequest) error {
+	_, err := c.call("Activate", true, func() (interface{}, error) {
+		return nil, c.client.Activate(activateRequest)
+	})
+	return err
+}
+
+func (c *RetryingStorageClient) CreateVolume(createVolumeRequest resources.CreateVolumeRequest) error {
+	_, err := c.call("CreateVolume", false, func() (interface{}, error) {
+		return nil, c.client.CreateVolume(createVolumeRequest)
+	})
+	return err
+}
+
+func (c *RetryingStorageClient) RemoveVolume(removeVolumeRequest resources.RemoveVolumeRequest) error {
+	_, err := c.call("RemoveVolume", false, func() (interface{}, error) {
+		return nil, c.client.RemoveVolume(removeVolumeRequest)
+	})
+	return err
+}
+
+func (c *RetryingStorageClient) ListVolumes(listVolumeRequest resources.ListVolumesRequest) ([]resources.Volume, error) {
+	value, err := c.call("ListVolumes", true, func() (interface{}, error) {
+		return c.client.ListVolumes(listVolumeRequest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]resources.Volume), nil
+}
+
+func (c *RetryingStorageClient) GetVolume(getVolumeRequest resources.GetVolumeRequest) (resources.Volume, error) {
+	value, err := c.call("GetVolume", true, func() (interface{}, error) {
+		return c.client.GetVolume(getVolumeRequest)
+	})
+	if err != nil {
+		return resources.Volume{}, err
+	}
+	return value.(resources.Volume), nil
+}
+
+func (c *RetryingStorageClient) GetVolumeConfig(getVolumeConfigRequest resources.GetVolumeConfigRequest) (map[string]interface{}, error) {
+	value, err := c.call("GetVolumeConfig", true, func() (interface{}, error) {
+		return c.client.GetVolumeConfig(getVolumeConfigRequest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+func (c *RetryingStorageClient) Attach(attachRequest resources.AttachRequest) (string, error) {
+	value, err := c.call("Attach", false, func() (interface{}, error) {
+		return c.client.Attach(attachRequest)
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+func (c *RetryingStorageClient) Detach(detachRequest resources.DetachRequest) error {
+	_, err := c.call("Detach", false, func() (interface{}, error) {
+		return nil, c.client.Detach(detachRequest)
+	})
+	return err
+}
@@ -0,0 +1,138 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ctl "github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("RetryingStorageClient", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		config     k8sresources.ClientRetryConfig
+		client     *k8sutils.RetryingStorageClient
+	)
+	connectionRefused := fmt.Errorf("dial tcp 10.0.0.1:9999: getsockopt: connection refused")
+
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		config = k8sresources.ClientRetryConfig{MaxRetries: 2, InitialBackoffMilliseconds: 1, MaxBackoffMilliseconds: 2, CircuitBreakerThreshold: -1}
+	})
+	JustBeforeEach(func() {
+		client = k8sutils.NewRetryingStorageClient(fakeClient, config, ctl.ErrorCode)
+	})
+
+	It("retries the calls failing as transient", func() {
+		fakeClient.GetVolumeReturnsOnCall(0, resources.Volume{}, connectionRefused)
+		fakeClient.GetVolumeReturnsOnCall(1, resources.Volume{Name: "vol1"}, nil)
+		volume, err := client.GetVolume(resources.GetVolumeRequest{Name: "vol1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.Name).To(Equal("vol1"))
+		Expect(fakeClient.GetVolumeCallCount()).To(Equal(2))
+	})
+	It("gives up after MaxRetries", func() {
+		fakeClient.AttachReturns("", connectionRefused)
+		_, err := client.Attach(resources.AttachRequest{Name: "vol1", Host: "node1"})
+		Expect(err).To(Equal(connectionRefused))
+		Expect(fakeClient.AttachCallCount()).To(Equal(3))
+	})
+	It("does not retry the changes failing once their request was sent", func() {
+		fakeClient.CreateVolumeReturns(fmt.Errorf("read tcp 10.0.0.2:51000->10.0.0.1:9999: i/o timeout"))
+		err := client.CreateVolume(resources.CreateVolumeRequest{Name: "vol1"})
+		Expect(ctl.ErrorCode(err)).To(Equal(k8sresources.ErrorCodeTransient))
+		Expect(fakeClient.CreateVolumeCallCount()).To(Equal(1))
+	})
+	It("retries the reads failing once their request was sent", func() {
+		fakeClient.GetVolumeConfigReturnsOnCall(0, nil, fmt.Errorf("read tcp 10.0.0.2:51000->10.0.0.1:9999: read: connection reset by peer"))
+		fakeClient.GetVolumeConfigReturnsOnCall(1, map[string]interface{}{"Wwn": "WWN1"}, nil)
+		_, err := client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: "vol1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(2))
+	})
+	It("does not retry the other failures", func() {
+		fakeClient.AttachReturns("", fmt.Errorf("volume vol1 is already attached to host node2"))
+		_, err := client.Attach(resources.AttachRequest{Name: "vol1", Host: "node1"})
+		Expect(err).To(HaveOccurred())
+		Expect(fakeClient.AttachCallCount()).To(Equal(1))
+	})
+	It("fails the optional calls the client does not support", func() {
+		err := client.CreateSnapshot(k8sresources.CreateSnapshotRequest{Name: "snap1", VolumeName: "vol1"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("does not support"))
+	})
+
+	Context("with a call timeout", func() {
+		BeforeEach(func() {
+			config.CallTimeoutMilliseconds = 20
+		})
+		It("fails the calls not returning in time as timed out, without retrying them", func() {
+			fakeClient.DetachStub = func(resources.DetachRequest) error {
+				time.Sleep(200 * time.Millisecond)
+				return nil
+			}
+			err := client.Detach(resources.DetachRequest{Name: "vol1", Host: "node1"})
+			Expect(err).To(HaveOccurred())
+			Expect(ctl.ErrorCode(err)).To(Equal(k8sresources.ErrorCodeTimeout))
+			Expect(fakeClient.DetachCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("with a circuit breaker", func() {
+		BeforeEach(func() {
+			config.MaxRetries = -1
+			config.CircuitBreakerThreshold = 2
+			config.CircuitBreakerOpenMilliseconds = 50
+		})
+		It("fails fast while open and closes once a call succeeds again", func() {
+			fakeClient.GetVolumeConfigReturns(nil, connectionRefused)
+			for i := 0; i < 2; i++ {
+				_, err := client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: "vol1"})
+				Expect(err).To(Equal(connectionRefused))
+			}
+			_, err := client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: "vol1"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unavailable"))
+			Expect(ctl.ErrorCode(err)).To(Equal(k8sresources.ErrorCodeTransient))
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(2))
+
+			time.Sleep(60 * time.Millisecond)
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{"Wwn": "WWN1"}, nil)
+			for i := 0; i < 2; i++ {
+				volumeConfig, err := client.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: "vol1"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(volumeConfig).To(HaveKeyWithValue("Wwn", "WWN1"))
+			}
+			Expect(fakeClient.GetVolumeConfigCallCount()).To(Equal(4))
+		})
+		It("does not count the failures of the requests", func() {
+			fakeClient.CreateVolumeReturns(fmt.Errorf("backend xyz not found"))
+			for i := 0; i < 3; i++ {
+				Expect(client.CreateVolume(resources.CreateVolumeRequest{Name: "vol1"})).NotTo(Succeed())
+			}
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(3))
+		})
+	})
+})
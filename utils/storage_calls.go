@@ -0,0 +1,127 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"fmt"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+)
+
+//NotSupportedError is the failure of an optional call the ubiquity client does not implement
+type NotSupportedError struct {
+	Operation string
+}
+
+func (e *NotSupportedError) Error() string {
+	return fmt.Sprintf("the ubiquity client does not support %s", e.Operation)
+}
+
+//IsNotSupported tells whether err is the failure of an optional call the ubiquity client does not implement
+func IsNotSupported(err error) bool {
+	_, ok := err.(*NotSupportedError)
+	return ok
+}
+
+//OptionalStorageCalls makes the optional calls (VolumeCloner, VolumeSnapshotter) on the client Client returns, through Call when set, e.g to retry or time them.
+//The storage clients wrapping another one embed it to forward these calls, and a call the innermost client does not implement fails with a NotSupportedError.
+type OptionalStorageCalls struct {
+	Client func() resources.StorageClient
+	Call   func(name string, call func() error) error
+}
+
+//NewOptionalStorageCalls allows to make the optional calls on client
+func NewOptionalStorageCalls(client resources.StorageClient) OptionalStorageCalls {
+	return OptionalStorageCalls{Client: func() resources.StorageClient { return client }}
+}
+
+//Unwrap returns the client the optional calls are made on
+func (o OptionalStorageCalls) Unwrap() resources.StorageClient {
+	return o.Client()
+}
+
+//SupportsCloning tells whether the clone calls on client can succeed, i.e whether the innermost of the clients it wraps implements VolumeCloner
+func SupportsCloning(client resources.StorageClient) bool {
+	_, ok := innermostClient(client).(k8sresources.VolumeCloner)
+	return ok
+}
+
+//SupportsSnapshots tells whether the snapshot calls on client can succeed, i.e whether the innermost of the clients it wraps implements VolumeSnapshotter
+func SupportsSnapshots(client resources.StorageClient) bool {
+	_, ok := innermostClient(client).(k8sresources.VolumeSnapshotter)
+	return ok
+}
+
+// innermostClient returns the client the storage clients wrapping another one make their calls on in the end
+func innermostClient(client resources.StorageClient) resources.StorageClient {
+	for {
+		wrapper, ok := client.(interface {
+			Unwrap() resources.StorageClient
+		})
+		if !ok {
+			return client
+		}
+		client = wrapper.Unwrap()
+	}
+}
+
+func (o OptionalStorageCalls) call(name string, call func() error) error {
+	if o.Call == nil {
+		return call()
+	}
+	return o.Call(name, call)
+}
+
+func (o OptionalStorageCalls) CloneVolume(cloneVolumeRequest k8sresources.CloneVolumeRequest) error {
+	cloner, ok := o.Client().(k8sresources.VolumeCloner)
+	if !ok {
+		return &NotSupportedError{Operation: "volume cloning"}
+	}
+	return o.call("CloneVolume", func() error {
+		return cloner.CloneVolume(cloneVolumeRequest)
+	})
+}
+
+func (o OptionalStorageCalls) CreateSnapshot(createSnapshotRequest k8sresources.CreateSnapshotRequest) error {
+	snapshotter, ok := o.Client().(k8sresources.VolumeSnapshotter)
+	if !ok {
+		return &NotSupportedError{Operation: "volume snapshots"}
+	}
+	return o.call("CreateSnapshot", func() error {
+		return snapshotter.CreateSnapshot(createSnapshotRequest)
+	})
+}
+
+func (o OptionalStorageCalls) DeleteSnapshot(deleteSnapshotRequest k8sresources.DeleteSnapshotRequest) error {
+	snapshotter, ok := o.Client().(k8sresources.VolumeSnapshotter)
+	if !ok {
+		return &NotSupportedError{Operation: "volume snapshots"}
+	}
+	return o.call("DeleteSnapshot", func() error {
+		return snapshotter.DeleteSnapshot(deleteSnapshotRequest)
+	})
+}
+
+func (o OptionalStorageCalls) RestoreSnapshot(restoreSnapshotRequest k8sresources.RestoreSnapshotRequest) error {
+	snapshotter, ok := o.Client().(k8sresources.VolumeSnapshotter)
+	if !ok {
+		return &NotSupportedError{Operation: "volume snapshots"}
+	}
+	return o.call("RestoreSnapshot", func() error {
+		return snapshotter.RestoreSnapshot(restoreSnapshotRequest)
+	})
+}
@@ -0,0 +1,157 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+)
+
+//ParseAllowedTopologies parses the k8sresources.ParameterAllowedTopologies storage class parameter
+func ParseAllowedTopologies(value string) ([]k8sresources.TopologySelectorTerm, error) {
+	var terms []k8sresources.TopologySelectorTerm
+	if err := json.Unmarshal([]byte(value), &terms); err != nil {
+		return nil, fmt.Errorf("expected a JSON list of topology selector terms: %v", err)
+	}
+	for _, term := range terms {
+		if len(term.MatchLabelExpressions) == 0 {
+			return nil, fmt.Errorf("a topology selector term has no matchLabelExpressions")
+		}
+		for _, expression := range term.MatchLabelExpressions {
+			if expression.Key == "" || len(expression.Values) == 0 {
+				return nil, fmt.Errorf("a topology selector expression needs a key and values")
+			}
+		}
+	}
+	return terms, nil
+}
+
+//SelectTopology returns the first of the topologies with the storage class parameters, reachable from the node of nodeLabels and allowed by allowedTopologies.
+//nodeLabels is nil when no node was selected for the volume, allowedTopologies empty when the storage class allows any.
+//It returns nil without topologies, i.e when the storage is reachable from all the nodes.
+func SelectTopology(topologies []k8sresources.TopologyConfig, parameters map[string]string, nodeLabels map[string]string, allowedTopologies []k8sresources.TopologySelectorTerm) (*k8sresources.TopologyConfig, error) {
+	if len(topologies) == 0 {
+		return nil, nil
+	}
+	for i := range topologies {
+		topology := &topologies[i]
+		if topologyHasParameters(topology, parameters) && topologyReachableFrom(topology, nodeLabels) && topologyAllowed(topology, allowedTopologies) {
+			return topology, nil
+		}
+	}
+
+	var constraints []string
+	if backend, ok := parameters["backend"]; ok {
+		constraints = append(constraints, "of backend "+backend)
+	}
+	if nodeLabels != nil {
+		constraints = append(constraints, "reachable from the selected node")
+	}
+	if len(allowedTopologies) > 0 {
+		constraints = append(constraints, "in the allowed topologies")
+	}
+	return nil, fmt.Errorf("no configured topology %s", strings.Join(append(constraints, "matches the storage class parameters"), ", "))
+}
+
+//TopologyParameters returns the storage class parameters of the volumes created in topology, nil topology keeps them
+func TopologyParameters(parameters map[string]string, topology *k8sresources.TopologyConfig) map[string]string {
+	if topology == nil {
+		return parameters
+	}
+	merged := make(map[string]string, len(parameters)+len(topology.Parameters)+1)
+	for key, value := range parameters {
+		merged[key] = value
+	}
+	for key, value := range topology.Parameters {
+		merged[key] = value
+	}
+	if topology.Backend != "" {
+		merged["backend"] = topology.Backend
+	}
+	return merged
+}
+
+//TopologyTerms returns the node selector terms of the nodes a volume is reachable from, the labels of its topology, or else the allowed topologies.
+//It returns none when the volume is reachable from all the nodes.
+func TopologyTerms(topology *k8sresources.TopologyConfig, allowedTopologies []k8sresources.TopologySelectorTerm) []k8sresources.TopologySelectorTerm {
+	if topology == nil {
+		return allowedTopologies
+	}
+	if len(topology.Labels) == 0 {
+		return nil
+	}
+	var keys []string
+	for key := range topology.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var term k8sresources.TopologySelectorTerm
+	for _, key := range keys {
+		term.MatchLabelExpressions = append(term.MatchLabelExpressions, k8sresources.TopologySelectorLabelRequirement{Key: key, Values: []string{topology.Labels[key]}})
+	}
+	return []k8sresources.TopologySelectorTerm{term}
+}
+
+// topologyHasParameters tells whether the storage class does not ask for another backend or other parameters than the ones of the topology
+func topologyHasParameters(topology *k8sresources.TopologyConfig, parameters map[string]string) bool {
+	if backend, ok := parameters["backend"]; ok && topology.Backend != "" && backend != topology.Backend {
+		return false
+	}
+	for key, value := range topology.Parameters {
+		if classValue, ok := parameters[key]; ok && classValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func topologyReachableFrom(topology *k8sresources.TopologyConfig, nodeLabels map[string]string) bool {
+	if nodeLabels == nil {
+		return true
+	}
+	for key, value := range topology.Labels {
+		if nodeValue, ok := nodeLabels[key]; !ok || nodeValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func topologyAllowed(topology *k8sresources.TopologyConfig, allowedTopologies []k8sresources.TopologySelectorTerm) bool {
+	if len(allowedTopologies) == 0 {
+		return true
+	}
+	for _, term := range allowedTopologies {
+		if topologyMatchesTerm(topology, term) {
+			return true
+		}
+	}
+	return false
+}
+
+func topologyMatchesTerm(topology *k8sresources.TopologyConfig, term k8sresources.TopologySelectorTerm) bool {
+	for _, expression := range term.MatchLabelExpressions {
+		value, ok := topology.Labels[expression.Key]
+		if !ok || !containsString(expression.Values, value) {
+			return false
+		}
+	}
+	return true
+}
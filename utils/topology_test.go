@@ -0,0 +1,114 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package utils_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+)
+
+var _ = Describe("Topology", func() {
+	var (
+		topologies []k8sresources.TopologyConfig
+		fabricA    = map[string]string{"ubiquity.ibm.com/fabric": "a"}
+		fabricB    = map[string]string{"ubiquity.ibm.com/fabric": "b"}
+	)
+	BeforeEach(func() {
+		topologies = []k8sresources.TopologyConfig{
+			{Labels: fabricA, Backend: resources.SCBE, Parameters: map[string]string{"profile": "gold-a"}},
+			{Labels: fabricB, Backend: resources.SCBE, Parameters: map[string]string{"profile": "gold-b"}},
+			{Labels: map[string]string{"ubiquity.ibm.com/gpfs1": "mounted"}, Backend: resources.SpectrumScale, Parameters: map[string]string{"filesystem": "gpfs1"}},
+		}
+	})
+
+	Context(".SelectTopology", func() {
+		It("selects none without configured topologies", func() {
+			topology, err := k8sutils.SelectTopology(nil, map[string]string{"backend": resources.SCBE}, fabricA, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(topology).To(BeNil())
+		})
+		It("selects the topology reachable from the node", func() {
+			topology, err := k8sutils.SelectTopology(topologies, map[string]string{"backend": resources.SCBE}, fabricB, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(topology.Parameters["profile"]).To(Equal("gold-b"))
+		})
+		It("selects the backend of the node when the storage class does not tell it", func() {
+			nodeLabels := map[string]string{"ubiquity.ibm.com/gpfs1": "mounted", "kubernetes.io/hostname": "node1"}
+			topology, err := k8sutils.SelectTopology(topologies, map[string]string{}, nodeLabels, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(topology.Backend).To(Equal(resources.SpectrumScale))
+		})
+		It("selects the first allowed topology without a selected node", func() {
+			allowed := []k8sresources.TopologySelectorTerm{{MatchLabelExpressions: []k8sresources.TopologySelectorLabelRequirement{{Key: "ubiquity.ibm.com/fabric", Values: []string{"b", "c"}}}}}
+			topology, err := k8sutils.SelectTopology(topologies, map[string]string{"backend": resources.SCBE}, nil, allowed)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(topology.Labels).To(Equal(fabricB))
+		})
+		It("skips the topologies of other parameters than the storage class", func() {
+			topology, err := k8sutils.SelectTopology(topologies, map[string]string{"backend": resources.SCBE, "profile": "gold-b"}, nil, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(topology.Labels).To(Equal(fabricB))
+		})
+		It("fails when no topology is reachable from the node", func() {
+			_, err := k8sutils.SelectTopology(topologies, map[string]string{"backend": resources.SpectrumScale}, fabricA, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("of backend spectrum-scale"))
+			Expect(err.Error()).To(ContainSubstring("reachable from the selected node"))
+		})
+	})
+
+	Context(".TopologyParameters", func() {
+		It("adds the backend and the parameters of the topology", func() {
+			parameters := k8sutils.TopologyParameters(map[string]string{"fstype": "xfs"}, &topologies[0])
+			Expect(parameters).To(Equal(map[string]string{"backend": resources.SCBE, "profile": "gold-a", "fstype": "xfs"}))
+		})
+		It("keeps the parameters without a topology", func() {
+			parameters := map[string]string{"backend": resources.SCBE}
+			Expect(k8sutils.TopologyParameters(parameters, nil)).To(Equal(parameters))
+		})
+	})
+
+	Context(".TopologyTerms", func() {
+		It("restricts the volume to the labels of its topology", func() {
+			terms := k8sutils.TopologyTerms(&topologies[0], nil)
+			Expect(terms).To(Equal([]k8sresources.TopologySelectorTerm{{MatchLabelExpressions: []k8sresources.TopologySelectorLabelRequirement{{Key: "ubiquity.ibm.com/fabric", Values: []string{"a"}}}}}))
+		})
+		It("restricts the volume to the allowed topologies without a topology", func() {
+			allowed := []k8sresources.TopologySelectorTerm{{MatchLabelExpressions: []k8sresources.TopologySelectorLabelRequirement{{Key: "zone", Values: []string{"z1"}}}}}
+			Expect(k8sutils.TopologyTerms(nil, allowed)).To(Equal(allowed))
+			Expect(k8sutils.TopologyTerms(nil, nil)).To(BeEmpty())
+		})
+	})
+
+	Context(".ParseAllowedTopologies", func() {
+		It("parses a JSON list of terms", func() {
+			terms, err := k8sutils.ParseAllowedTopologies(`[{"matchLabelExpressions": [{"key": "zone", "values": ["z1", "z2"]}]}]`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(terms).To(HaveLen(1))
+			Expect(terms[0].MatchLabelExpressions[0].Values).To(Equal([]string{"z1", "z2"}))
+		})
+		It("fails on a term without values", func() {
+			_, err := k8sutils.ParseAllowedTopologies(`[{"matchLabelExpressions": [{"key": "zone"}]}]`)
+			Expect(err).To(HaveOccurred())
+			_, err = k8sutils.ParseAllowedTopologies(`zone=z1`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,111 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume
+
+import (
+	"fmt"
+	"strings"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// The PV and VolumeSnapshot annotations of the secret of their credentials, Delete reads it after the storage class may be gone
+	annSecretName      = k8sresources.ParameterSecretName
+	annSecretNamespace = k8sresources.ParameterSecretNamespace
+
+	// The namespace of the secret when the storage class does not tell it
+	defaultSecretNamespace = "${pvc.namespace}"
+)
+
+// volumeSecret is a secret of ubiquity credentials
+type volumeSecret struct {
+	name        string
+	namespace   string
+	credentials resources.CredentialInfo
+}
+
+// credentialInfo returns the credentials of the secret, none without a secret, i.e the ubiquity server uses its own
+func (s *volumeSecret) credentialInfo() resources.CredentialInfo {
+	if s == nil {
+		return resources.CredentialInfo{}
+	}
+	return s.credentials
+}
+
+// getClassSecret returns the secret the storage class parameters reference for the claim, nil when they reference none
+func (p *flexProvisioner) getClassSecret(parameters map[string]string, claim *v1.PersistentVolumeClaim) (*volumeSecret, error) {
+	name, ok := parameters[k8sresources.ParameterSecretName]
+	if !ok {
+		if _, ok := parameters[k8sresources.ParameterSecretNamespace]; ok {
+			return nil, fmt.Errorf("parameter %s requires parameter %s", k8sresources.ParameterSecretNamespace, k8sresources.ParameterSecretName)
+		}
+		return nil, nil
+	}
+	namespace, ok := parameters[k8sresources.ParameterSecretNamespace]
+	if !ok {
+		namespace = defaultSecretNamespace
+	}
+	return getSecret(p.kubeClient, expandClaimTemplate(namespace, claim), expandClaimTemplate(name, claim))
+}
+
+// getVolumeSecret returns the secret the volume was provisioned with, nil when it has none
+func (p *flexProvisioner) getVolumeSecret(volume *v1.PersistentVolume) (*volumeSecret, error) {
+	return getAnnotatedSecret(p.kubeClient, volume.Annotations)
+}
+
+// getAnnotatedSecret returns the secret of the annSecretName and annSecretNamespace annotations, nil when there are none
+func getAnnotatedSecret(client kubernetes.Interface, annotations map[string]string) (*volumeSecret, error) {
+	name, ok := annotations[annSecretName]
+	if !ok {
+		return nil, nil
+	}
+	return getSecret(client, annotations[annSecretNamespace], name)
+}
+
+func getSecret(client kubernetes.Interface, namespace string, name string) (*volumeSecret, error) {
+	if client == nil {
+		return nil, fmt.Errorf("cannot read secret %s/%s, there is no kubernetes client", namespace, name)
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the credentials secret %s/%s: %v", namespace, name, err)
+	}
+	username, ok := secret.Data[k8sresources.SecretKeyUsername]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %s key", namespace, name, k8sresources.SecretKeyUsername)
+	}
+	password, ok := secret.Data[k8sresources.SecretKeyPassword]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %s key", namespace, name, k8sresources.SecretKeyPassword)
+	}
+	return &volumeSecret{
+		name:        name,
+		namespace:   namespace,
+		credentials: resources.CredentialInfo{UserName: string(username), Password: string(password)},
+	}, nil
+}
+
+// expandClaimTemplate replaces ${pvc.name} and ${pvc.namespace} in value with the ones of the claim
+func expandClaimTemplate(value string, claim *v1.PersistentVolumeClaim) string {
+	return strings.NewReplacer("${pvc.name}", claim.Name, "${pvc.namespace}", claim.Namespace).Replace(value)
+}
@@ -0,0 +1,156 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume
+
+import (
+	"fmt"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// A PV annotation telling the volume existed on the backend before the PV, it was imported rather than provisioned
+const annImported = "ubiquity.ibm.com/imported"
+
+//ImportOptions tells which existing backend volume to import as a PV, and how
+type ImportOptions struct {
+	VolumeName string
+	// PVName is the name of the PV, it can only be VolumeName
+	PVName string
+	// Capacity is the capacity of the PV, the request of the claim by default
+	Capacity string
+	// PodMountLayout is the layout of the pods of the volume, the one of the flex config of the nodes by default
+	PodMountLayout string
+	// ReclaimPolicy is Retain by default, deleting the PV keeps the data that existed before it. With Delete the PV is annotated as provisioned by this provisioner.
+	ReclaimPolicy v1.PersistentVolumeReclaimPolicy
+	// ClaimNamespace and ClaimName bind the PV to the claim, which may not exist yet. The PV gets the access modes and the storage class of an existing claim.
+	ClaimNamespace string
+	ClaimName      string
+}
+
+//ImportVolume creates the PV of an existing backend volume, with the flex options Provision would have given it.
+//It returns the PV without creating it when dryRun is set.
+func ImportVolume(ubiquityClient resources.StorageClient, kubeClient kubernetes.Interface, options ImportOptions, dryRun bool) (*v1.PersistentVolume, error) {
+	var claim *v1.PersistentVolumeClaim
+	if options.ClaimName != "" {
+		existing, err := kubeClient.CoreV1().PersistentVolumeClaims(options.ClaimNamespace).Get(options.ClaimName, metav1.GetOptions{})
+		if err == nil {
+			claim = existing
+		} else if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("error getting claim %s/%s: %v", options.ClaimNamespace, options.ClaimName, err)
+		}
+	}
+	pv, err := NewImportedPV(ubiquityClient, options, claim)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return pv, nil
+	}
+	created, err := kubeClient.CoreV1().PersistentVolumes().Create(pv)
+	if err != nil {
+		return nil, fmt.Errorf("error creating PV %s: %v", pv.Name, err)
+	}
+	return created, nil
+}
+
+//NewImportedPV returns the PV of an existing backend volume, bound to claim when not nil
+func NewImportedPV(ubiquityClient resources.StorageClient, options ImportOptions, claim *v1.PersistentVolumeClaim) (*v1.PersistentVolume, error) {
+	if options.VolumeName == "" {
+		return nil, fmt.Errorf("the name of the volume to import is missing")
+	}
+	volume, err := ubiquityClient.GetVolume(resources.GetVolumeRequest{Name: options.VolumeName})
+	if err != nil {
+		return nil, fmt.Errorf("error getting volume %s: %v", options.VolumeName, err)
+	}
+	volumeConfig, err := ubiquityClient.GetVolumeConfig(resources.GetVolumeConfigRequest{Name: options.VolumeName})
+	if err != nil {
+		return nil, fmt.Errorf("error getting volume config details of %s: %v", options.VolumeName, err)
+	}
+
+	// the flex driver gets the volume of the unmount and detach call-outs from the name of the PV
+	if options.PVName != "" && options.PVName != options.VolumeName {
+		return nil, fmt.Errorf("the PV of volume %s cannot be named %s, it must be named after its volume", options.VolumeName, options.PVName)
+	}
+	pvName := options.VolumeName
+	reclaimPolicy := options.ReclaimPolicy
+	if reclaimPolicy == "" {
+		reclaimPolicy = v1.PersistentVolumeReclaimRetain
+	}
+	if options.PodMountLayout != "" && options.PodMountLayout != k8sresources.PodMountLayoutSymlink && options.PodMountLayout != k8sresources.PodMountLayoutBind {
+		return nil, fmt.Errorf("pod mount layout %q is invalid, expected %s or %s", options.PodMountLayout, k8sresources.PodMountLayoutSymlink, k8sresources.PodMountLayoutBind)
+	}
+
+	var capacity resource.Quantity
+	if options.Capacity != "" {
+		capacity, err = resource.ParseQuantity(options.Capacity)
+		if err != nil {
+			return nil, fmt.Errorf("capacity %q is invalid: %v", options.Capacity, err)
+		}
+	} else if claim != nil {
+		var ok bool
+		capacity, ok = claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		if !ok {
+			return nil, fmt.Errorf("the capacity is missing and claim %s/%s requests none", claim.Namespace, claim.Name)
+		}
+	} else {
+		return nil, fmt.Errorf("the capacity of volume %s is missing", options.VolumeName)
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        pvName,
+			Annotations: map[string]string{annImported: "true"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:  k8sresources.UbiquityK8sFlexVolumeDriverFullName,
+					Options: flexVolumeOptions(volume.Name, volume.Backend, options.PodMountLayout, volumeConfig),
+				},
+			},
+		},
+	}
+	if reclaimPolicy == v1.PersistentVolumeReclaimDelete {
+		// the provision controller deletes only the released PVs it provisioned
+		pv.Annotations[annDynamicallyProvisioned] = k8sresources.ProvisionerName
+		pv.Annotations[annProvisionerId] = k8sresources.UbiquityProvisionerName
+	}
+	if options.ClaimName != "" {
+		// the binder binds the claim to this PV only
+		pv.Spec.ClaimRef = &v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: options.ClaimNamespace, Name: options.ClaimName}
+	}
+	if claim != nil {
+		pv.Spec.ClaimRef.UID = claim.UID
+		if len(claim.Spec.AccessModes) > 0 {
+			pv.Spec.AccessModes = claim.Spec.AccessModes
+		}
+		// the binder binds a claim only to a PV of its storage class
+		pv.Spec.StorageClassName = getClaimClass(claim)
+	}
+	return pv, nil
+}
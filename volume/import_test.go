@@ -0,0 +1,123 @@
+/**
+ * Copyright 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume_test
+
+import (
+	"fmt"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity-k8s/volume"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("ImportVolume", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		kubeClient *k8sfake.Clientset
+		options    volume.ImportOptions
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeClient.GetVolumeReturns(resources.Volume{Name: "fileset1", Backend: resources.SpectrumScale}, nil)
+		fakeClient.GetVolumeConfigReturns(map[string]interface{}{"filesystem": "gpfs1", "fileset": "fileset1", "type": "fileset"}, nil)
+		kubeClient = k8sfake.NewSimpleClientset()
+		options = volume.ImportOptions{VolumeName: "fileset1", Capacity: "10Gi"}
+	})
+
+	It("creates a PV with the flex options of a provisioned volume", func() {
+		pv, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pv.Name).To(Equal("fileset1"))
+		Expect(pv.Spec.PersistentVolumeReclaimPolicy).To(Equal(v1.PersistentVolumeReclaimRetain))
+		Expect(pv.Spec.FlexVolume.Driver).To(Equal(k8sresources.UbiquityK8sFlexVolumeDriverFullName))
+		Expect(pv.Spec.FlexVolume.Options).To(Equal(map[string]string{
+			"volumeName": "fileset1",
+			"backend":    resources.SpectrumScale,
+			"filesystem": "gpfs1",
+			"fileset":    "fileset1",
+			"type":       "fileset",
+		}))
+		capacity := pv.Spec.Capacity[v1.ResourceStorage]
+		Expect(capacity.String()).To(Equal("10Gi"))
+
+		created, err := kubeClient.CoreV1().PersistentVolumes().Get("fileset1", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created.Spec.FlexVolume.Options["volumeName"]).To(Equal("fileset1"))
+	})
+	It("binds the PV to an existing claim, with its capacity, access modes and storage class", func() {
+		claim := newClaim("data", "5Gi", map[string]string{"volume.beta.kubernetes.io/storage-class": "gold"})
+		claim.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}
+		kubeClient = k8sfake.NewSimpleClientset(claim)
+		options.Capacity = ""
+		options.ClaimNamespace, options.ClaimName = "default", "data"
+		pv, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pv.Name).To(Equal("fileset1"))
+		Expect(pv.Spec.ClaimRef.Namespace).To(Equal("default"))
+		Expect(pv.Spec.ClaimRef.Name).To(Equal("data"))
+		Expect(pv.Spec.AccessModes).To(Equal([]v1.PersistentVolumeAccessMode{v1.ReadWriteMany}))
+		Expect(pv.Spec.StorageClassName).To(Equal("gold"))
+		capacity := pv.Spec.Capacity[v1.ResourceStorage]
+		Expect(capacity.Cmp(resource.MustParse("5Gi"))).To(Equal(0))
+	})
+	It("binds the PV to a claim created later", func() {
+		options.ClaimNamespace, options.ClaimName = "default", "data"
+		pv, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pv.Spec.ClaimRef.Name).To(Equal("data"))
+		Expect(pv.Spec.ClaimRef.UID).To(BeEmpty())
+	})
+	It("fails when the PV is not named after the volume", func() {
+		options.PVName = "pv-data"
+		_, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("named after its volume"))
+	})
+	It("annotates the PV of the Delete reclaim policy as provisioned", func() {
+		options.ReclaimPolicy = v1.PersistentVolumeReclaimDelete
+		pv, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pv.Annotations).To(HaveKeyWithValue("pv.kubernetes.io/provisioned-by", k8sresources.ProvisionerName))
+		Expect(pv.Annotations).To(HaveKeyWithValue("Provisioner_Id", k8sresources.UbiquityProvisionerName))
+	})
+	It("does not create the PV on a dry run", func() {
+		pv, err := volume.ImportVolume(fakeClient, kubeClient, options, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pv.Name).To(Equal("fileset1"))
+		_, err = kubeClient.CoreV1().PersistentVolumes().Get("fileset1", metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+	It("fails when the volume does not exist on the backend", func() {
+		fakeClient.GetVolumeReturns(resources.Volume{}, fmt.Errorf("volume not found"))
+		_, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("volume not found"))
+	})
+	It("fails without a capacity nor a claim", func() {
+		options.Capacity = ""
+		_, err := volume.ImportVolume(fakeClient, kubeClient, options, false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("capacity"))
+	})
+})
@@ -0,0 +1,168 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/IBM/ubiquity-k8s/metrics"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity/resources"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+//OrphanCollectorConfig tells how often the orphan collector looks for the backend volumes no PV references, and what it does with them
+type OrphanCollectorConfig struct {
+	Interval time.Duration
+	// Delete removes the orphans from the backend, else they are only reported
+	Delete bool
+	// GracePeriod is how long a volume must stay orphaned before it is deleted, e.g a volume whose PV is being created is orphaned for a moment
+	GracePeriod time.Duration
+	// Backends limits the collector to the volumes of these backends, all when empty
+	Backends []string
+}
+
+//OrphanCollector reports, and optionally deletes, the volumes of the ubiquity server no PV references,
+//e.g the ones left behind by a Delete that failed halfway or by a PV deleted by hand.
+//Every volume of the ubiquity server is expected to belong to the cluster, the server must not serve other clusters or docker hosts.
+type OrphanCollector struct {
+	logger         *log.Logger
+	client         kubernetes.Interface
+	ubiquityClient resources.StorageClient
+	config         OrphanCollectorConfig
+	// when each orphan was first seen orphaned, kept in memory only: a restart or a new leader starts the grace periods over
+	orphanedSince map[string]time.Time
+	now           func() time.Time
+}
+
+//NewOrphanCollector allows to instantiate an orphan collector
+func NewOrphanCollector(logger *log.Logger, client kubernetes.Interface, ubiquityClient resources.StorageClient, config OrphanCollectorConfig) *OrphanCollector {
+	return &OrphanCollector{
+		logger:         logger,
+		client:         client,
+		ubiquityClient: ubiquityClient,
+		config:         config,
+		orphanedSince:  make(map[string]time.Time),
+		now:            time.Now,
+	}
+}
+
+//Run collects the orphans every interval until stopCh is closed
+func (c *OrphanCollector) Run(stopCh <-chan struct{}) {
+	c.logger.Printf("Starting the orphan collector, every %s, deleting the orphans %t after %s", c.config.Interval, c.config.Delete, c.config.GracePeriod)
+	wait.Until(func() {
+		if _, err := c.Collect(); err != nil {
+			c.logger.Printf("Failed to collect the orphaned volumes: %v", err)
+		}
+	}, c.config.Interval, stopCh)
+}
+
+//Collect returns the names of the volumes no PV references, and deletes the ones orphaned for longer than the grace period when deletion is enabled
+func (c *OrphanCollector) Collect() ([]string, error) {
+	// the volumes are listed before the PVs, a volume provisioned meanwhile has its PV listed
+	volumes, err := c.ubiquityClient.ListVolumes(resources.ListVolumesRequest{Backends: c.config.Backends})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the volumes of the ubiquity server: %v", err)
+	}
+	referenced, err := c.referencedVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	var orphans []string
+	orphansPerBackend := make(map[string]int)
+	orphanedSince := make(map[string]time.Time)
+	for _, volume := range volumes {
+		if referenced[volume.Name] || !c.collectsBackend(volume.Backend) {
+			continue
+		}
+		orphans = append(orphans, volume.Name)
+		orphansPerBackend[volume.Backend]++
+		since, ok := c.orphanedSince[volume.Name]
+		if !ok {
+			since = now
+		}
+		orphanedSince[volume.Name] = since
+
+		if !c.config.Delete {
+			c.logger.Printf("Volume %s of backend %s is orphaned since %s, no PV references it (dry run, not deleting)", volume.Name, volume.Backend, since.Format(time.RFC3339))
+			continue
+		}
+		if now.Sub(since) < c.config.GracePeriod {
+			c.logger.Printf("Volume %s of backend %s is orphaned since %s, deleting it after the grace period of %s", volume.Name, volume.Backend, since.Format(time.RFC3339), c.config.GracePeriod)
+			continue
+		}
+		c.logger.Printf("Deleting volume %s of backend %s, orphaned since %s", volume.Name, volume.Backend, since.Format(time.RFC3339))
+		if err := c.ubiquityClient.RemoveVolume(resources.RemoveVolumeRequest{Name: volume.Name}); err != nil {
+			// retried at the next run
+			c.logger.Printf("Failed to delete orphaned volume %s: %v", volume.Name, err)
+			continue
+		}
+		delete(orphanedSince, volume.Name)
+	}
+	// a volume referenced again, or deleted, starts its grace period over
+	c.orphanedSince = orphanedSince
+
+	metrics.SetOrphanedVolumes(orphansPerBackend)
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// referencedVolumes returns the names of the volumes the ubiquity PVs reference: the ones of this provisioner (Provisioner_Id annotation),
+// the flex PVs created by hand and the PVs the external-provisioner created with the CSI driver.
+// The vendored API (release-1.8) drops Spec.CSI, a CSI PV is recognized by its provisioned-by annotation and named after its volume.
+func (c *OrphanCollector) referencedVolumes() (map[string]bool, error) {
+	pvs, err := c.client.CoreV1().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing the PVs: %v", err)
+	}
+	referenced := make(map[string]bool)
+	for _, pv := range pvs.Items {
+		ubiquityFlexVolume := pv.Spec.FlexVolume != nil && pv.Spec.FlexVolume.Driver == k8sresources.UbiquityK8sFlexVolumeDriverFullName
+		if pv.Annotations[annProvisionerId] != k8sresources.UbiquityProvisionerName &&
+			pv.Annotations[annDynamicallyProvisioned] != k8sresources.UbiquityK8sCsiDriverFullName &&
+			!ubiquityFlexVolume {
+			continue
+		}
+		volumeName := pv.Name
+		if pv.Spec.FlexVolume != nil {
+			if name, ok := pv.Spec.FlexVolume.Options["volumeName"]; ok {
+				volumeName = name
+			}
+		}
+		referenced[volumeName] = true
+	}
+	return referenced, nil
+}
+
+func (c *OrphanCollector) collectsBackend(backend string) bool {
+	if len(c.config.Backends) == 0 {
+		return true
+	}
+	for _, collected := range c.config.Backends {
+		if collected == backend {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright 2017 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume_test
+
+import (
+	"fmt"
+	"time"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity-k8s/volume"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newUbiquityPV(name string, volumeName string) *v1.PersistentVolume {
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{"Provisioner_Id": k8sresources.UbiquityProvisionerName}},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:  k8sresources.UbiquityK8sFlexVolumeDriverFullName,
+					Options: map[string]string{"volumeName": volumeName},
+				},
+			},
+		},
+	}
+}
+
+var _ = Describe("OrphanCollector", func() {
+	var (
+		fakeClient *fakes.FakeStorageClient
+		kubeClient *k8sfake.Clientset
+		config     volume.OrphanCollectorConfig
+	)
+	BeforeEach(func() {
+		fakeClient = new(fakes.FakeStorageClient)
+		fakeClient.ListVolumesReturns([]resources.Volume{
+			{Name: "pv1", Backend: resources.SCBE},
+			{Name: "pv2", Backend: resources.SCBE},
+			{Name: "fileset1", Backend: resources.SpectrumScale},
+		}, nil)
+		kubeClient = k8sfake.NewSimpleClientset(newUbiquityPV("pv1", "pv1"))
+		config = volume.OrphanCollectorConfig{Interval: time.Hour}
+	})
+
+	It("reports the volumes no PV references without deleting them", func() {
+		orphans, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(Equal([]string{"fileset1", "pv2"}))
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+	})
+	It("reports only the volumes of the collected backends", func() {
+		config.Backends = []string{resources.SCBE}
+		orphans, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(Equal([]string{"pv2"}))
+		Expect(fakeClient.ListVolumesArgsForCall(0).Backends).To(Equal([]string{resources.SCBE}))
+	})
+	It("deletes the orphans once their grace period is over", func() {
+		config.Delete = true
+		orphans, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(HaveLen(2))
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(2))
+	})
+	It("does not delete the orphans during their grace period", func() {
+		config.Delete = true
+		config.GracePeriod = time.Hour
+		collector := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config)
+		_, err := collector.Collect()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = collector.Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+	})
+	It("keeps the volumes of the PVs created by hand", func() {
+		kubeClient = k8sfake.NewSimpleClientset(newUbiquityPV("pv1", "pv1"), newUbiquityPV("static", "fileset1"))
+		orphans, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(Equal([]string{"pv2"}))
+	})
+	It("keeps the volumes of the PVs of the CSI driver", func() {
+		csiPV := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv2", Annotations: map[string]string{"pv.kubernetes.io/provisioned-by": k8sresources.UbiquityK8sCsiDriverFullName}}}
+		kubeClient = k8sfake.NewSimpleClientset(newUbiquityPV("pv1", "pv1"), csiPV)
+		config.Delete = true
+		orphans, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(Equal([]string{"fileset1"}))
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(1))
+		Expect(fakeClient.RemoveVolumeArgsForCall(0).Name).To(Equal("fileset1"))
+	})
+	It("keeps the volumes of the PVs annotated with the provisioner identity", func() {
+		provisionedPV := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv2", Annotations: map[string]string{"Provisioner_Id": k8sresources.UbiquityProvisionerName}}}
+		kubeClient = k8sfake.NewSimpleClientset(newUbiquityPV("pv1", "pv1"), provisionedPV)
+		orphans, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(orphans).To(Equal([]string{"fileset1"}))
+	})
+	It("fails without deleting anything when the volumes cannot be listed", func() {
+		config.Delete = true
+		fakeClient.ListVolumesReturns(nil, fmt.Errorf("ubiquity server unreachable"))
+		_, err := volume.NewOrphanCollector(testLogger, kubeClient, fakeClient, config).Collect()
+		Expect(err).To(HaveOccurred())
+		Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(0))
+	})
+})
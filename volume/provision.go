@@ -23,13 +23,22 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/IBM/ubiquity-k8s/metrics"
 	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity-k8s/snapshot"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
 	"github.com/IBM/ubiquity/resources"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 
 	"k8s.io/api/core/v1"
 )
@@ -50,17 +59,40 @@ const (
 	// A PV annotation for the identity of the flexProvisioner that provisioned it
 	annProvisionerId = "Provisioner_Id"
 
+	// The PV annotation naming the provisioner that deletes the PV, the external-provisioner sets it to the CSI driver name
+	annDynamicallyProvisioned = "pv.kubernetes.io/provisioned-by"
+
+	// A PVC annotation naming a PVC of the same namespace to clone. It stands for
+	// PVC.Spec.DataSource, which the vendored k8s API (release-1.8) does not have yet,
+	// the provisioner does not read PVC.Spec.DataSource.
+	annDataSource = "ubiquity.ibm.com/data-source"
+	// The kind of the data source, PersistentVolumeClaim (the default) or VolumeSnapshot
+	annDataSourceKind = "ubiquity.ibm.com/data-source-kind"
+
+	// The annotation the claims used for their storage class before Spec.StorageClassName
+	annStorageClass = "volume.beta.kubernetes.io/storage-class"
+
 	podIPEnv     = "POD_IP"
 	serviceEnv   = "SERVICE_NAME"
 	namespaceEnv = "POD_NAMESPACE"
 	nodeEnv      = "NODE_NAME"
 )
 
-func NewFlexProvisioner(logger *log.Logger, ubiquityClient resources.StorageClient, config resources.UbiquityPluginConfig) (controller.Provisioner, error) {
-	return newFlexProvisionerInternal(logger, ubiquityClient, config)
+func NewFlexProvisioner(logger *log.Logger, ubiquityClient resources.StorageClient, kubeClient kubernetes.Interface, snapshotClient rest.Interface, config resources.UbiquityPluginConfig) (controller.Provisioner, error) {
+	return newFlexProvisionerInternal(logger, ubiquityClient, kubeClient, snapshotClient, config)
+}
+
+//NewFlexProvisionerWithTopology allows to instantiate a provisioner of storage reachable only from some nodes, it creates each volume in the first of topologies reachable from its pod node
+func NewFlexProvisionerWithTopology(logger *log.Logger, ubiquityClient resources.StorageClient, kubeClient kubernetes.Interface, snapshotClient rest.Interface, config resources.UbiquityPluginConfig, topologies []k8sresources.TopologyConfig) (controller.Provisioner, error) {
+	provisioner, err := newFlexProvisionerInternal(logger, ubiquityClient, kubeClient, snapshotClient, config)
+	if provisioner != nil {
+		provisioner.topologies = topologies
+	}
+	return provisioner, err
 }
 
-func newFlexProvisionerInternal(logger *log.Logger, ubiquityClient resources.StorageClient, config resources.UbiquityPluginConfig) (*flexProvisioner, error) {
+//GetIdentity returns the UUID of this provisioner, generated on the first start and kept in the identity file of the log directory
+func GetIdentity(logger *log.Logger, config resources.UbiquityPluginConfig) types.UID {
 	var identity types.UID
 	identityPath := path.Join(config.LogPath, identityFile)
 	if _, err := os.Stat(identityPath); os.IsNotExist(err) {
@@ -76,11 +108,24 @@ func newFlexProvisionerInternal(logger *log.Logger, ubiquityClient resources.Sto
 		}
 		identity = types.UID(strings.TrimSpace(string(read)))
 	}
+	return identity
+}
+
+func newFlexProvisionerInternal(logger *log.Logger, ubiquityClient resources.StorageClient, kubeClient kubernetes.Interface, snapshotClient rest.Interface, config resources.UbiquityPluginConfig) (*flexProvisioner, error) {
+	var eventRecorder record.EventRecorder
+	if kubeClient != nil {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(v1.NamespaceAll)})
+		eventRecorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: k8sresources.UbiquityProvisionerName})
+	}
 	provisioner := &flexProvisioner{
 		logger:         logger,
-		identity:       identity,
+		identity:       GetIdentity(logger, config),
+		eventRecorder:  eventRecorder,
 		ubiquityClient: ubiquityClient,
 		ubiquityConfig: config,
+		kubeClient:     kubeClient,
+		snapshotClient: snapshotClient,
 		podIPEnv:       podIPEnv,
 		serviceEnv:     serviceEnv,
 		namespaceEnv:   namespaceEnv,
@@ -103,6 +148,14 @@ type flexProvisioner struct {
 
 	ubiquityClient resources.StorageClient
 	ubiquityConfig resources.UbiquityPluginConfig
+	// The parts of the storage reachable only from some nodes, none when all the storage is reachable from all the nodes
+	topologies []k8sresources.TopologyConfig
+
+	// Read the data sources of the new volumes, the claims to clone and the VolumeSnapshots to restore
+	kubeClient     kubernetes.Interface
+	snapshotClient rest.Interface
+	// Records the failures of the backends on the claims, nil without a kubeClient
+	eventRecorder record.EventRecorder
 
 	// Environment variables the provisioner pod needs valid values for in order to
 	// put a service cluster IP as the server of provisioned NFS PVs, passed in
@@ -116,6 +169,13 @@ type flexProvisioner struct {
 // Provision creates a volume i.e. the storage asset and returns a PV object for
 // the volume.
 func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	start := time.Now()
+	pv, err := p.provision(options)
+	metrics.ObserveProvisionerOperation("Provision", options.Parameters["backend"], start, err)
+	return pv, err
+}
+
+func (p *flexProvisioner) provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
 	if options.PVC == nil {
 		return nil, fmt.Errorf("options missing PVC %#v", options)
 	}
@@ -133,14 +193,64 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 	fmt.Printf("PVC with capacity %d", capacity.Value())
 	capacityMB := capacity.Value() / (1024 * 1024)
 
-	volume_details, err := p.createVolume(options, capacityMB)
+	allowedTopologies, err := getAllowedTopologies(options.Parameters)
+	if err != nil {
+		err = fmt.Errorf("invalid parameters of storage class %s: %v", getClaimClass(options.PVC), err)
+		p.recordInvalidParameters(options, err)
+		return nil, err
+	}
+	// the topology may set the backend, the filesystem or the SCBE service of the volume
+	topology, err := p.selectTopology(options, allowedTopologies)
+	if err != nil {
+		return nil, err
+	}
+	options.Parameters = k8sutils.TopologyParameters(options.Parameters, topology)
+
+	// a typo in the storage class would create the volume with the backend defaults, e.g in another filesystem
+	if err := k8sutils.ValidateStorageClassParameters(options.Parameters); err != nil {
+		err = fmt.Errorf("invalid parameters of storage class %s: %v", getClaimClass(options.PVC), err)
+		p.recordInvalidParameters(options, err)
+		return nil, err
+	}
+
+	secret, err := p.getClassSecret(options.Parameters, options.PVC)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := p.getDataSource(options.PVC)
+	if err != nil {
+		return nil, err
+	}
+
+	volume_details, err := p.createVolume(options, capacityMB, source, secret.credentialInfo())
 	if err != nil {
+		p.recordCreateVolumeFailure(options, err)
 		return nil, err
 	}
 
 	annotations := make(map[string]string)
 	annotations[annCreatedBy] = createdBy
 	annotations[annProvisionerId] = k8sresources.UbiquityProvisionerName
+	// the pods of the volume are scheduled only on the nodes it is reachable from
+	if terms := k8sutils.TopologyTerms(topology, allowedTopologies); len(terms) > 0 {
+		nodeAffinity, err := nodeAffinityAnnotation(terms)
+		if err != nil {
+			return nil, fmt.Errorf("error setting the node affinity of PV %s: %v", options.PVName, err)
+		}
+		annotations[annNodeAffinity] = nodeAffinity
+	}
+	var secretRef *v1.LocalObjectReference
+	if secret != nil {
+		annotations[annSecretName] = secret.name
+		annotations[annSecretNamespace] = secret.namespace
+		// kubelet reads the SecretRef in the namespace of the pod
+		if secret.namespace == options.PVC.Namespace {
+			secretRef = &v1.LocalObjectReference{Name: secret.name}
+		} else {
+			p.logger.Printf("secret %s/%s is not in the namespace of claim %s/%s, the nodes mount PV %s with their own credentials", secret.namespace, secret.name, options.PVC.Namespace, options.PVC.Name, options.PVName)
+		}
+	}
 
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
@@ -158,7 +268,7 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 				FlexVolume: &v1.FlexVolumeSource{
 					Driver:    k8sresources.UbiquityK8sFlexVolumeDriverFullName,
 					FSType:    "",
-					SecretRef: nil,
+					SecretRef: secretRef,
 					ReadOnly:  false,
 					Options:   volume_details,
 				},
@@ -172,18 +282,33 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 // Delete removes the directory that was created by Provision backing the given
 // PV.
 func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
+	start := time.Now()
+	err := p.delete(volume)
+	backend := ""
+	if volume.Spec.FlexVolume != nil {
+		backend = volume.Spec.FlexVolume.Options["backend"]
+	}
+	metrics.ObserveProvisionerOperation("Delete", backend, start, err)
+	return err
+}
+
+func (p *flexProvisioner) delete(volume *v1.PersistentVolume) error {
 	if volume.Name == "" {
 		return fmt.Errorf("volume name cannot be empty %#v", volume)
 	}
 
 	if volume.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimRetain {
-		getVolumeRequest := resources.GetVolumeRequest{Name: volume.Name}
+		secret, err := p.getVolumeSecret(volume)
+		if err != nil {
+			return err
+		}
+		getVolumeRequest := resources.GetVolumeRequest{Name: volume.Name, CredentialInfo: secret.credentialInfo()}
 		volume, err := p.ubiquityClient.GetVolume(getVolumeRequest)
 		if err != nil {
 			fmt.Printf("error-retrieving-volume-info")
 			return err
 		}
-		removeVolumeRequest := resources.RemoveVolumeRequest{Name: volume.Name}
+		removeVolumeRequest := resources.RemoveVolumeRequest{Name: volume.Name, CredentialInfo: secret.credentialInfo()}
 		err = p.ubiquityClient.RemoveVolume(removeVolumeRequest)
 		if err != nil {
 			return err
@@ -195,37 +320,243 @@ func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
 	return nil
 }
 
-func (p *flexProvisioner) createVolume(options controller.VolumeOptions, capacity int64) (map[string]string, error) {
+func (p *flexProvisioner) createVolume(options controller.VolumeOptions, capacity int64, source *dataSource, credentials resources.CredentialInfo) (map[string]string, error) {
 	ubiquityParams := make(map[string]interface{})
 	if capacity != 0 {
 		ubiquityParams["quota"] = fmt.Sprintf("%dM", capacity)    // SSc backend expect quota option
 		ubiquityParams["size"] = fmt.Sprintf("%d", capacity/1024) // SCBE backend expect size option
 	}
+	podMountLayout := options.Parameters[k8sresources.OptionNamePodMountLayout]
 	for key, value := range options.Parameters {
+		if !k8sutils.IsBackendParameter(key) {
+			// a flex only option, ubiquity does not know it
+			continue
+		}
 		ubiquityParams[key] = value
 	}
-	backendName, exists := ubiquityParams["backend"]
+	b, exists := options.Parameters["backend"]
 	if !exists {
 		return nil, fmt.Errorf("backend is not specified")
 	}
-	b := backendName.(string)
-	createVolumeRequest := resources.CreateVolumeRequest{Name: options.PVName, Backend: b, Opts: ubiquityParams}
-	err := p.ubiquityClient.CreateVolume(createVolumeRequest)
-	if err != nil {
-		return nil, fmt.Errorf("error creating volume: %v", err)
+	if source != nil && source.volume != nil {
+		err := p.cloneVolume(options.PVName, b, ubiquityParams, source.volume, credentials)
+		if err != nil {
+			return nil, err
+		}
+	} else if source != nil && source.snapshot != nil {
+		err := p.restoreSnapshot(options.PVName, b, ubiquityParams, source.snapshot, credentials)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		createVolumeRequest := resources.CreateVolumeRequest{Name: options.PVName, Backend: b, Opts: ubiquityParams, CredentialInfo: credentials}
+		err := p.ubiquityClient.CreateVolume(createVolumeRequest)
+		if err != nil {
+			return nil, fmt.Errorf("error creating volume: %v", err)
+		}
 	}
 
-	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: options.PVName}
+	getVolumeConfigRequest := resources.GetVolumeConfigRequest{Name: options.PVName, CredentialInfo: credentials}
 	volumeConfig, err := p.ubiquityClient.GetVolumeConfig(getVolumeConfigRequest)
 	if err != nil {
 		return nil, fmt.Errorf("error getting volume config details: %v", err)
 	}
 
+	return flexVolumeOptions(options.PVName, b, podMountLayout, volumeConfig), nil
+}
+
+// flexVolumeOptions returns the options of the flex volume of a ubiquity volume, the flex driver reads the volume config from them.
+// podMountLayout is the one of the storage class, empty when it sets none.
+func flexVolumeOptions(volumeName string, backend string, podMountLayout string, volumeConfig map[string]interface{}) map[string]string {
 	flexVolumeConfig := make(map[string]string)
-	flexVolumeConfig["volumeName"] = options.PVName
+	flexVolumeConfig["volumeName"] = volumeName
+	flexVolumeConfig["backend"] = backend
+	if podMountLayout != "" {
+		flexVolumeConfig[k8sresources.OptionNamePodMountLayout] = podMountLayout
+	}
 	for key, value := range volumeConfig {
 		flexVolumeConfig[key] = fmt.Sprintf("%v", value)
 	}
+	return flexVolumeConfig
+}
+
+// dataSource is what a new volume is created from, either a PV to clone or a VolumeSnapshot to restore
+type dataSource struct {
+	volume   *v1.PersistentVolume
+	snapshot *snapshot.VolumeSnapshot
+}
 
-	return flexVolumeConfig, nil
+// getDataSource returns the data source of the new claim, nil if it has none
+func (p *flexProvisioner) getDataSource(claim *v1.PersistentVolumeClaim) (*dataSource, error) {
+	sourceName, ok := claim.Annotations[annDataSource]
+	if !ok {
+		return nil, nil
+	}
+	switch kind := claim.Annotations[annDataSourceKind]; kind {
+	case "", "PersistentVolumeClaim":
+		// the ubiquity client of the pinned ubiquity version has no clone call, fail before fetching the source
+		if !k8sutils.SupportsCloning(p.ubiquityClient) {
+			return nil, fmt.Errorf("cannot clone claim %s/%s, the ubiquity client of the provisioner does not support volume cloning", claim.Namespace, sourceName)
+		}
+		sourceVolume, err := p.getCloneSource(claim, sourceName)
+		if err != nil {
+			return nil, err
+		}
+		return &dataSource{volume: sourceVolume}, nil
+	case snapshot.VolumeSnapshotKind:
+		if !k8sutils.SupportsSnapshots(p.ubiquityClient) {
+			return nil, fmt.Errorf("cannot restore VolumeSnapshot %s/%s, the ubiquity client of the provisioner does not support volume snapshots", claim.Namespace, sourceName)
+		}
+		sourceSnapshot, err := p.getSnapshotSource(claim, sourceName)
+		if err != nil {
+			return nil, err
+		}
+		return &dataSource{snapshot: sourceSnapshot}, nil
+	default:
+		return nil, fmt.Errorf("data source kind [%s] is not supported, use PersistentVolumeClaim or %s", kind, snapshot.VolumeSnapshotKind)
+	}
+}
+
+// getCloneSource returns the PV bound to the claim sourceName.
+// The clone gets the backend, storage class and content of the source, so it must support the access modes
+// and fit the capacity of the source.
+func (p *flexProvisioner) getCloneSource(claim *v1.PersistentVolumeClaim, sourceName string) (*v1.PersistentVolume, error) {
+	if p.kubeClient == nil {
+		return nil, fmt.Errorf("cannot clone claim %s, the provisioner has no kubernetes client", sourceName)
+	}
+
+	sourceClaim, err := p.kubeClient.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(sourceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the data source claim %s/%s: %v", claim.Namespace, sourceName, err)
+	}
+	if sourceClaim.Status.Phase != v1.ClaimBound || sourceClaim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("cannot clone claim %s/%s, it is not bound", claim.Namespace, sourceName)
+	}
+	if getClaimClass(sourceClaim) != getClaimClass(claim) {
+		return nil, fmt.Errorf("cannot clone claim %s/%s of storage class [%s] into storage class [%s]", claim.Namespace, sourceName, getClaimClass(sourceClaim), getClaimClass(claim))
+	}
+
+	sourceVolume, err := p.kubeClient.CoreV1().PersistentVolumes().Get(sourceClaim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting PV %s of the data source claim %s/%s: %v", sourceClaim.Spec.VolumeName, claim.Namespace, sourceName, err)
+	}
+	if sourceVolume.Spec.FlexVolume == nil || sourceVolume.Spec.FlexVolume.Driver != k8sresources.UbiquityK8sFlexVolumeDriverFullName {
+		return nil, fmt.Errorf("cannot clone claim %s/%s, its PV %s was not provisioned by ubiquity", claim.Namespace, sourceName, sourceVolume.Name)
+	}
+
+	for _, accessMode := range claim.Spec.AccessModes {
+		if !containsAccessMode(sourceVolume.Spec.AccessModes, accessMode) {
+			return nil, fmt.Errorf("cannot clone claim %s/%s with access mode %s, its PV %s supports %v", claim.Namespace, sourceName, accessMode, sourceVolume.Name, sourceVolume.Spec.AccessModes)
+		}
+	}
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	sourceCapacity := sourceVolume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if requested.Cmp(sourceCapacity) < 0 {
+		return nil, fmt.Errorf("cannot clone claim %s/%s of %s into a smaller volume of %s", claim.Namespace, sourceName, sourceCapacity.String(), requested.String())
+	}
+
+	return sourceVolume, nil
+}
+
+func (p *flexProvisioner) cloneVolume(name string, backend string, ubiquityParams map[string]interface{}, sourceVolume *v1.PersistentVolume, credentials resources.CredentialInfo) error {
+	sourceBackend := sourceVolume.Spec.FlexVolume.Options["backend"]
+	if sourceBackend != "" && sourceBackend != backend {
+		return fmt.Errorf("cannot clone PV %s of backend %s into backend %s", sourceVolume.Name, sourceBackend, backend)
+	}
+	sourceName, ok := sourceVolume.Spec.FlexVolume.Options["volumeName"]
+	if !ok {
+		sourceName = sourceVolume.Name
+	}
+
+	p.logger.Printf("cloning volume %s from volume %s on backend %s", name, sourceName, backend)
+	cloneVolumeRequest := k8sresources.CloneVolumeRequest{Name: name, SourceName: sourceName, Backend: backend, Opts: ubiquityParams, CredentialInfo: credentials}
+	if err := k8sutils.NewOptionalStorageCalls(p.ubiquityClient).CloneVolume(cloneVolumeRequest); err != nil {
+		return fmt.Errorf("error cloning volume %s: %v", sourceName, err)
+	}
+	return nil
+}
+
+// getSnapshotSource returns the ready VolumeSnapshot sourceName, the new volume must fit its capacity
+func (p *flexProvisioner) getSnapshotSource(claim *v1.PersistentVolumeClaim, sourceName string) (*snapshot.VolumeSnapshot, error) {
+	if p.snapshotClient == nil {
+		return nil, fmt.Errorf("cannot restore VolumeSnapshot %s, the provisioner has no snapshot client", sourceName)
+	}
+
+	sourceSnapshot, err := snapshot.Get(p.snapshotClient, claim.Namespace, sourceName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the data source VolumeSnapshot %s/%s: %v", claim.Namespace, sourceName, err)
+	}
+	if !sourceSnapshot.Status.Ready {
+		return nil, fmt.Errorf("cannot restore VolumeSnapshot %s/%s, it is not ready", claim.Namespace, sourceName)
+	}
+	if sourceSnapshot.DeletionTimestamp != nil {
+		return nil, fmt.Errorf("cannot restore VolumeSnapshot %s/%s, it is being deleted", claim.Namespace, sourceName)
+	}
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if requested.Cmp(sourceSnapshot.Status.RestoreSize) < 0 {
+		return nil, fmt.Errorf("cannot restore VolumeSnapshot %s/%s of %s into a smaller volume of %s", claim.Namespace, sourceName, sourceSnapshot.Status.RestoreSize.String(), requested.String())
+	}
+
+	return sourceSnapshot, nil
+}
+
+func (p *flexProvisioner) restoreSnapshot(name string, backend string, ubiquityParams map[string]interface{}, sourceSnapshot *snapshot.VolumeSnapshot, credentials resources.CredentialInfo) error {
+	if sourceSnapshot.Status.Backend != backend {
+		return fmt.Errorf("cannot restore VolumeSnapshot %s of backend %s into backend %s", sourceSnapshot.Name, sourceSnapshot.Status.Backend, backend)
+	}
+
+	p.logger.Printf("restoring volume %s from snapshot %s of volume %s on backend %s", name, sourceSnapshot.Status.SnapshotName, sourceSnapshot.Status.SourceVolumeName, backend)
+	restoreSnapshotRequest := k8sresources.RestoreSnapshotRequest{
+		Name:             name,
+		SnapshotName:     sourceSnapshot.Status.SnapshotName,
+		SourceVolumeName: sourceSnapshot.Status.SourceVolumeName,
+		Backend:          backend,
+		Opts:             ubiquityParams,
+		CredentialInfo:   credentials,
+	}
+	if err := k8sutils.NewOptionalStorageCalls(p.ubiquityClient).RestoreSnapshot(restoreSnapshotRequest); err != nil {
+		return fmt.Errorf("error restoring snapshot %s: %v", sourceSnapshot.Status.SnapshotName, err)
+	}
+	return nil
+}
+
+// recordCreateVolumeFailure records the failure on the claim with the backend, the profile and the error of the ubiquity server
+func (p *flexProvisioner) recordCreateVolumeFailure(options controller.VolumeOptions, err error) {
+	if p.eventRecorder == nil {
+		return
+	}
+	backend := options.Parameters["backend"]
+	profile, ok := options.Parameters["profile"]
+	if !ok {
+		profile = "none"
+	}
+	reason := k8sutils.ClassifyStorageFailure(err.Error(), "CreateVolumeFailed")
+	p.eventRecorder.Eventf(options.PVC, v1.EventTypeWarning, reason, "Failed to create volume %s on backend %s with profile %s: %v", options.PVName, backend, profile, err)
+}
+
+// recordInvalidParameters records the invalid storage class parameters on the claim, no volume was created for it
+func (p *flexProvisioner) recordInvalidParameters(options controller.VolumeOptions, err error) {
+	if p.eventRecorder == nil {
+		return
+	}
+	p.eventRecorder.Eventf(options.PVC, v1.EventTypeWarning, "InvalidParameters", "Failed to create volume %s: %v", options.PVName, err)
+}
+
+func getClaimClass(claim *v1.PersistentVolumeClaim) string {
+	if class, ok := claim.Annotations[annStorageClass]; ok {
+		return class
+	}
+	if claim.Spec.StorageClassName != nil {
+		return *claim.Spec.StorageClassName
+	}
+	return ""
+}
+
+func containsAccessMode(accessModes []v1.PersistentVolumeAccessMode, accessMode v1.PersistentVolumeAccessMode) bool {
+	for _, mode := range accessModes {
+		if mode == accessMode {
+			return true
+		}
+	}
+	return false
 }
@@ -19,6 +19,8 @@ package volume_test
 import (
 	"fmt"
 
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
 	"github.com/IBM/ubiquity-k8s/volume"
 	"github.com/IBM/ubiquity/fakes"
 	"github.com/IBM/ubiquity/resources"
@@ -26,9 +28,31 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 )
 
+type fakeCloningClient struct {
+	*fakes.FakeStorageClient
+	cloneVolumeRequests []k8sresources.CloneVolumeRequest
+}
+
+func (c *fakeCloningClient) CloneVolume(cloneVolumeRequest k8sresources.CloneVolumeRequest) error {
+	c.cloneVolumeRequests = append(c.cloneVolumeRequests, cloneVolumeRequest)
+	return nil
+}
+
+func newClaim(name string, storage string, annotations map[string]string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources:   v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(storage)}},
+		},
+	}
+}
+
 var _ = Describe("Provisioner", func() {
 	var (
 		fakeClient *fakes.FakeStorageClient
@@ -45,7 +69,7 @@ var _ = Describe("Provisioner", func() {
 		backends = []string{resources.SpectrumScale}
 		ubiquityConfig = resources.UbiquityPluginConfig{Backends: backends}
 		// fakeKubeInterface = new(k8s_fake.FakeInterface)
-		provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, ubiquityConfig)
+		provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, nil, nil, ubiquityConfig)
 	})
 
 	Context(".Provision", func() {
@@ -64,6 +88,239 @@ var _ = Describe("Provisioner", func() {
 
 	})
 
+	Context(".Provision with a data source", func() {
+		var (
+			sourceClaim  *v1.PersistentVolumeClaim
+			sourceVolume *v1.PersistentVolume
+		)
+		BeforeEach(func() {
+			sourceClaim = newClaim("golden", "1Gi", map[string]string{"volume.beta.kubernetes.io/storage-class": "gold"})
+			sourceClaim.Spec.VolumeName = "pv-golden"
+			sourceClaim.Status.Phase = v1.ClaimBound
+			sourceVolume = &v1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{Name: "pv-golden"},
+				Spec: v1.PersistentVolumeSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+					Capacity:    v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+					PersistentVolumeSource: v1.PersistentVolumeSource{
+						FlexVolume: &v1.FlexVolumeSource{
+							Driver:  k8sresources.UbiquityK8sFlexVolumeDriverFullName,
+							Options: map[string]string{"volumeName": "pv-golden", "backend": resources.SCBE},
+						},
+					},
+				},
+			}
+			options = controller.VolumeOptions{
+				PVName:     "pv-clone",
+				PVC:        newClaim("clone", "2Gi", map[string]string{"volume.beta.kubernetes.io/storage-class": "gold", "ubiquity.ibm.com/data-source": "golden"}),
+				Parameters: map[string]string{"backend": resources.SCBE},
+			}
+		})
+		It("clones the volume of the source claim", func() {
+			cloningClient := &fakeCloningClient{FakeStorageClient: fakeClient}
+			provisioner, err = volume.NewFlexProvisioner(testLogger, cloningClient, k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			pv, err := provisioner.Provision(options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pv.Name).To(Equal("pv-clone"))
+			Expect(cloningClient.cloneVolumeRequests).To(HaveLen(1))
+			Expect(cloningClient.cloneVolumeRequests[0].Name).To(Equal("pv-clone"))
+			Expect(cloningClient.cloneVolumeRequests[0].SourceName).To(Equal("pv-golden"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails without creating a volume when the ubiquity client cannot clone", func() {
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not support volume cloning"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("clones through the storage clients wrapping the one that can clone", func() {
+			cloningClient := &fakeCloningClient{FakeStorageClient: fakeClient}
+			provisioner, err = volume.NewFlexProvisioner(testLogger, k8sutils.NewReloadableStorageClient(cloningClient), k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cloningClient.cloneVolumeRequests).To(HaveLen(1))
+		})
+		It("fails when the clone is smaller than the source", func() {
+			options.PVC = newClaim("clone", "512Mi", options.PVC.Annotations)
+			provisioner, err = volume.NewFlexProvisioner(testLogger, &fakeCloningClient{FakeStorageClient: fakeClient}, k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("smaller"))
+		})
+		It("fails when the source claim is not bound", func() {
+			sourceClaim.Status.Phase = v1.ClaimPending
+			provisioner, err = volume.NewFlexProvisioner(testLogger, &fakeCloningClient{FakeStorageClient: fakeClient}, k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("not bound"))
+		})
+		It("fails without creating a volume when the ubiquity client cannot restore a VolumeSnapshot", func() {
+			options.PVC.Annotations["ubiquity.ibm.com/data-source-kind"] = "VolumeSnapshot"
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not support volume snapshots"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails when restoring a VolumeSnapshot without a snapshot client", func() {
+			options.PVC.Annotations["ubiquity.ibm.com/data-source-kind"] = "VolumeSnapshot"
+			provisioner, err = volume.NewFlexProvisioner(testLogger, &fakeSnapshottingClient{FakeStorageClient: fakeClient}, k8sfake.NewSimpleClientset(), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("no snapshot client"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails when the data source kind is unknown", func() {
+			options.PVC.Annotations["ubiquity.ibm.com/data-source-kind"] = "ConfigMap"
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("ConfigMap"))
+		})
+		It("fails without creating the volume when a storage class parameter is misspelled", func() {
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			options.Parameters = map[string]string{"backend": resources.SpectrumScale, "filesytem": "gold"}
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`unknown parameter "filesytem"`))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails when the source claim is of another storage class", func() {
+			sourceClaim.Annotations["volume.beta.kubernetes.io/storage-class"] = "silver"
+			provisioner, err = volume.NewFlexProvisioner(testLogger, &fakeCloningClient{FakeStorageClient: fakeClient}, k8sfake.NewSimpleClientset(sourceClaim, sourceVolume), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("silver"))
+		})
+	})
+
+	Context(".Provision with a credentials secret", func() {
+		var secret *v1.Secret
+		BeforeEach(func() {
+			secret = &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "default-credentials", Namespace: "default"},
+				Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+			}
+			options = controller.VolumeOptions{
+				PVName:     "pv1",
+				PVC:        newClaim("claim1", "1Gi", nil),
+				Parameters: map[string]string{"backend": resources.SCBE, "ubiquity.ibm.com/secret-name": "${pvc.namespace}-credentials"},
+			}
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+		})
+		It("creates the volume with the credentials of the secret and references it in the PV", func() {
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(secret), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			pv, err := provisioner.Provision(options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(1))
+			createVolumeRequest := fakeClient.CreateVolumeArgsForCall(0)
+			Expect(createVolumeRequest.CredentialInfo).To(Equal(resources.CredentialInfo{UserName: "admin", Password: "secret"}))
+			Expect(createVolumeRequest.Opts).ToNot(HaveKey("ubiquity.ibm.com/secret-name"))
+			Expect(pv.Spec.FlexVolume.SecretRef).To(Equal(&v1.LocalObjectReference{Name: "default-credentials"}))
+			Expect(pv.Annotations).To(HaveKeyWithValue("ubiquity.ibm.com/secret-name", "default-credentials"))
+			Expect(pv.Annotations).To(HaveKeyWithValue("ubiquity.ibm.com/secret-namespace", "default"))
+		})
+		It("does not reference a secret of another namespace in the PV", func() {
+			secret.Namespace = "ubiquity"
+			options.Parameters["ubiquity.ibm.com/secret-namespace"] = "ubiquity"
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(secret), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			pv, err := provisioner.Provision(options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.CreateVolumeArgsForCall(0).CredentialInfo.UserName).To(Equal("admin"))
+			Expect(pv.Spec.FlexVolume.SecretRef).To(BeNil())
+			Expect(pv.Annotations).To(HaveKeyWithValue("ubiquity.ibm.com/secret-namespace", "ubiquity"))
+		})
+		It("fails without creating the volume when the secret does not exist", func() {
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("default/default-credentials"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("fails without creating the volume when the secret has no password", func() {
+			delete(secret.Data, "password")
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(secret), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("no password key"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("deletes the volume with the credentials of the secret of the PV", func() {
+			provisioner, err = volume.NewFlexProvisioner(testLogger, fakeClient, k8sfake.NewSimpleClientset(secret), nil, ubiquityConfig)
+			Expect(err).ToNot(HaveOccurred())
+			fakeClient.GetVolumeReturns(resources.Volume{Name: "pv1"}, nil)
+			pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{
+				Name:        "pv1",
+				Annotations: map[string]string{"ubiquity.ibm.com/secret-name": "default-credentials", "ubiquity.ibm.com/secret-namespace": "default"},
+			}}
+			err = provisioner.Delete(pv)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.RemoveVolumeCallCount()).To(Equal(1))
+			Expect(fakeClient.RemoveVolumeArgsForCall(0).CredentialInfo).To(Equal(resources.CredentialInfo{UserName: "admin", Password: "secret"}))
+		})
+	})
+
+	Context(".Provision with topologies", func() {
+		var (
+			node       *v1.Node
+			topologies []k8sresources.TopologyConfig
+		)
+		BeforeEach(func() {
+			node = &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"ubiquity.ibm.com/fabric": "b"}}}
+			topologies = []k8sresources.TopologyConfig{
+				{Labels: map[string]string{"ubiquity.ibm.com/fabric": "a"}, Backend: resources.SCBE, Parameters: map[string]string{"profile": "gold-a"}},
+				{Labels: map[string]string{"ubiquity.ibm.com/fabric": "b"}, Backend: resources.SCBE, Parameters: map[string]string{"profile": "gold-b"}},
+			}
+			options = controller.VolumeOptions{
+				PVName:     "pv1",
+				PVC:        newClaim("claim1", "1Gi", map[string]string{"volume.kubernetes.io/selected-node": "node1"}),
+				Parameters: map[string]string{"fstype": "xfs"},
+			}
+			fakeClient.GetVolumeConfigReturns(map[string]interface{}{}, nil)
+		})
+		It("creates the volume in the topology of the selected node and restricts the PV to its nodes", func() {
+			provisioner, err = volume.NewFlexProvisionerWithTopology(testLogger, fakeClient, k8sfake.NewSimpleClientset(node), nil, ubiquityConfig, topologies)
+			Expect(err).ToNot(HaveOccurred())
+			pv, err := provisioner.Provision(options)
+			Expect(err).ToNot(HaveOccurred())
+			createVolumeRequest := fakeClient.CreateVolumeArgsForCall(0)
+			Expect(createVolumeRequest.Backend).To(Equal(resources.SCBE))
+			Expect(createVolumeRequest.Opts).To(HaveKeyWithValue("profile", "gold-b"))
+			Expect(createVolumeRequest.Opts).To(HaveKeyWithValue("fstype", "xfs"))
+			Expect(pv.Annotations["volume.alpha.kubernetes.io/node-affinity"]).To(ContainSubstring(`"key":"ubiquity.ibm.com/fabric","operator":"In","values":["b"]`))
+		})
+		It("fails without creating the volume when no topology is reachable from the selected node", func() {
+			node.Labels["ubiquity.ibm.com/fabric"] = "c"
+			provisioner, err = volume.NewFlexProvisionerWithTopology(testLogger, fakeClient, k8sfake.NewSimpleClientset(node), nil, ubiquityConfig, topologies)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = provisioner.Provision(options)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("reachable from the selected node"))
+			Expect(fakeClient.CreateVolumeCallCount()).To(Equal(0))
+		})
+		It("restricts the PV to the allowed topologies without configured topologies", func() {
+			options.PVC.Annotations = nil
+			options.Parameters = map[string]string{"backend": resources.SCBE, "ubiquity.ibm.com/allowed-topologies": `[{"matchLabelExpressions": [{"key": "zone", "values": ["z1"]}]}]`}
+			pv, err := provisioner.Provision(options)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fakeClient.CreateVolumeArgsForCall(0).Opts).ToNot(HaveKey("ubiquity.ibm.com/allowed-topologies"))
+			Expect(pv.Annotations["volume.alpha.kubernetes.io/node-affinity"]).To(ContainSubstring(`"key":"zone","operator":"In","values":["z1"]`))
+		})
+	})
+
 	Context(".Delete", func() {
 
 		It("fails when volume name is empty", func() {
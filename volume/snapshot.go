@@ -0,0 +1,261 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	ubiquitycontroller "github.com/IBM/ubiquity-k8s/controller"
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity-k8s/snapshot"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/IBM/ubiquity/resources"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kubernetes/pkg/util/goroutinemap"
+)
+
+// The resync retries the snapshots that failed, to be taken or deleted
+const snapshotResyncPeriod = 5 * time.Minute
+
+// The finalizer keeps a VolumeSnapshot until its backend snapshot is deleted, even when it is deleted while the provisioner is down or a standby
+const snapshotFinalizer = "ubiquity.ibm.com/volume-snapshot"
+
+//SnapshotController takes a backend snapshot for each VolumeSnapshot, and deletes it with the VolumeSnapshot.
+//The VolumeSnapshots get a finalizer, removed once their backend snapshot is deleted, the failed deletes are retried at each resync.
+type SnapshotController struct {
+	logger         *log.Logger
+	client         kubernetes.Interface
+	snapshotClient rest.Interface
+	ubiquityClient resources.StorageClient
+	// one operation per VolumeSnapshot at a time
+	operations goroutinemap.GoRoutineMap
+}
+
+//NewSnapshotController allows to instantiate a snapshot controller
+func NewSnapshotController(logger *log.Logger, client kubernetes.Interface, snapshotClient rest.Interface, ubiquityClient resources.StorageClient) *SnapshotController {
+	return &SnapshotController{
+		logger:         logger,
+		client:         client,
+		snapshotClient: snapshotClient,
+		ubiquityClient: ubiquityClient,
+		operations:     goroutinemap.NewGoRoutineMap(true /* exponentialBackOffOnError */),
+	}
+}
+
+//Run watches the VolumeSnapshots until stopCh is closed
+func (s *SnapshotController) Run(stopCh <-chan struct{}) {
+	snapshotListWatch := cache.NewListWatchFromClient(s.snapshotClient, snapshot.VolumeSnapshotResourcePlural, v1.NamespaceAll, fields.Everything())
+	_, snapshotController := cache.NewInformer(snapshotListWatch, &snapshot.VolumeSnapshot{}, snapshotResyncPeriod, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.scheduleSync(obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			s.scheduleSync(newObj)
+		},
+	})
+
+	s.logger.Printf("Starting the snapshot controller")
+	snapshotController.Run(stopCh)
+}
+
+// scheduleSync takes the snapshot of a new VolumeSnapshot, or deletes the one of a deleted VolumeSnapshot
+func (s *SnapshotController) scheduleSync(obj interface{}) {
+	volumeSnapshot, ok := obj.(*snapshot.VolumeSnapshot)
+	if !ok {
+		return
+	}
+	switch {
+	case volumeSnapshot.DeletionTimestamp != nil:
+		if hasSnapshotFinalizer(volumeSnapshot) {
+			s.run(volumeSnapshot, s.DeleteSnapshot)
+		}
+	case !volumeSnapshot.Status.Ready:
+		s.run(volumeSnapshot, s.CreateSnapshot)
+	case !hasSnapshotFinalizer(volumeSnapshot):
+		// taken before the VolumeSnapshots got the finalizer
+		s.run(volumeSnapshot, s.addSnapshotFinalizer)
+	}
+}
+
+func (s *SnapshotController) run(volumeSnapshot *snapshot.VolumeSnapshot, operation func(*snapshot.VolumeSnapshot) error) {
+	snapshotKey := volumeSnapshot.Namespace + "/" + volumeSnapshot.Name
+	s.operations.Run(snapshotKey, func() error {
+		err := operation(volumeSnapshot)
+		if err != nil {
+			s.logger.Printf("Failed to process VolumeSnapshot %s: %v", snapshotKey, err)
+		}
+		return err
+	})
+}
+
+//CreateSnapshot takes the backend snapshot of the VolumeSnapshot, named after its UID. The VolumeSnapshot gets the finalizer and the snapshot name first,
+//so that deleting it deletes a snapshot taken whatever happens next, and a snapshot that already exists is taken as the one of a previous attempt.
+func (s *SnapshotController) CreateSnapshot(volumeSnapshot *snapshot.VolumeSnapshot) error {
+	claimName := volumeSnapshot.Spec.PersistentVolumeClaimName
+	claim, err := s.client.CoreV1().PersistentVolumeClaims(volumeSnapshot.Namespace).Get(claimName, metav1.GetOptions{})
+	if err != nil {
+		return s.setSnapshotError(volumeSnapshot, fmt.Errorf("error getting claim %s/%s: %v", volumeSnapshot.Namespace, claimName, err))
+	}
+	if claim.Status.Phase != v1.ClaimBound || claim.Spec.VolumeName == "" {
+		return s.setSnapshotError(volumeSnapshot, fmt.Errorf("claim %s/%s is not bound", volumeSnapshot.Namespace, claimName))
+	}
+	pv, err := s.client.CoreV1().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return s.setSnapshotError(volumeSnapshot, fmt.Errorf("error getting PV %s: %v", claim.Spec.VolumeName, err))
+	}
+	if pv.Spec.FlexVolume == nil || pv.Spec.FlexVolume.Driver != k8sresources.UbiquityK8sFlexVolumeDriverFullName {
+		return s.setSnapshotError(volumeSnapshot, fmt.Errorf("PV %s was not provisioned by ubiquity", pv.Name))
+	}
+
+	volumeName, ok := pv.Spec.FlexVolume.Options["volumeName"]
+	if !ok {
+		volumeName = pv.Name
+	}
+	secret, err := getAnnotatedSecret(s.client, pv.Annotations)
+	if err != nil {
+		return s.setSnapshotError(volumeSnapshot, err)
+	}
+	backend, ok := pv.Spec.FlexVolume.Options["backend"]
+	if !ok {
+		// PVs provisioned before the backend was recorded in their options
+		volume, err := s.ubiquityClient.GetVolume(resources.GetVolumeRequest{Name: volumeName, CredentialInfo: secret.credentialInfo()})
+		if err != nil {
+			return s.setSnapshotError(volumeSnapshot, fmt.Errorf("error getting volume %s: %v", volumeName, err))
+		}
+		backend = volume.Backend
+	}
+
+	snapshotName := fmt.Sprintf("snapshot-%s", volumeSnapshot.UID)
+	if !hasSnapshotFinalizer(volumeSnapshot) || volumeSnapshot.Status.SnapshotName != snapshotName {
+		volumeSnapshot = volumeSnapshot.DeepCopy()
+		if !hasSnapshotFinalizer(volumeSnapshot) {
+			volumeSnapshot.Finalizers = append(volumeSnapshot.Finalizers, snapshotFinalizer)
+		}
+		volumeSnapshot.Status.SnapshotName = snapshotName
+		volumeSnapshot.Status.SourceVolumeName = volumeName
+		volumeSnapshot.Status.Backend = backend
+		if secret != nil {
+			// the delete reads the secret of the PV from the VolumeSnapshot, the PV may be gone by then
+			if volumeSnapshot.Annotations == nil {
+				volumeSnapshot.Annotations = map[string]string{}
+			}
+			volumeSnapshot.Annotations[annSecretName] = secret.name
+			volumeSnapshot.Annotations[annSecretNamespace] = secret.namespace
+		}
+		volumeSnapshot, err = snapshot.Update(s.snapshotClient, volumeSnapshot)
+		if err != nil {
+			return fmt.Errorf("error adding the finalizer of snapshot %s: %v", snapshotName, err)
+		}
+	}
+
+	s.logger.Printf("Taking snapshot %s of volume %s on backend %s", snapshotName, volumeName, backend)
+	createSnapshotRequest := k8sresources.CreateSnapshotRequest{Name: snapshotName, VolumeName: volumeName, Backend: backend, CredentialInfo: secret.credentialInfo()}
+	if err := k8sutils.NewOptionalStorageCalls(s.ubiquityClient).CreateSnapshot(createSnapshotRequest); err != nil {
+		if ubiquitycontroller.ErrorCode(err) != k8sresources.ErrorCodeAlreadyExists {
+			return s.setSnapshotError(volumeSnapshot, fmt.Errorf("error taking snapshot %s of volume %s: %v", snapshotName, volumeName, err))
+		}
+		s.logger.Printf("Snapshot %s of volume %s already exists", snapshotName, volumeName)
+	}
+
+	volumeSnapshot = volumeSnapshot.DeepCopy()
+	now := metav1.Now()
+	volumeSnapshot.Status = snapshot.VolumeSnapshotStatus{
+		Ready:            true,
+		SnapshotName:     snapshotName,
+		SourceVolumeName: volumeName,
+		Backend:          backend,
+		RestoreSize:      pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)],
+		CreationTime:     &now,
+	}
+	if _, err := snapshot.Update(s.snapshotClient, volumeSnapshot); err != nil {
+		return fmt.Errorf("error updating the status of VolumeSnapshot %s/%s: %v", volumeSnapshot.Namespace, volumeSnapshot.Name, err)
+	}
+	s.logger.Printf("VolumeSnapshot %s/%s is ready", volumeSnapshot.Namespace, volumeSnapshot.Name)
+	return nil
+}
+
+//DeleteSnapshot deletes the backend snapshot of the deleted VolumeSnapshot, if any, and then removes its finalizer. A snapshot already gone counts as deleted.
+func (s *SnapshotController) DeleteSnapshot(volumeSnapshot *snapshot.VolumeSnapshot) error {
+	if volumeSnapshot.Status.SnapshotName != "" {
+		secret, err := getAnnotatedSecret(s.client, volumeSnapshot.Annotations)
+		if err != nil {
+			return err
+		}
+		s.logger.Printf("Deleting snapshot %s of volume %s", volumeSnapshot.Status.SnapshotName, volumeSnapshot.Status.SourceVolumeName)
+		deleteSnapshotRequest := k8sresources.DeleteSnapshotRequest{
+			Name:           volumeSnapshot.Status.SnapshotName,
+			VolumeName:     volumeSnapshot.Status.SourceVolumeName,
+			Backend:        volumeSnapshot.Status.Backend,
+			CredentialInfo: secret.credentialInfo(),
+		}
+		if err := k8sutils.NewOptionalStorageCalls(s.ubiquityClient).DeleteSnapshot(deleteSnapshotRequest); err != nil && ubiquitycontroller.ErrorCode(err) != k8sresources.ErrorCodeSnapshotNotFound {
+			return fmt.Errorf("error deleting snapshot %s: %v", volumeSnapshot.Status.SnapshotName, err)
+		}
+	}
+
+	volumeSnapshot = volumeSnapshot.DeepCopy()
+	var finalizers []string
+	for _, finalizer := range volumeSnapshot.Finalizers {
+		if finalizer != snapshotFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	volumeSnapshot.Finalizers = finalizers
+	if _, err := snapshot.Update(s.snapshotClient, volumeSnapshot); err != nil {
+		return fmt.Errorf("error removing the finalizer of VolumeSnapshot %s/%s: %v", volumeSnapshot.Namespace, volumeSnapshot.Name, err)
+	}
+	s.logger.Printf("VolumeSnapshot %s/%s is deleted", volumeSnapshot.Namespace, volumeSnapshot.Name)
+	return nil
+}
+
+func (s *SnapshotController) addSnapshotFinalizer(volumeSnapshot *snapshot.VolumeSnapshot) error {
+	volumeSnapshot = volumeSnapshot.DeepCopy()
+	volumeSnapshot.Finalizers = append(volumeSnapshot.Finalizers, snapshotFinalizer)
+	if _, err := snapshot.Update(s.snapshotClient, volumeSnapshot); err != nil {
+		return fmt.Errorf("error adding the finalizer of VolumeSnapshot %s/%s: %v", volumeSnapshot.Namespace, volumeSnapshot.Name, err)
+	}
+	return nil
+}
+
+func hasSnapshotFinalizer(volumeSnapshot *snapshot.VolumeSnapshot) bool {
+	for _, finalizer := range volumeSnapshot.Finalizers {
+		if finalizer == snapshotFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// setSnapshotError reports the error in the status of the VolumeSnapshot and returns it, so the operation is retried with a backoff
+func (s *SnapshotController) setSnapshotError(volumeSnapshot *snapshot.VolumeSnapshot, err error) error {
+	if volumeSnapshot.Status.Error == err.Error() {
+		return err
+	}
+	volumeSnapshot = volumeSnapshot.DeepCopy()
+	volumeSnapshot.Status.Error = err.Error()
+	if _, updateErr := snapshot.Update(s.snapshotClient, volumeSnapshot); updateErr != nil {
+		s.logger.Printf("Failed to update the status of VolumeSnapshot %s/%s: %v", volumeSnapshot.Namespace, volumeSnapshot.Name, updateErr)
+	}
+	return err
+}
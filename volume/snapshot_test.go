@@ -0,0 +1,154 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	"github.com/IBM/ubiquity-k8s/snapshot"
+	"github.com/IBM/ubiquity-k8s/volume"
+	"github.com/IBM/ubiquity/fakes"
+	"github.com/IBM/ubiquity/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+type fakeSnapshottingClient struct {
+	*fakes.FakeStorageClient
+	createSnapshotRequests []k8sresources.CreateSnapshotRequest
+	createSnapshotErr      error
+	deleteSnapshotRequests []k8sresources.DeleteSnapshotRequest
+	deleteSnapshotErr      error
+}
+
+func (c *fakeSnapshottingClient) CreateSnapshot(createSnapshotRequest k8sresources.CreateSnapshotRequest) error {
+	c.createSnapshotRequests = append(c.createSnapshotRequests, createSnapshotRequest)
+	return c.createSnapshotErr
+}
+
+func (c *fakeSnapshottingClient) DeleteSnapshot(deleteSnapshotRequest k8sresources.DeleteSnapshotRequest) error {
+	c.deleteSnapshotRequests = append(c.deleteSnapshotRequests, deleteSnapshotRequest)
+	return c.deleteSnapshotErr
+}
+
+func (c *fakeSnapshottingClient) RestoreSnapshot(restoreSnapshotRequest k8sresources.RestoreSnapshotRequest) error {
+	return nil
+}
+
+var _ = Describe("SnapshotController", func() {
+	var (
+		fakeClient      *fakeSnapshottingClient
+		server          *httptest.Server
+		volumeSnapshots map[string]*snapshot.VolumeSnapshot
+		volumeSnapshot  *snapshot.VolumeSnapshot
+		controller      *volume.SnapshotController
+	)
+	BeforeEach(func() {
+		fakeClient = &fakeSnapshottingClient{FakeStorageClient: new(fakes.FakeStorageClient)}
+		volumeSnapshot = &snapshot.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "snap1", Namespace: "default", UID: "uid1"},
+			Spec:       snapshot.VolumeSnapshotSpec{PersistentVolumeClaimName: "claim1"},
+		}
+		volumeSnapshots = map[string]*snapshot.VolumeSnapshot{"snap1": volumeSnapshot}
+		// the API server of the VolumeSnapshot CRD, it only gets and updates
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := path.Base(r.URL.Path)
+			if r.Method == "PUT" {
+				var updated snapshot.VolumeSnapshot
+				Expect(json.NewDecoder(r.Body).Decode(&updated)).To(Succeed())
+				volumeSnapshots[name] = &updated
+			}
+			stored, ok := volumeSnapshots[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			stored.APIVersion, stored.Kind = snapshot.SchemeGroupVersion.String(), snapshot.VolumeSnapshotKind
+			w.Header().Set("Content-Type", "application/json")
+			Expect(json.NewEncoder(w).Encode(stored)).To(Succeed())
+		}))
+		snapshotClient, err := snapshot.NewClient(&rest.Config{Host: server.URL})
+		Expect(err).ToNot(HaveOccurred())
+
+		pv := newUbiquityPV("pv1", "pv1")
+		pv.Spec.FlexVolume.Options["backend"] = resources.SCBE
+		pv.Spec.Capacity = v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")}
+		claim := newClaim("claim1", "1Gi", nil)
+		claim.Spec.VolumeName = "pv1"
+		claim.Status.Phase = v1.ClaimBound
+		controller = volume.NewSnapshotController(testLogger, k8sfake.NewSimpleClientset(pv, claim), snapshotClient, fakeClient)
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("takes the snapshot of a VolumeSnapshot with the finalizer", func() {
+		Expect(controller.CreateSnapshot(volumeSnapshot)).To(Succeed())
+		Expect(fakeClient.createSnapshotRequests).To(Equal([]k8sresources.CreateSnapshotRequest{{Name: "snapshot-uid1", VolumeName: "pv1", Backend: resources.SCBE}}))
+		Expect(volumeSnapshots["snap1"].Finalizers).To(Equal([]string{"ubiquity.ibm.com/volume-snapshot"}))
+		Expect(volumeSnapshots["snap1"].Status.Ready).To(BeTrue())
+		Expect(volumeSnapshots["snap1"].Status.SnapshotName).To(Equal("snapshot-uid1"))
+	})
+	It("takes a snapshot that already exists as the one of a previous attempt", func() {
+		fakeClient.createSnapshotErr = fmt.Errorf("snapshot snapshot-uid1 already exists")
+		Expect(controller.CreateSnapshot(volumeSnapshot)).To(Succeed())
+		Expect(volumeSnapshots["snap1"].Status.Ready).To(BeTrue())
+		Expect(volumeSnapshots["snap1"].Status.Error).To(BeEmpty())
+	})
+	It("keeps the finalizer and the snapshot name when the snapshot fails", func() {
+		fakeClient.createSnapshotErr = fmt.Errorf("pool pool1 is full")
+		Expect(controller.CreateSnapshot(volumeSnapshot)).ToNot(Succeed())
+		Expect(volumeSnapshots["snap1"].Finalizers).To(HaveLen(1))
+		Expect(volumeSnapshots["snap1"].Status.Ready).To(BeFalse())
+		Expect(volumeSnapshots["snap1"].Status.SnapshotName).To(Equal("snapshot-uid1"))
+		Expect(volumeSnapshots["snap1"].Status.Error).To(ContainSubstring("pool pool1 is full"))
+	})
+
+	Context("deleted", func() {
+		BeforeEach(func() {
+			now := metav1.Now()
+			volumeSnapshot.DeletionTimestamp = &now
+			volumeSnapshot.Finalizers = []string{"ubiquity.ibm.com/volume-snapshot"}
+			volumeSnapshot.Status = snapshot.VolumeSnapshotStatus{Ready: true, SnapshotName: "snapshot-uid1", SourceVolumeName: "pv1", Backend: resources.SCBE}
+		})
+		It("deletes the snapshot and then removes the finalizer", func() {
+			Expect(controller.DeleteSnapshot(volumeSnapshot)).To(Succeed())
+			Expect(fakeClient.deleteSnapshotRequests).To(Equal([]k8sresources.DeleteSnapshotRequest{{Name: "snapshot-uid1", VolumeName: "pv1", Backend: resources.SCBE}}))
+			Expect(volumeSnapshots["snap1"].Finalizers).To(BeEmpty())
+		})
+		It("keeps the finalizer when the delete fails, to retry it", func() {
+			fakeClient.deleteSnapshotErr = fmt.Errorf("the ubiquity server is unavailable")
+			Expect(controller.DeleteSnapshot(volumeSnapshot)).ToNot(Succeed())
+			Expect(volumeSnapshots["snap1"].Finalizers).To(HaveLen(1))
+		})
+		It("removes the finalizer of a snapshot already gone", func() {
+			fakeClient.deleteSnapshotErr = fmt.Errorf("snapshot snapshot-uid1 not found")
+			Expect(controller.DeleteSnapshot(volumeSnapshot)).To(Succeed())
+			Expect(volumeSnapshots["snap1"].Finalizers).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,107 @@
+/**
+ * Copyright 2018 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+
+	k8sresources "github.com/IBM/ubiquity-k8s/resources"
+	k8sutils "github.com/IBM/ubiquity-k8s/utils"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// A PVC annotation naming the node of its first pod, the scheduler sets it when the storage class binds on WaitForFirstConsumer
+	annSelectedNode = "volume.kubernetes.io/selected-node"
+
+	// A PV annotation of the nodes the volume is reachable from. It stands for
+	// PV.Spec.NodeAffinity, which the vendored k8s API (release-1.8) does not have yet.
+	annNodeAffinity = "volume.alpha.kubernetes.io/node-affinity"
+)
+
+// getAllowedTopologies returns the topologies the storage class allows, none when it allows all
+func getAllowedTopologies(parameters map[string]string) ([]k8sresources.TopologySelectorTerm, error) {
+	value, ok := parameters[k8sresources.ParameterAllowedTopologies]
+	if !ok {
+		return nil, nil
+	}
+	return k8sutils.ParseAllowedTopologies(value)
+}
+
+// selectTopology returns the configured topology of the new volume, reachable from the selected node of its claim and allowed by its storage class, nil without configured topologies
+func (p *flexProvisioner) selectTopology(options controller.VolumeOptions, allowedTopologies []k8sresources.TopologySelectorTerm) (*k8sresources.TopologyConfig, error) {
+	if len(p.topologies) == 0 {
+		return nil, nil
+	}
+	nodeLabels, err := p.getSelectedNodeLabels(options.PVC)
+	if err != nil {
+		return nil, err
+	}
+	topology, err := k8sutils.SelectTopology(p.topologies, options.Parameters, nodeLabels, allowedTopologies)
+	if err != nil {
+		return nil, fmt.Errorf("cannot provision claim %s/%s: %v", options.PVC.Namespace, options.PVC.Name, err)
+	}
+	p.logger.Printf("provisioning volume %s in the topology of backend %s with labels %v", options.PVName, topology.Backend, topology.Labels)
+	return topology, nil
+}
+
+// getSelectedNodeLabels returns the labels of the node selected for the claim, nil when none was
+func (p *flexProvisioner) getSelectedNodeLabels(claim *v1.PersistentVolumeClaim) (map[string]string, error) {
+	nodeName, ok := claim.Annotations[annSelectedNode]
+	if !ok {
+		return nil, nil
+	}
+	if p.kubeClient == nil {
+		return nil, fmt.Errorf("cannot get the selected node %s, the provisioner has no kubernetes client", nodeName)
+	}
+	node, err := p.kubeClient.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting the selected node %s: %v", nodeName, err)
+	}
+	if node.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return node.Labels, nil
+}
+
+// nodeAffinityAnnotation returns the annNodeAffinity of a volume reachable from the nodes matching any of terms
+func nodeAffinityAnnotation(terms []k8sresources.TopologySelectorTerm) (string, error) {
+	var nodeSelectorTerms []v1.NodeSelectorTerm
+	for _, term := range terms {
+		var nodeSelectorTerm v1.NodeSelectorTerm
+		for _, expression := range term.MatchLabelExpressions {
+			nodeSelectorTerm.MatchExpressions = append(nodeSelectorTerm.MatchExpressions, v1.NodeSelectorRequirement{
+				Key:      expression.Key,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   expression.Values,
+			})
+		}
+		nodeSelectorTerms = append(nodeSelectorTerms, nodeSelectorTerm)
+	}
+	affinity := v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: nodeSelectorTerms},
+	}
+	value, err := json.Marshal(affinity)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}